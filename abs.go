@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+const absFunc = "abs"
+
+func isAbs(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == absFunc
+}
+
+func validateAbs(call *cc.Expr) error {
+	if len(call.List) != 1 {
+		return fmt.Errorf("%s() expects 1 argument, got %d", absFunc, len(call.List))
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+// checkAbsField checks the field of abs() is a signed integer, which is not a
+// bitfield.
+func checkAbsField(ast astInfo) error {
+	if IsMemberBitfield(ast.member) {
+		return fmt.Errorf("cannot %s() bitfield '%s'", absFunc, ast.member.Name)
+	}
+
+	intType, ok := mybtf.UnderlyingType(ast.lastField).(*btf.Int)
+	if !ok || intType.Encoding != btf.Signed {
+		return fmt.Errorf("%s() expects signed integer, got %s", absFunc, ast.lastField)
+	}
+
+	return nil
+}
+
+// abs2insns computes the absolute value of the signed integer of size bytes in
+// reg in place without branch, as (x ^ (x >> 63)) - (x >> 63) after sign
+// extending reg to 64 bits. R2 is used as scratch register.
+func abs2insns(insns asm.Instructions, size int, reg asm.Register) asm.Instructions {
+	if size < 8 {
+		shift := int32(64 - size*8)
+		insns = append(insns,
+			asm.LSh.Imm(reg, shift),  // reg <<= shift
+			asm.ArSh.Imm(reg, shift), // reg s>>= shift
+		)
+	}
+
+	return append(insns,
+		asm.Mov.Reg(asm.R2, reg), // r2 = reg
+		asm.ArSh.Imm(asm.R2, 63), // r2 s>>= 63
+		asm.Xor.Reg(reg, asm.R2), // reg ^= r2
+		asm.Sub.Reg(reg, asm.R2), // reg -= r2
+	)
+}
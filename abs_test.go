@@ -0,0 +1,72 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestAbs2insns(t *testing.T) {
+	for _, tt := range []struct {
+		v    uint64
+		size int
+		exp  uint64
+	}{
+		{0, 4, 0},
+		{10, 4, 10},
+		{0xFFFFFFF6, 4, 10},         // -10
+		{0x80000000, 4, 0x80000000}, // INT_MIN
+		{0xFFFF, 2, 1},              // -1
+		{0x7F, 1, 0x7F},
+		{^uint64(9), 8, 10}, // -10
+	} {
+		regs := map[asm.Register]uint64{asm.R3: tt.v}
+		runALU64(t, abs2insns(nil, tt.size, asm.R3), regs)
+		test.AssertEqual(t, regs[asm.R3], tt.exp)
+	}
+}
+
+func TestCompileAbs(t *testing.T) {
+	t.Run("abs(skb->skb_iif) > 10", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "abs(skb->skb_iif) > 10", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[1:2], asm.Instructions{
+			asm.Add.Imm(asm.R3, 148),
+		})
+		test.AssertEqualSlice(t, insns[7:len(insns)-4], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.ArSh.Imm(asm.R3, 32),
+			asm.Mov.Reg(asm.R2, asm.R3),
+			asm.ArSh.Imm(asm.R2, 63),
+			asm.Xor.Reg(asm.R3, asm.R2),
+			asm.Sub.Reg(asm.R3, asm.R2),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-4:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 10, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("unsigned field", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "abs(skb->mark) > 10", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "abs(skb->skb_iif, skb->len) > 10", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/Asphaltt/mybtf"
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
 )
 
 type AccessOptions struct {
@@ -24,30 +25,107 @@ type AccessResult struct {
 	Insns     asm.Instructions
 	LastField btf.Type
 	LabelUsed bool
+	Reg       asm.Register // register holding the read value
 }
 
 func Access(opts AccessOptions) (AccessResult, error) {
+	ast, err := parseAccess(opts)
+	if err != nil {
+		return AccessResult{}, err
+	}
+
+	return access(ast, opts)
+}
+
+// ReadField generates only the instructions to read the member accessed by the
+// expression like skb->dev->ifindex into the Dst register, without any
+// comparison or return, so that the value can be reused by the following
+// custom instructions. Unlike Access, the value is in host byte order and the
+// signed one is sign-extended to 64 bits, e.g. skb->protocol is 0x800 for IPv4.
+// The read failure jumps to LabelExit, which has to be defined by the caller
+// if LabelUsed.
+func ReadField(opts AccessOptions) (AccessResult, error) {
+	ast, err := parseAccess(opts)
+	if err != nil {
+		return AccessResult{}, err
+	}
+
+	return accessValue(ast, opts)
+}
+
+// parseAccess parses and validates the expression of the access options.
+func parseAccess(opts AccessOptions) (*cc.Expr, error) {
 	if opts.Expr == "" || opts.Type == nil || opts.LabelExit == "" {
-		return AccessResult{}, fmt.Errorf("invalid options")
+		return nil, fmt.Errorf("invalid options")
+	}
+
+	if opts.LabelExit == labelReturn {
+		return nil, fmt.Errorf("exit label %s collides with the return label", opts.LabelExit)
 	}
 
 	ast, err := parse(opts.Expr)
 	if err != nil {
-		return AccessResult{}, fmt.Errorf("failed to compile expression %s: %w", opts.Expr, err)
+		return nil, fmt.Errorf("failed to compile expression %s: %w", opts.Expr, err)
 	}
 
 	err = validateLeftOperand(ast)
 	if err != nil {
-		return AccessResult{}, fmt.Errorf("expression is not struct/union member access: %w", err)
+		return nil, fmt.Errorf("expression is not struct/union member access: %w", err)
+	}
+
+	return ast, nil
+}
+
+// access generates the instructions to read the member accessed by the parsed
+// and validated expression.
+func access(ast *cc.Expr, opts AccessOptions) (AccessResult, error) {
+	res, _, err := accessInfo(ast, opts)
+	return res, err
+}
+
+// accessValue is like access but converts the big endian value to host byte
+// order, and sign-extends the signed field to 64 bits, so that the value is
+// compared as a 64-bit value, e.g. a negative s16 field is less than 0.
+func accessValue(ast *cc.Expr, opts AccessOptions) (AccessResult, error) {
+	res, info, err := accessInfo(ast, opts)
+	if err != nil {
+		return AccessResult{}, err
 	}
 
+	res.Insns, err = be2host(res.Insns, res.LastField, info.bigEndian, res.Reg)
+	if err != nil {
+		return AccessResult{}, err
+	}
+
+	if !isSignedType(res.LastField) {
+		return res, nil
+	}
+
+	bits := 0
+	if IsMemberBitfield(info.member) {
+		bits = int(info.member.BitfieldSize)
+	} else if size, err := btf.Sizeof(res.LastField); err == nil {
+		bits = size * 8
+	}
+	if bits > 0 && bits < 64 {
+		shift := int32(64 - bits)
+		res.Insns = append(res.Insns,
+			asm.LSh.Imm(res.Reg, shift),  // reg <<= shift
+			asm.ArSh.Imm(res.Reg, shift), // reg s>>= shift
+		)
+	}
+
+	return res, nil
+}
+
+func accessInfo(ast *cc.Expr, opts AccessOptions) (AccessResult, astInfo, error) {
 	offsets, err := expr2offset(ast, opts.Type)
 	if err != nil {
-		return AccessResult{}, fmt.Errorf("failed to convert expression to offsets: %w", err)
+		return AccessResult{}, astInfo{}, fmt.Errorf("failed to convert expression to offsets: %w", err)
 	}
 
 	if len(offsets.offsets) == 0 {
-		return AccessResult{}, fmt.Errorf("expr should be struct/union member access")
+		return AccessResult{}, astInfo{}, fmt.Errorf("expr should be struct/union member access")
 	}
 
 	var size int
@@ -58,7 +136,7 @@ func Access(opts AccessOptions) (AccessResult, error) {
 	} else {
 		size, err = checkLastField(offsets.member, offsets.lastField)
 		if err != nil {
-			return AccessResult{}, err
+			return AccessResult{}, astInfo{}, err
 		}
 	}
 
@@ -66,9 +144,14 @@ func Access(opts AccessOptions) (AccessResult, error) {
 	if opts.Src != asm.R3 {
 		insns = append(insns, asm.Mov.Reg(asm.R3, opts.Src))
 	}
+	n := len(insns)
 	insns, labelUsed := offset2insns(insns, offsets.offsets, opts.Dst, opts.LabelExit, isArr)
+	userRead2insns(insns[n:], offsets.userReads)
+	if offsets.bigEndian && !IsMemberBitfield(offsets.member) {
+		insns = narrowLastLoad(insns, size)
+	}
 
-	tgt := tgtInfo{0, offsets.lastField, size, offsets.bigEndian}
+	tgt := tgtInfo{typ: offsets.lastField, sizof: size, bigEndian: offsets.bigEndian}
 	if IsMemberBitfield(offsets.member) {
 		insns, _ = bitfield2insns(insns, tgt.constant, offsets.member, opts.Dst)
 	} else {
@@ -79,5 +162,6 @@ func Access(opts AccessOptions) (AccessResult, error) {
 		Insns:     insns,
 		LastField: offsets.lastField,
 		LabelUsed: labelUsed,
-	}, nil
+		Reg:       opts.Dst,
+	}, offsets, nil
 }
@@ -18,6 +18,16 @@ func TestAccess(t *testing.T) {
 		test.AssertEqual(t, err.Error(), "invalid options")
 	})
 
+	t.Run("colliding labels", func(t *testing.T) {
+		_, err := Access(AccessOptions{
+			Expr:      "skb->len",
+			Type:      getSkbBtf(t),
+			LabelExit: labelReturn,
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "exit label "+labelReturn+" collides with the return label")
+	})
+
 	t.Run("failed to parse", func(t *testing.T) {
 		_, err := Access(AccessOptions{
 			Expr:      "a)(test)",
@@ -177,3 +187,63 @@ func TestAccess(t *testing.T) {
 		})
 	})
 }
+
+func TestReadField(t *testing.T) {
+	res, err := ReadField(AccessOptions{
+		Expr:      "skb->dev->ifindex",
+		Type:      getSkbBtf(t),
+		Src:       asm.R1,
+		Dst:       asm.R5,
+		LabelExit: labelExitFail,
+	})
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, res.Reg, asm.R5)
+	test.AssertTrue(t, res.LabelUsed)
+
+	insns := res.Insns
+	test.AssertEqualSlice(t, insns[len(insns)-6:], asm.Instructions{
+		asm.FnProbeReadKernel.Call(),
+		asm.LoadMem(asm.R5, asm.RFP, -8, asm.DWord),
+		asm.LSh.Imm(asm.R5, 32),
+		asm.RSh.Imm(asm.R5, 32),
+		asm.LSh.Imm(asm.R5, 32),
+		asm.ArSh.Imm(asm.R5, 32),
+	})
+
+	var reads int
+	for _, ins := range insns {
+		test.AssertTrue(t, ins.OpCode.JumpOp() != asm.Exit)
+		test.AssertTrue(t, ins.Reference() != labelReturn)
+		if ins.IsBuiltinCall() {
+			reads++
+		}
+	}
+	test.AssertEqual(t, reads, 2)
+}
+
+func TestReadFieldHostOrder(t *testing.T) {
+	res, err := ReadField(AccessOptions{
+		Expr:      "skb->protocol",
+		Type:      getSkbBtf(t),
+		Src:       asm.R1,
+		Dst:       asm.R3,
+		LabelExit: labelExitFail,
+	})
+	test.AssertNoErr(t, err)
+
+	// Unlike Access, the big endian value is swapped to host byte order.
+	insns := res.Insns
+	test.AssertEqualSlice(t, insns[len(insns)-1:], asm.Instructions{
+		asm.HostTo(asm.BE, asm.R3, asm.Half),
+	})
+
+	raw, err := Access(AccessOptions{
+		Expr:      "skb->protocol",
+		Type:      getSkbBtf(t),
+		Src:       asm.R1,
+		Dst:       asm.R3,
+		LabelExit: labelExitFail,
+	})
+	test.AssertNoErr(t, err)
+	test.AssertEqualSlice(t, insns[:len(insns)-1], raw.Insns)
+}
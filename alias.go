@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// FieldAliases maps the members renamed across kernel versions, keyed by the
+// struct/union name and the member name like "task_struct.state", to the other
+// member name like "__state". An alias is used only if the member is not found
+// by its own name, so that the same expression compiles against the BTF of
+// both old and new kernels.
+type FieldAliases map[string]string
+
+// DefaultFieldAliases is the aliases of the known renamed members, which is
+// used if CompileOptions.FieldAliases is nil. Clone it to extend it.
+var DefaultFieldAliases = FieldAliases{
+	"task_struct.state":   "__state", // renamed in v5.14
+	"task_struct.__state": "state",
+	"inode.i_ctime":       "__i_ctime", // renamed in v6.6
+	"inode.__i_ctime":     "i_ctime",
+}
+
+// resolve returns the alias of the member if it's not found in the
+// struct/union typ, or the name itself.
+func (a FieldAliases) resolve(typ btf.Type, name string) string {
+	var typName string
+	switch v := typ.(type) {
+	case *btf.Struct:
+		if _, err := mybtf.FindStructMember(v, name); err == nil {
+			return name
+		}
+		typName = v.Name
+	case *btf.Union:
+		if _, err := mybtf.FindUnionMember(v, name); err == nil {
+			return name
+		}
+		typName = v.Name
+	default:
+		return name
+	}
+
+	if alias, ok := a[typName+"."+name]; ok {
+		return alias
+	}
+
+	return name
+}
+
+// fieldAliases returns the aliases to resolve the renamed members.
+func (opts *CompileOptions) fieldAliases() FieldAliases {
+	if opts.FieldAliases != nil {
+		return opts.FieldAliases
+	}
+
+	return DefaultFieldAliases
+}
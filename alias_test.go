@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func getTaskBtf(t *testing.T) *btf.Pointer {
+	task, err := testBtf.AnyTypeByName("task_struct")
+	test.AssertNoErr(t, err)
+	return &btf.Pointer{Target: task}
+}
+
+func TestFieldAliasesResolve(t *testing.T) {
+	task := getTaskBtf(t).Target
+
+	test.AssertEqual(t, DefaultFieldAliases.resolve(task, "state"), "__state")
+	test.AssertEqual(t, DefaultFieldAliases.resolve(task, "__state"), "__state")
+	test.AssertEqual(t, DefaultFieldAliases.resolve(task, "pid"), "pid")
+	test.AssertEqual(t, DefaultFieldAliases.resolve(task, "xxx"), "xxx")
+	test.AssertEqual(t, DefaultFieldAliases.resolve(getU64Btf(t), "state"), "state")
+
+	var aliases FieldAliases
+	test.AssertEqual(t, aliases.resolve(task, "state"), "state")
+}
+
+func TestCompileFieldAliases(t *testing.T) {
+	t.Run("task->state == 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "task->state == 0", Type: getTaskBtf(t)})
+		test.AssertNoErr(t, err)
+
+		want, err := Compile(CompileOptions{Expr: "task->__state == 0", Type: getTaskBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, want.Insns)
+	})
+
+	t.Run("extended", func(t *testing.T) {
+		aliases := maps.Clone(DefaultFieldAliases)
+		aliases["sk_buff.length"] = "len"
+
+		res, err := Compile(CompileOptions{Expr: "skb->length > 1024", Type: getSkbBtf(t), FieldAliases: aliases})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("alias not found", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:         "skb->xmit_more == 1",
+			Type:         getSkbBtf(t),
+			FieldAliases: FieldAliases{"sk_buff.xmit_more": "__xmit_more"},
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(skb->xmit_more == 1): failed to convert expr to access offsets: failed to find member __xmit_more of sk_buff")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "task->state == 0", Type: getTaskBtf(t), FieldAliases: FieldAliases{}})
+		test.AssertHaveErr(t, err)
+	})
+}
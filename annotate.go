@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/cilium/ebpf/asm"
+)
+
+// annotateReads attaches the member access expressions as the sources of the
+// instructions reading them, i.e. the bpf_probe_read_kernel() calls, the
+// bpf_probe_read_user() calls of the __user pointers or the direct loads, so
+// that the verifier errors at the instructions can be mapped back to the
+// expressions.
+func annotateReads(insns asm.Instructions, paths []string) {
+	k := 0
+	for i := range insns {
+		if k == len(paths) {
+			return
+		}
+
+		ins := &insns[i]
+		isRead := ins.IsBuiltinCall() && (ins.Constant == int64(asm.FnProbeReadKernel) || ins.Constant == int64(asm.FnProbeReadUser))
+		isLoad := ins.OpCode.Class().IsLoad() && ins.OpCode.Mode() == asm.MemMode && ins.Src == asm.R3
+		if isRead || isLoad {
+			insns[i] = ins.WithSource(asm.Comment("read " + paths[k]))
+			k++
+		}
+	}
+}
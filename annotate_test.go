@@ -0,0 +1,63 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestAnnotate(t *testing.T) {
+	sources := func(t *testing.T, opts CompileOptions) map[int]string {
+		res, err := Compile(opts)
+		test.AssertNoErr(t, err)
+
+		srcs := make(map[int]string)
+		for i, insn := range res.Insns {
+			if src := insn.Source(); src != nil {
+				srcs[i] = src.String()
+			}
+		}
+		return srcs
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		srcs := sources(t, CompileOptions{Expr: "skb->dev->ifindex == 9", Type: getSkbBtf(t)})
+		test.AssertEqual(t, len(srcs), 0)
+	})
+
+	t.Run("probe read", func(t *testing.T) {
+		srcs := sources(t, CompileOptions{Expr: "skb->dev->ifindex == 9", Type: getSkbBtf(t), Annotate: true})
+		test.AssertEqual(t, len(srcs), 2)
+		test.AssertEqual(t, srcs[5], "read skb->dev")
+		test.AssertEqual(t, srcs[12], "read skb->dev->ifindex")
+	})
+
+	t.Run("user read", func(t *testing.T) {
+		srcs := sources(t, CompileOptions{Expr: "s->uptr->val == 1", Type: getUserBtf(), Annotate: true})
+		test.AssertEqual(t, len(srcs), 2)
+		test.AssertEqual(t, srcs[4], "read s->uptr")
+		test.AssertEqual(t, srcs[11], "read s->uptr->val")
+	})
+
+	t.Run("direct load", func(t *testing.T) {
+		srcs := sources(t, CompileOptions{Expr: "skb->dev->ifindex == 9", Type: getSkbBtf(t), Annotate: true, UseDirectLoad: true})
+		test.AssertEqual(t, len(srcs), 2)
+		test.AssertEqual(t, srcs[1], "read skb->dev")
+		test.AssertEqual(t, srcs[3], "read skb->dev->ifindex")
+	})
+
+	t.Run("embedded member", func(t *testing.T) {
+		srcs := sources(t, CompileOptions{Expr: "skb->dev->dev.kobj.state_initialized == 1", Type: getSkbBtf(t), Annotate: true})
+		test.AssertEqual(t, len(srcs), 2)
+		test.AssertEqual(t, srcs[12], "read skb->dev->dev.kobj.state_initialized")
+	})
+
+	t.Run("string", func(t *testing.T) {
+		srcs := sources(t, CompileOptions{Expr: `skb->dev->name == "lo"`, Type: getSkbBtf(t), Annotate: true})
+		test.AssertEqual(t, len(srcs), 1)
+		test.AssertEqual(t, srcs[5], "read skb->dev")
+	})
+}
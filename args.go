@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// ArgRoot is the root of the member access by an argument name like
+// arg2->field, whose pointer is in the register set by the surrounding
+// program.
+type ArgRoot struct {
+	// Reg is the register holding the pointer of the argument. It must be
+	// one of r6-r9, as r1-r5 are clobbered by the read helper.
+	Reg asm.Register
+
+	// Type is the btf type of the argument.
+	Type btf.Type
+}
+
+func validateArgs(args map[string]ArgRoot) error {
+	for name, arg := range args {
+		if arg.Reg < asm.R6 || arg.Reg > asm.R9 {
+			return fmt.Errorf("invalid register %s of argument %s; must be one of r6-r9", arg.Reg, name)
+		}
+		if arg.Type == nil {
+			return fmt.Errorf("type of argument %s is missing", name)
+		}
+	}
+
+	return nil
+}
+
+// rootName returns the name of the root of the member access like skb of
+// skb->dev->ifindex, or "" if it's not a member access.
+func rootName(expr *cc.Expr) string {
+	for expr != nil && (expr.Op == cc.Arrow || expr.Op == cc.Dot || expr.Op == cc.Index) {
+		expr = expr.Left
+	}
+
+	if expr == nil || expr.Op != cc.Name {
+		return ""
+	}
+	return expr.Text
+}
+
+// isMemberAccess reports whether the expression is a member access like
+// skb->len or arg2->cb[0] rooted at a name, which excludes the bare name like
+// an enum name or a constant name.
+func isMemberAccess(expr *cc.Expr) bool {
+	if expr == nil || (expr.Op != cc.Arrow && expr.Op != cc.Dot && expr.Op != cc.Index) {
+		return false
+	}
+	return rootName(expr) != ""
+}
+
+// argRoot returns the register and type of the root of the member access,
+// which is r1 and the type of the options if it's not an argument.
+func argRoot(expr *cc.Expr, opts CompileOptions) (asm.Register, btf.Type) {
+	if arg, ok := opts.Args[rootName(expr)]; ok {
+		return arg.Reg, arg.Type
+	}
+
+	return asm.R1, opts.Type
+}
+
+// compileArgs compiles the comparison of two member accesses of different
+// roots like skb->len > arg2->mtu, whose roots are looked up in the
+// arguments, see compareOperands.
+func compileArgs(expr *cc.Expr, opts CompileOptions) (asm.Instructions, error) {
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare member accesses with register %s", opts.CompareReg)
+	}
+
+	for _, operand := range []*cc.Expr{expr.Left, expr.Right} {
+		if err := validateLeftOperand(operand); err != nil {
+			return nil, err
+		}
+	}
+
+	root := func(operand *cc.Expr) (asm.Register, btf.Type) { return argRoot(operand, opts) }
+	return compareOperands(expr.Left, expr.Right, expr.Op, root, opts.failLabel())
+}
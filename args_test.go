@@ -0,0 +1,203 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestRootName(t *testing.T) {
+	for _, tt := range []struct {
+		expr, exp string
+	}{
+		{"skb->dev->ifindex", "skb"},
+		{"arg2->cb[0]", "arg2"},
+		{"(unsigned short)skb->len", ""},
+		{"1", ""},
+	} {
+		ast, err := parse(tt.expr)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, rootName(ast), tt.exp)
+	}
+}
+
+func TestIsMemberAccess(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		exp  bool
+	}{
+		{"skb->dev->ifindex", true},
+		{"arg2->cb[0]", true},
+		{"MARK_DROP", false},
+		{"1", false},
+	} {
+		ast, err := parse(tt.expr)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, isMemberAccess(ast), tt.exp)
+	}
+}
+
+func TestCompileArgs(t *testing.T) {
+	t.Run("a->len > b->skb_iif", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "a->len > b->skb_iif",
+			Type: getIphdrBtf(t),
+			Args: map[string]ArgRoot{
+				"a": {Reg: asm.R6, Type: getSkbBtf(t)},
+				"b": {Reg: asm.R7, Type: getSkbBtf(t)},
+			},
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:4], asm.Instructions{
+			asm.StoreMem(asm.R10, stackOffsetRoot, asm.R1, asm.DWord),
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+		})
+		test.AssertEqualSlice(t, insns[11:15], asm.Instructions{
+			asm.StoreMem(asm.R10, stackOffsetLeft, asm.R3, asm.DWord),
+			asm.Mov.Reg(asm.R1, asm.R7),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 148),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-5:], asm.Instructions{
+			asm.LoadMem(asm.R2, asm.R10, stackOffsetLeft, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Reg(asm.R2, asm.R3, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("iph->saddr == b->len", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "iph->saddr == b->len",
+			Type: getIphdrBtf(t),
+			Args: map[string]ArgRoot{"b": {Reg: asm.R7, Type: getSkbBtf(t)}},
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[1:3], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 12),
+		})
+		test.AssertEqualSlice(t, insns[10:13], asm.Instructions{
+			asm.HostTo(asm.BE, asm.R3, asm.Word),
+			asm.StoreMem(asm.R10, stackOffsetLeft, asm.R3, asm.DWord),
+			asm.Mov.Reg(asm.R1, asm.R7),
+		})
+	})
+
+	t.Run("b->len > 1024", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "b->len > 1024",
+			Type: getIphdrBtf(t),
+			Args: map[string]ArgRoot{"b": {Reg: asm.R7, Type: getSkbBtf(t)}},
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:3], asm.Instructions{
+			asm.Mov.Reg(asm.R1, asm.R7),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+		})
+	})
+
+	t.Run("signed a->skb_iif < b->skb_iif", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "a->skb_iif < b->skb_iif",
+			Type: getIphdrBtf(t),
+			Args: map[string]ArgRoot{
+				"a": {Reg: asm.R6, Type: getSkbBtf(t)},
+				"b": {Reg: asm.R7, Type: getSkbBtf(t)},
+			},
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-9:], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.ArSh.Imm(asm.R3, 32),
+			asm.LoadMem(asm.R2, asm.R10, stackOffsetLeft, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSLT.Reg(asm.R2, asm.R3, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("LabelFail", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      "a->dev->ifindex == b->skb_iif",
+			Type:      getIphdrBtf(t),
+			LabelFail: "next_filter",
+			Args: map[string]ArgRoot{
+				"a": {Reg: asm.R6, Type: getSkbBtf(t)},
+				"b": {Reg: asm.R7, Type: getSkbBtf(t)},
+			},
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[9:10], asm.Instructions{asm.JEq.Imm(asm.R3, 0, "next_filter")})
+		test.AssertEqualSlice(t, insns[len(insns)-2:len(insns)-1], asm.Instructions{asm.Xor.Reg(asm.R0, asm.R0)})
+	})
+
+	t.Run("invalid register", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr: "b->len > 1024",
+			Type: getIphdrBtf(t),
+			Args: map[string]ArgRoot{"b": {Reg: asm.R2, Type: getSkbBtf(t)}},
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid register r2 of argument b")
+	})
+
+	t.Run("unknown member", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr: "iph->saddr == b->xxx",
+			Type: getIphdrBtf(t),
+			Args: map[string]ArgRoot{"b": {Reg: asm.R7, Type: getSkbBtf(t)}},
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("enum right operand", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "prog->type == BPF_PROG_TYPE_KPROBE",
+			Type: getBpfProgBtf(t),
+			Args: map[string]ArgRoot{"b": {Reg: asm.R7, Type: getSkbBtf(t)}},
+		})
+		test.AssertNoErr(t, err)
+		n := len(res.Insns)
+		test.AssertEqualSlice(t, res.Insns[n-3:n-2], asm.Instructions{
+			asm.JEq.Imm(asm.R3, 2, labelReturn),
+		})
+	})
+
+	t.Run("constant right operand", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      "skb->mark == MARK_DROP",
+			Type:      getSkbBtf(t),
+			Args:      map[string]ArgRoot{"b": {Reg: asm.R7, Type: getSkbBtf(t)}},
+			Constants: map[string]uint64{"MARK_DROP": 0xdead},
+		})
+		test.AssertNoErr(t, err)
+		n := len(res.Insns)
+		test.AssertEqualSlice(t, res.Insns[n-3:n-2], asm.Instructions{
+			asm.JEq.Imm(asm.R3, 0xdead, labelReturn),
+		})
+	})
+}
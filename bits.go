@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+const (
+	bitsFunc = "bits"
+	byteFunc = "byte"
+)
+
+// bitRange is the inclusive range [lo, hi] of the bits to extract, whose bit 0
+// is the least significant bit of the value in host byte order.
+type bitRange struct {
+	lo, hi int
+}
+
+func isBits(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == bitsFunc
+}
+
+func validateBits(call *cc.Expr) error {
+	if len(call.List) != 3 {
+		return fmt.Errorf("%s() expects a field and a bit range like %s(*(unsigned int *)(skb + 4), 3, 7)", bitsFunc, bitsFunc)
+	}
+
+	if _, err := parseBitRange(call); err != nil {
+		return err
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+func parseBitRange(call *cc.Expr) (bitRange, error) {
+	var bits [2]uint64
+	for i, arg := range call.List[1:] {
+		if arg.Op != cc.Number {
+			return bitRange{}, fmt.Errorf("unexpected bit %v of %s(); must be constant number", arg, bitsFunc)
+		}
+
+		n, err := parseNumber(arg.Text)
+		if err != nil {
+			return bitRange{}, fmt.Errorf("failed to parse bit %s of %s(): %w", arg.Text, bitsFunc, err)
+		}
+		bits[i] = n
+	}
+
+	lo, hi := bits[0], bits[1]
+	if lo > hi || hi > 63 {
+		return bitRange{}, fmt.Errorf("invalid bit range [%d:%d] of %s(); must be in [0:63]", lo, hi, bitsFunc)
+	}
+
+	return bitRange{lo: int(lo), hi: int(hi)}, nil
+}
+
+// check checks the bit range is in the width of the read field of size bytes.
+func (r bitRange) check(size int) error {
+	if r.hi >= size*8 {
+		return fmt.Errorf("bit range [%d:%d] of %s() exceeds %d bits of the field", r.lo, r.hi, bitsFunc, size*8)
+	}
+
+	return nil
+}
+
+// width returns the number of the bits in the range.
+func (r bitRange) width() int {
+	return r.hi - r.lo + 1
+}
+
+// bits2insns extracts the bits in the range of reg in place, as
+// (reg << (63 - hi)) >> (63 - hi + lo).
+func bits2insns(insns asm.Instructions, r bitRange, reg asm.Register) asm.Instructions {
+	if shift := 63 - r.hi; shift != 0 {
+		insns = append(insns, asm.LSh.Imm(reg, int32(shift))) // reg <<= 63 - hi
+	}
+	if shift := 63 - r.hi + r.lo; shift != 0 {
+		insns = append(insns, asm.RSh.Imm(reg, int32(shift))) // reg >>= 63 - hi + lo
+	}
+
+	return insns
+}
+
+func isByte(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == byteFunc
+}
+
+func validateByte(call *cc.Expr) error {
+	if len(call.List) != 2 {
+		return fmt.Errorf("%s() expects a field and a byte index like %s(skb->protocol, 0)", byteFunc, byteFunc)
+	}
+
+	if _, err := parseByteIndex(call); err != nil {
+		return err
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+func parseByteIndex(call *cc.Expr) (int, error) {
+	arg := call.List[1]
+	if arg.Op != cc.Number {
+		return 0, fmt.Errorf("unexpected byte index %v of %s(); must be constant number", arg, byteFunc)
+	}
+
+	n, err := parseNumber(arg.Text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse byte index %s of %s(): %w", arg.Text, byteFunc, err)
+	}
+	if n > 7 {
+		return 0, fmt.Errorf("byte index %d of %s() is out of range [0, 7]", n, byteFunc)
+	}
+
+	return int(n), nil
+}
+
+// byteRange returns the bit range of the byte n in memory of the field of size
+// bytes, whose value is in the target byte order. The byte 0 is the least
+// significant byte only if neither the field nor the target is big endian,
+// e.g. byte(skb->protocol, 0) is 0x08 of ETH_P_IP in network byte order.
+func byteRange(n, size int, bigEndian, targetBE bool) (bitRange, error) {
+	if n >= size {
+		return bitRange{}, fmt.Errorf("byte index %d of %s() exceeds %d bytes of the field", n, byteFunc, size)
+	}
+
+	if bigEndian || targetBE {
+		n = size - 1 - n
+	}
+
+	return bitRange{lo: n * 8, hi: n*8 + 7}, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateBits(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		err  string
+	}{
+		{"bits(*(unsigned int *)(skb + 4), 3) == 1", "bits() expects a field and a bit range"},
+		{"bits(*(unsigned int *)(skb + 4), lo, 7) == 1", "unexpected bit lo of bits()"},
+		{"bits(*(unsigned int *)(skb + 4), 7, 3) == 1", "invalid bit range [7:3] of bits()"},
+		{"bits(*(unsigned int *)(skb + 4), 3, 64) == 1", "invalid bit range [3:64] of bits()"},
+	} {
+		expr, err := parse(tt.expr)
+		test.AssertNoErr(t, err)
+
+		err = validate(expr)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), tt.err)
+	}
+
+	expr, err := parse("bits(*(unsigned int *)(skb + 4), 3, 7) == 1")
+	test.AssertNoErr(t, err)
+	test.AssertNoErr(t, validate(expr))
+}
+
+func TestBits2insns(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		r    bitRange
+		exp  asm.Instructions
+	}{
+		{"[3:7]", bitRange{3, 7}, asm.Instructions{asm.LSh.Imm(asm.R3, 56), asm.RSh.Imm(asm.R3, 59)}},
+		{"[0:7]", bitRange{0, 7}, asm.Instructions{asm.LSh.Imm(asm.R3, 56), asm.RSh.Imm(asm.R3, 56)}},
+		{"[8:63]", bitRange{8, 63}, asm.Instructions{asm.RSh.Imm(asm.R3, 8)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			test.AssertEqualSlice(t, bits2insns(nil, tt.r, asm.R3), tt.exp)
+		})
+	}
+
+	test.AssertEmptySlice(t, bits2insns(nil, bitRange{0, 63}, asm.R3))
+}
+
+func TestCompileBits(t *testing.T) {
+	t.Run("bits(*(unsigned int *)(skb + 4), 3, 7) == 5", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "bits(*(unsigned int *)(skb + 4), 3, 7) == 5", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.LSh.Imm(asm.R3, 56),
+			asm.RSh.Imm(asm.R3, 59),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 5, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("exceeds read width", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "bits(*(unsigned short *)(skb + 4), 3, 16) == 5", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertTrue(t, strings.Contains(err.Error(), "bit range [3:16] of bits() exceeds 16 bits"))
+	})
+}
+
+func TestValidateByte(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		err  string
+	}{
+		{"byte(skb->protocol) == 8", "byte() expects a field and a byte index"},
+		{"byte(skb->protocol, n) == 8", "unexpected byte index n of byte()"},
+		{"byte(skb->protocol, 8) == 8", "byte index 8 of byte() is out of range"},
+		{"byte(skb->protocol(), 0) == 8", "unexpected function call"},
+	} {
+		expr, err := parse(tt.expr)
+		test.AssertNoErr(t, err)
+
+		err = validate(expr)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), tt.err)
+	}
+
+	expr, err := parse("byte(skb->protocol, 1) == 8")
+	test.AssertNoErr(t, err)
+	test.AssertNoErr(t, validate(expr))
+}
+
+func TestByteRange(t *testing.T) {
+	r, err := byteRange(0, 2, false, false)
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, r, bitRange{0, 7})
+
+	r, err = byteRange(0, 2, true, false)
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, r, bitRange{8, 15})
+
+	r, err = byteRange(1, 4, true, false)
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, r, bitRange{16, 23})
+
+	_, err = byteRange(2, 2, false, false)
+	test.AssertHaveErr(t, err)
+	test.AssertStrPrefix(t, err.Error(), "byte index 2 of byte() exceeds 2 bytes of the field")
+
+	r, err = byteRange(0, 2, false, true)
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, r, bitRange{8, 15})
+}
+
+func TestCompileByte(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		lsh  int32
+		val  int32
+	}{
+		{"byte(skb->protocol, 0) == 0x08", 48, 0x08},
+		{"byte(skb->protocol, 1) == 0", 56, 0},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: tt.expr, Type: getSkbBtf(t), ByteOrder: binary.LittleEndian})
+			test.AssertNoErr(t, err)
+
+			insns := res.Insns
+			test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+				asm.And.Imm(asm.R3, 0xffff),
+				asm.HostTo(asm.BE, asm.R3, asm.Half),
+				asm.LSh.Imm(asm.R3, tt.lsh),
+				asm.RSh.Imm(asm.R3, 56),
+				asm.Mov.Imm(asm.R0, 1),
+				asm.JEq.Imm(asm.R3, tt.val, labelReturn),
+				asm.Xor.Reg(asm.R0, asm.R0),
+				asm.Return().WithSymbol(labelReturn),
+			})
+		})
+	}
+
+	t.Run("exceeds field size", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "byte(skb->protocol, 2) == 0", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertTrue(t, strings.Contains(err.Error(), "byte index 2 of byte() exceeds 2 bytes of the field"))
+	})
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+// labelCond is the prefix of the labels of the conditions to skip to.
+const labelCond = "__cond_bice_filter"
+
+type condition struct {
+	expr string
+	or   bool
+}
+
+// FilterBuilder accumulates the conditions against the fixed root type, which
+// are combined from left to right like ((a && b) || c) without precedence.
+type FilterBuilder struct {
+	typ    btf.Type
+	conds  []condition
+	macros Macros
+}
+
+// NewFilterBuilder creates a FilterBuilder for the root type.
+func NewFilterBuilder(typ btf.Type) *FilterBuilder {
+	return &FilterBuilder{typ: typ}
+}
+
+// WithMacros sets the macros expanded in the conditions, like is_ipv4 for
+// skb->protocol == 0x0008.
+func (b *FilterBuilder) WithMacros(macros Macros) *FilterBuilder {
+	b.macros = macros
+	return b
+}
+
+// And appends the condition, which has to match together with the previous
+// ones.
+func (b *FilterBuilder) And(expr string) *FilterBuilder {
+	b.conds = append(b.conds, condition{expr: expr})
+	return b
+}
+
+// Or appends the condition, which matches if the previous ones don't.
+func (b *FilterBuilder) Or(expr string) *FilterBuilder {
+	b.conds = append(b.conds, condition{expr: expr, or: true})
+	return b
+}
+
+// skipTarget returns the index of the condition to skip to, once the verdict
+// is determined before condition i, i.e. the following condition of the other
+// operator, or len(conds) to return the verdict directly.
+func skipTarget(conds []condition, i int) int {
+	for j := i + 1; j < len(conds); j++ {
+		if conds[j].or != conds[i].or {
+			return j
+		}
+	}
+
+	return len(conds)
+}
+
+// Build compiles the conditions and combines their verdicts in r7 from left to
+// right. Once the verdict is determined, i.e. 0 before && or 1 before ||, the
+// following conditions of the same operator are skipped without any read.
+func (b *FilterBuilder) Build() (CompileResult, error) {
+	if b.typ == nil {
+		return CompileResult{}, fmt.Errorf("invalid type")
+	}
+	if len(b.conds) == 0 {
+		return CompileResult{}, fmt.Errorf("no conditions to build")
+	}
+	if len(b.conds) > maxSetFilters {
+		return CompileResult{}, fmt.Errorf("too many conditions %d; must be at most %d", len(b.conds), maxSetFilters)
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(ctxSaveReg, asm.R1), // r6 = r1
+	}
+
+	for i, cond := range b.conds {
+		res, err := Compile(CompileOptions{Expr: cond.expr, Type: b.typ, Macros: b.macros})
+		if err != nil {
+			return CompileResult{}, fmt.Errorf("failed to compile condition %d: %w", i, err)
+		}
+
+		block := relabel(res.Insns, i)
+		ret := block[len(block)-1]
+		if ret.OpCode.JumpOp() != asm.Exit {
+			return CompileResult{}, fmt.Errorf("unexpected last instruction %v of condition %d", ret, i)
+		}
+		block = block[:len(block)-1]
+
+		var acc asm.Instruction
+		switch {
+		case i == 0:
+			acc = asm.Mov.Reg(setAccReg, asm.R0) // r7 = r0
+		case cond.or:
+			acc = asm.Or.Reg(setAccReg, asm.R0) // r7 |= r0
+		default:
+			acc = asm.And.Reg(setAccReg, asm.R0) // r7 &= r0
+		}
+
+		if i != 0 {
+			target := setLabel(labelCond, skipTarget(b.conds, i))
+			jmp := asm.JEq.Imm(setAccReg, 0, target) // if r7 == 0, skip &&
+			if cond.or {
+				jmp = asm.JNE.Imm(setAccReg, 0, target) // if r7 != 0, skip ||
+			}
+
+			insns = append(insns,
+				jmp.WithSymbol(setLabel(labelCond, i)),
+				asm.Mov.Reg(asm.R1, ctxSaveReg), // r1 = r6
+			)
+		}
+
+		insns = append(insns, block...)
+		insns = append(insns, acc.WithMetadata(ret.Metadata))
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(asm.R0, setAccReg).WithSymbol(setLabel(labelCond, len(b.conds))), // r0 = r7
+		asm.Return().WithSymbol(labelReturn),                                         // return; __return
+	)
+
+	return CompileResult{Insns: insns}, nil
+}
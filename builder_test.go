@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+// runCombination emulates the combination of the constant verdicts, and
+// returns r0 and the number of executed instructions.
+func runCombination(t *testing.T, insns asm.Instructions) (uint64, int) {
+	t.Helper()
+
+	symbols := map[string]int{}
+	for i, ins := range insns {
+		if sym := ins.Symbol(); sym != "" {
+			symbols[sym] = i
+		}
+	}
+
+	regs := map[asm.Register]uint64{}
+	for pc, steps := 0, 1; pc < len(insns); pc, steps = pc+1, steps+1 {
+		ins := insns[pc]
+		switch ins.OpCode.JumpOp() {
+		case asm.Exit:
+			return regs[asm.R0], steps
+		case asm.Call:
+			t.Fatalf("unexpected read at %d", pc)
+		case asm.Ja:
+			pc = symbols[ins.Reference()] - 1
+		case asm.JEq, asm.JNE:
+			if (regs[ins.Dst] == uint64(ins.Constant)) == (ins.OpCode.JumpOp() == asm.JEq) {
+				pc = symbols[ins.Reference()] - 1
+			}
+		default:
+			runALU64(t, insns[pc:pc+1], regs)
+		}
+	}
+
+	t.Fatal("no exit")
+	return 0, 0
+}
+
+func TestFilterBuilder(t *testing.T) {
+	t.Run("skb->len > 1024 && skb->mark == 1", func(t *testing.T) {
+		res, err := NewFilterBuilder(getSkbBtf(t)).
+			And("skb->len > 1024").
+			And("skb->mark == 1").
+			Build()
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:1], asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+		})
+		test.AssertEqualSlice(t, insns[11:15], asm.Instructions{
+			asm.JGT.Imm(asm.R3, 1024, labelReturn+"_0"),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Mov.Reg(asm.R7, asm.R0).WithSymbol(labelReturn + "_0"),
+			asm.JEq.Imm(asm.R7, 0, labelCond+"_2").WithSymbol(labelCond + "_1"),
+		})
+		test.AssertEqualSlice(t, insns[15:17], asm.Instructions{
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Mov.Reg(asm.R3, asm.R1),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-5:], asm.Instructions{
+			asm.JEq.Imm(asm.R3, 1, labelReturn+"_1"),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.And.Reg(asm.R7, asm.R0).WithSymbol(labelReturn + "_1"),
+			asm.Mov.Reg(asm.R0, asm.R7).WithSymbol(labelCond + "_2"),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("combination", func(t *testing.T) {
+		const (
+			yes = "sizeof(skb->len) == 4"
+			no  = "sizeof(skb->len) == 8"
+		)
+
+		for _, tt := range []struct {
+			name  string
+			build func(b *FilterBuilder) *FilterBuilder
+			exp   uint64
+		}{
+			{"yes", func(b *FilterBuilder) *FilterBuilder { return b.And(yes) }, 1},
+			{"yes && yes", func(b *FilterBuilder) *FilterBuilder { return b.And(yes).And(yes) }, 1},
+			{"yes && no", func(b *FilterBuilder) *FilterBuilder { return b.And(yes).And(no) }, 0},
+			{"no && yes", func(b *FilterBuilder) *FilterBuilder { return b.And(no).And(yes) }, 0},
+			{"no || yes", func(b *FilterBuilder) *FilterBuilder { return b.And(no).Or(yes) }, 1},
+			{"no || no", func(b *FilterBuilder) *FilterBuilder { return b.And(no).Or(no) }, 0},
+			{"yes || no && no", func(b *FilterBuilder) *FilterBuilder { return b.Or(yes).Or(no).And(no) }, 0},
+			{"no && no || yes", func(b *FilterBuilder) *FilterBuilder { return b.And(no).And(no).Or(yes) }, 1},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				res, err := tt.build(NewFilterBuilder(getSkbBtf(t))).Build()
+				test.AssertNoErr(t, err)
+				r0, _ := runCombination(t, res.Insns)
+				test.AssertEqual(t, r0, tt.exp)
+			})
+		}
+	})
+
+	t.Run("return on first mismatch", func(t *testing.T) {
+		res, err := NewFilterBuilder(getSkbBtf(t)).
+			And("sizeof(skb->len) == 8").
+			And("skb->len > 1024").
+			And("skb->dev->ifindex == 1").
+			Build()
+		test.AssertNoErr(t, err)
+
+		// r6 = r1; r0 = 0; r7 = r0; if r7 == 0 goto end; r0 = r7; return
+		r0, steps := runCombination(t, res.Insns)
+		test.AssertEqual(t, r0, 0)
+		test.AssertEqual(t, steps, 6)
+	})
+
+	t.Run("return on first match", func(t *testing.T) {
+		res, err := NewFilterBuilder(getSkbBtf(t)).
+			And("sizeof(skb->len) == 4").
+			Or("skb->len > 1024").
+			Or("skb->dev->ifindex == 1").
+			Build()
+		test.AssertNoErr(t, err)
+
+		r0, steps := runCombination(t, res.Insns)
+		test.AssertEqual(t, r0, 1)
+		test.AssertEqual(t, steps, 6)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		_, err := NewFilterBuilder(nil).And("skb->len > 1").Build()
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "invalid type")
+	})
+
+	t.Run("no conditions", func(t *testing.T) {
+		_, err := NewFilterBuilder(getSkbBtf(t)).Build()
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "no conditions to build")
+	})
+
+	t.Run("invalid condition", func(t *testing.T) {
+		_, err := NewFilterBuilder(getSkbBtf(t)).And("skb->len > 1").Or("skb->xxx > 1").Build()
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile condition 1")
+	})
+}
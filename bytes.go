@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+// resolveJumps resolves the labels of the jump instructions to relative
+// offsets. The returned instructions have neither symbols nor references, so
+// that they can be placed anywhere.
+func resolveJumps(insns asm.Instructions) (asm.Instructions, error) {
+	symbols := make(map[string]asm.RawInstructionOffset)
+	for iter := insns.Iterate(); iter.Next(); {
+		sym := iter.Ins.Symbol()
+		if sym == "" {
+			continue
+		}
+
+		if _, ok := symbols[sym]; ok {
+			return nil, fmt.Errorf("duplicate symbol %s", sym)
+		}
+		symbols[sym] = iter.Offset
+	}
+
+	resolved := make(asm.Instructions, 0, len(insns))
+	for iter := insns.Iterate(); iter.Next(); {
+		ins := iter.Ins
+
+		offset := ins.Offset
+		if ref := ins.Reference(); ref != "" {
+			if !ins.OpCode.Class().IsJump() || ins.IsFunctionCall() {
+				return nil, fmt.Errorf("insn %d: unexpected reference to %s", iter.Index, ref)
+			}
+
+			target, ok := symbols[ref]
+			if !ok {
+				return nil, fmt.Errorf("insn %d: dangling reference to %s: %w", iter.Index, ref, ErrNotFound)
+			}
+
+			delta := int64(target) - int64(iter.Offset) - 1
+			if delta < math.MinInt16 || delta > math.MaxInt16 {
+				return nil, fmt.Errorf("insn %d: too far jump to %s", iter.Index, ref)
+			}
+			offset = int16(delta)
+		}
+
+		resolved = append(resolved, asm.Instruction{
+			OpCode:   ins.OpCode,
+			Dst:      ins.Dst,
+			Src:      ins.Src,
+			Offset:   offset,
+			Constant: ins.Constant,
+		}.WithSource(ins.Source()))
+	}
+
+	return resolved, nil
+}
+
+// CompileBytes compiles the simple C expression like Compile, and then encodes
+// the instructions to raw bytecode in the target byte order, which is able to
+// be embedded into a precompiled bpf program.
+func CompileBytes(opts CompileOptions) ([]byte, error) {
+	res, err := Compile(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	insns, err := resolveJumps(res.Insns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve jumps: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := insns.Marshal(&buf, opts.byteOrder()); err != nil {
+		return nil, fmt.Errorf("failed to marshal instructions: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestResolveJumps(t *testing.T) {
+	t.Run("resolve", func(t *testing.T) {
+		insns, err := resolveJumps(asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadImm(asm.R4, 0x1234567890, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Reg(asm.R3, asm.R4, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+		test.AssertNoErr(t, err)
+
+		jeq := asm.JEq.Imm(asm.R3, 0, "")
+		jeq.Offset = 2
+		jeqReg := asm.JEq.Reg(asm.R3, asm.R4, "")
+		jeqReg.Offset = 1
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadImm(asm.R4, 0x1234567890, asm.DWord),
+			jeq.WithMetadata(asm.Metadata{}),
+			asm.Mov.Imm(asm.R0, 1),
+			jeqReg.WithMetadata(asm.Metadata{}),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return(),
+		})
+	})
+
+	t.Run("dangling reference", func(t *testing.T) {
+		_, err := resolveJumps(asm.Instructions{
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "insn 0: dangling reference to "+labelExitFail)
+	})
+
+	t.Run("duplicate symbol", func(t *testing.T) {
+		_, err := resolveJumps(asm.Instructions{
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelReturn),
+			asm.Return().WithSymbol(labelReturn),
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "duplicate symbol")
+	})
+}
+
+func TestCompileBytes(t *testing.T) {
+	t.Run("failed to compile", func(t *testing.T) {
+		_, err := CompileBytes(CompileOptions{
+			Expr: "skb->xxx == 0",
+			Type: getSkbBtf(t),
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("skb->dev->ifindex == 9", func(t *testing.T) {
+		opts := CompileOptions{
+			Expr: "skb->dev->ifindex == 9",
+			Type: getSkbBtf(t),
+		}
+
+		b, err := CompileBytes(opts)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, len(b)%asm.InstructionSize, 0)
+
+		var decoded asm.Instructions
+		err = decoded.Unmarshal(bytes.NewReader(b), nativeEndian)
+		test.AssertNoErr(t, err)
+
+		res, err := Compile(opts)
+		test.AssertNoErr(t, err)
+		expected, err := resolveJumps(res.Insns)
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, decoded, expected)
+		test.AssertEqual(t, decoded[7].Offset, 10)
+		test.AssertEqual(t, decoded[17].Offset, 1)
+	})
+
+	t.Run("big-endian target", func(t *testing.T) {
+		opts := CompileOptions{
+			Expr:      "skb->dev->ifindex == 9",
+			Type:      getSkbBtf(t),
+			ByteOrder: binary.BigEndian,
+		}
+
+		b, err := CompileBytes(opts)
+		test.AssertNoErr(t, err)
+
+		var decoded asm.Instructions
+		err = decoded.Unmarshal(bytes.NewReader(b), binary.BigEndian)
+		test.AssertNoErr(t, err)
+
+		res, err := Compile(opts)
+		test.AssertNoErr(t, err)
+		expected, err := resolveJumps(res.Insns)
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, decoded, expected)
+	})
+}
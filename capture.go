@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+const (
+	// stackOffsetCompiled is the deepest stack offset used by the compiled
+	// filters, i.e. the bottom of the string buffer of maxStrSize bytes below
+	// the read buffer at r10 - 8. The saved registers, the map keys and the
+	// hash buffer are above it.
+	stackOffsetCompiled = -8 - maxStrSize
+
+	minCaptureSlot = -512                    // the bottom of the bpf stack
+	maxCaptureSlot = stackOffsetCompiled - 8 // below the stack of the compiled filters
+)
+
+func validateCaptureSlot(slot int16) error {
+	if slot < minCaptureSlot || slot > maxCaptureSlot || slot%8 != 0 {
+		return fmt.Errorf("invalid capture slot %d; must be 8-byte aligned in [%d, %d]", slot, minCaptureSlot, maxCaptureSlot)
+	}
+
+	return nil
+}
+
+// readCapture reads the member access expression of the root in r1 to r3 as a
+// 64-bit value, see accessValue, so that the captured value and the current
+// one of a signed field are compared as negative values too.
+func readCapture(insns asm.Instructions, expr string, typ btf.Type, labelExit string) (AccessResult, error) {
+	ast, err := parseOperand(expr)
+	if err != nil {
+		return AccessResult{}, fmt.Errorf("failed to access expression(%s): %w", expr, err)
+	}
+
+	res, err := accessValue(ast, AccessOptions{
+		Insns:     insns,
+		Type:      typ,
+		Src:       asm.R1,
+		Dst:       asm.R3,
+		LabelExit: labelExit,
+	})
+	if err != nil {
+		return AccessResult{}, fmt.Errorf("failed to access expression(%s): %w", expr, err)
+	}
+
+	return res, nil
+}
+
+// CaptureField compiles the snapshot of the member access expression, like
+// CaptureField("skb->len", skb, -272, "captured"), at the program entry. The
+// field of the root in r1 is read via bpf_probe_read_kernel() and stored to
+// the stack slot at r10 + slot in host byte order, sign-extended if signed,
+// for CompareToCapture later in the same program. The slot must be 8-byte
+// aligned in [-512, -272], which is below the stack used by the compiled
+// filters, so that it's kept across them.
+//
+// The slot is zeroed before reading, and it jumps to labelExit, which must be
+// defined by the caller, if failing to read. r1-r5 are clobbered.
+func CaptureField(expr string, typ btf.Type, slot int16, labelExit string) (asm.Instructions, error) {
+	if err := validateCaptureSlot(slot); err != nil {
+		return nil, err
+	}
+
+	var insns asm.Instructions
+	insns = append(insns,
+		asm.StoreImm(asm.R10, slot, 0, asm.DWord), // *(r10 + slot) = 0
+	)
+
+	res, err := readCapture(insns, expr, typ, labelExit)
+	if err != nil {
+		return nil, err
+	}
+
+	insns = append(res.Insns,
+		asm.StoreMem(asm.R10, slot, asm.R3, asm.DWord), // *(r10 + slot) = r3
+	)
+
+	return insns, nil
+}
+
+// CompareToCapture compiles the comparison of the current value of the member
+// access expression with the snapshot stored by CaptureField, like
+// CompareToCapture("skb->len", ">", skb, -272) matching if skb->len grows.
+//
+// The signedness of the comparison is determined by the expression.
+func CompareToCapture(expr, op string, typ btf.Type, slot int16) (asm.Instructions, error) {
+	return compareToCapture(expr, op, typ, slot, big.NewRat(1, 1))
+}
+
+// parseFactor parses the decimal factor like 1.5 to the ratio 3/2, whose
+// numerator and denominator must be positive 32-bit integers.
+func parseFactor(factor string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(factor)
+	if !ok {
+		return nil, fmt.Errorf("invalid factor %s", factor)
+	}
+
+	if r.Sign() <= 0 || !r.Num().IsInt64() || r.Num().Int64() > math.MaxInt32 || r.Denom().Int64() > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid factor %s; must be positive ratio of 32-bit integers", factor)
+	}
+
+	return r, nil
+}
+
+// CompareToCaptureScaled is like CompareToCapture but compares with the
+// snapshot scaled by the decimal factor, like CompareToCaptureScaled("skb->len",
+// ">", skb, -272, "1.5") matching if skb->len grows by more than 50%.
+//
+// The factor is applied as ratio without division, e.g. skb->len * 2 >
+// captured * 3 for 1.5, so the products must not overflow 64 bits.
+func CompareToCaptureScaled(expr, op string, typ btf.Type, slot int16, factor string) (asm.Instructions, error) {
+	r, err := parseFactor(factor)
+	if err != nil {
+		return nil, err
+	}
+
+	return compareToCapture(expr, op, typ, slot, r)
+}
+
+func compareToCapture(expr, op string, typ btf.Type, slot int16, factor *big.Rat) (asm.Instructions, error) {
+	exprOp, err := parseOperator(op)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCaptureSlot(slot); err != nil {
+		return nil, err
+	}
+
+	res, err := readCapture(nil, expr, typ, labelExitFail)
+	if err != nil {
+		return nil, err
+	}
+
+	isSigned := isSignedType(res.LastField)
+
+	// if r3 <op> r2, goto __return
+	jmpOpCode, err := op2jump(exprOp, isSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+	if res.LabelUsed {
+		xorR0 = xorR0.WithSymbol(labelExitFail)
+	}
+	insns := append(res.Insns,
+		asm.LoadMem(asm.R2, asm.R10, slot, asm.DWord), // r2 = *(r10 + slot)
+	)
+	if num := factor.Num().Int64(); num != 1 {
+		insns = append(insns, asm.Mul.Imm(asm.R2, int32(num))) // r2 *= num
+	}
+	if den := factor.Denom().Int64(); den != 1 {
+		insns = append(insns, asm.Mul.Imm(asm.R3, int32(den))) // r3 *= den
+	}
+	insns = append(insns,
+		asm.Mov.Imm(asm.R0, 1), // r0 = 1
+		jmpOpCode.Reg(asm.R3, asm.R2, labelReturn),
+		xorR0,                                // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
+
+// changedRegexp matches the predicate like changed(skb->mark).
+var changedRegexp = regexp.MustCompile(`^\s*changed\s*\(\s*(.+?)\s*\)\s*$`)
+
+// Changed compiles the edge-triggered predicate like changed(skb->mark) with
+// the generation stored in the map referenced by mapName, which must be an
+// array or per-CPU array map with u64 value. It reads the field, compares it
+// with the value at key 0, and matches if they differ, updating the value to
+// the field. So it persists across tail calls, unlike CaptureField.
+//
+// It mismatches if failing to read the field or to look up the map.
+func Changed(expr string, typ btf.Type, mapName string) (asm.Instructions, error) {
+	m := changedRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected predicate %s; must be changed(field)", expr)
+	}
+	if mapName == "" {
+		return nil, fmt.Errorf("map of predicate %s is missing", expr)
+	}
+
+	res, err := readCapture(nil, m[1], typ, labelExitFail)
+	if err != nil {
+		return nil, err
+	}
+
+	insns := append(res.Insns,
+		asm.StoreMem(asm.R10, stackOffsetRoot, asm.R3, asm.DWord), // *(r10 - 16) = r3
+		asm.StoreImm(asm.R10, stackOffsetScratchKey, 0, asm.Word), // *(u32 *)(r10 - 4) = 0
+		asm.LoadMapPtr(asm.R1, 0).WithReference(mapName),          // r1 = map
+		asm.Mov.Reg(asm.R2, asm.R10),                              // r2 = r10
+		asm.Add.Imm(asm.R2, stackOffsetScratchKey),                // r2 = r10 - 4; key
+		asm.FnMapLookupElem.Call(),                                // r0 = bpf_map_lookup_elem(r1, r2)
+		asm.JEq.Imm(asm.R0, 0, labelExitFail),                     // if r0 == 0, goto __exit
+		asm.Mov.Reg(asm.R1, asm.R0),                               // r1 = r0
+		asm.LoadMem(asm.R3, asm.R10, stackOffsetRoot, asm.DWord),  // r3 = *(r10 - 16)
+		asm.LoadMem(asm.R2, asm.R1, 0, asm.DWord),                 // r2 = *(u64 *)r1
+		asm.Mov.Imm(asm.R0, 0),                                    // r0 = 0; r0 ^= r0 is prohibited on the pointer
+		asm.JEq.Reg(asm.R3, asm.R2, labelReturn),                  // if r3 == r2, goto __return
+		asm.StoreMem(asm.R1, 0, asm.R3, asm.DWord),                // *(u64 *)r1 = r3
+		asm.Mov.Imm(asm.R0, 1),                                    // r0 = 1
+		asm.Ja.Label(labelReturn),                                 // goto __return
+		asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),     // r0 = 0; __exit
+		asm.Return().WithSymbol(labelReturn),                      // return; __return
+	)
+
+	return insns, nil
+}
@@ -0,0 +1,316 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateCaptureSlot(t *testing.T) {
+	test.AssertNoErr(t, validateCaptureSlot(-272))
+	test.AssertNoErr(t, validateCaptureSlot(-512))
+
+	for _, slot := range []int16{-8, 0, 8, -16, -24, -264, -276, -520} {
+		err := validateCaptureSlot(slot)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid capture slot")
+	}
+}
+
+func TestCaptureField(t *testing.T) {
+	t.Run("skb->len", func(t *testing.T) {
+		insns, err := CaptureField("skb->len", getSkbBtf(t), -272, "captured")
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.StoreImm(asm.R10, -272, 0, asm.DWord),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.StoreMem(asm.R10, -272, asm.R3, asm.DWord),
+		})
+	})
+
+	t.Run("exit label", func(t *testing.T) {
+		insns, err := CaptureField("skb->dev->ifindex", getSkbBtf(t), -272, "captured")
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, jumpTargets(insns)["captured"], 1)
+	})
+
+	t.Run("big endian", func(t *testing.T) {
+		insns, err := CaptureField("skb->protocol", getSkbBtf(t), -280, "captured")
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-2:], asm.Instructions{
+			asm.HostTo(asm.BE, asm.R3, asm.Half),
+			asm.StoreMem(asm.R10, -280, asm.R3, asm.DWord),
+		})
+	})
+
+	t.Run("invalid slot", func(t *testing.T) {
+		_, err := CaptureField("skb->len", getSkbBtf(t), -8, "captured")
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid expression", func(t *testing.T) {
+		_, err := CaptureField("skb->xxx", getSkbBtf(t), -272, "captured")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to access expression(skb->xxx)")
+	})
+}
+
+func TestCompareToCapture(t *testing.T) {
+	t.Run("skb->len > captured", func(t *testing.T) {
+		insns, err := CompareToCapture("skb->len", ">", getSkbBtf(t), -272)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.LoadMem(asm.R2, asm.R10, -272, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Reg(asm.R3, asm.R2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		insns, err := CompareToCapture("skb->dev->ifindex", "<", getSkbBtf(t), -272)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-4:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSLT.Reg(asm.R3, asm.R2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("negative narrow field", func(t *testing.T) {
+		s16 := &btf.Int{Name: "short", Size: 2, Encoding: btf.Signed}
+		typ := &btf.Pointer{Target: &btf.Struct{
+			Name:    "s",
+			Size:    2,
+			Members: []btf.Member{{Name: "v", Type: s16}},
+		}}
+
+		captured, err := CaptureField("s->v", typ, -272, "captured")
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, captured[len(captured)-4:], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.LSh.Imm(asm.R3, 48),
+			asm.ArSh.Imm(asm.R3, 48),
+			asm.StoreMem(asm.R10, -272, asm.R3, asm.DWord),
+		})
+
+		insns, err := CompareToCapture("s->v", "<", typ, -272)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.LSh.Imm(asm.R3, 48),
+			asm.ArSh.Imm(asm.R3, 48),
+			asm.LoadMem(asm.R2, asm.R10, -272, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSLT.Reg(asm.R3, asm.R2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := CompareToCapture("skb->len", "+", getSkbBtf(t), -272)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected operator")
+	})
+
+	t.Run("invalid slot", func(t *testing.T) {
+		_, err := CompareToCapture("skb->len", ">", getSkbBtf(t), -12)
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid expression", func(t *testing.T) {
+		_, err := CompareToCapture("skb->xxx", ">", getSkbBtf(t), -272)
+		test.AssertHaveErr(t, err)
+	})
+}
+
+func TestParseFactor(t *testing.T) {
+	for _, tt := range []struct {
+		factor   string
+		num, den int64
+	}{
+		{"1.5", 3, 2},
+		{"2", 2, 1},
+		{"0.25", 1, 4},
+	} {
+		r, err := parseFactor(tt.factor)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, r.Num().Int64(), tt.num)
+		test.AssertEqual(t, r.Denom().Int64(), tt.den)
+	}
+
+	for _, factor := range []string{"", "x", "0", "-1.5", "4294967296"} {
+		_, err := parseFactor(factor)
+		test.AssertHaveErr(t, err)
+	}
+}
+
+func TestCompareToCaptureScaled(t *testing.T) {
+	t.Run("skb->len > captured * 1.5", func(t *testing.T) {
+		insns, err := CompareToCaptureScaled("skb->len", ">", getSkbBtf(t), -272, "1.5")
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-7:], asm.Instructions{
+			asm.LoadMem(asm.R2, asm.R10, -272, asm.DWord),
+			asm.Mul.Imm(asm.R2, 3),
+			asm.Mul.Imm(asm.R3, 2),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Reg(asm.R3, asm.R2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->len > captured * 2", func(t *testing.T) {
+		insns, err := CompareToCaptureScaled("skb->len", ">", getSkbBtf(t), -272, "2")
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-6:len(insns)-3], asm.Instructions{
+			asm.LoadMem(asm.R2, asm.R10, -272, asm.DWord),
+			asm.Mul.Imm(asm.R2, 2),
+			asm.Mov.Imm(asm.R0, 1),
+		})
+	})
+
+	t.Run("invalid factor", func(t *testing.T) {
+		_, err := CompareToCaptureScaled("skb->len", ">", getSkbBtf(t), -272, "-1")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid factor -1")
+	})
+}
+
+func TestChanged(t *testing.T) {
+	t.Run("changed(skb->mark)", func(t *testing.T) {
+		insns, err := Changed("changed(skb->mark)", getSkbBtf(t), "generations")
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 168),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-17:], asm.Instructions{
+			asm.StoreMem(asm.R10, -16, asm.R3, asm.DWord),
+			asm.StoreImm(asm.R10, -4, 0, asm.Word),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("generations"),
+			asm.Mov.Reg(asm.R2, asm.R10),
+			asm.Add.Imm(asm.R2, -4),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, labelExitFail),
+			asm.Mov.Reg(asm.R1, asm.R0),
+			asm.LoadMem(asm.R3, asm.R10, -16, asm.DWord),
+			asm.LoadMem(asm.R2, asm.R1, 0, asm.DWord),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.JEq.Reg(asm.R3, asm.R2, labelReturn),
+			asm.StoreMem(asm.R1, 0, asm.R3, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Ja.Label(labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("unexpected predicate", func(t *testing.T) {
+		_, err := Changed("skb->mark", getSkbBtf(t), "generations")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected predicate skb->mark")
+	})
+
+	t.Run("missing map", func(t *testing.T) {
+		_, err := Changed("changed(skb->mark)", getSkbBtf(t), "")
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid expression", func(t *testing.T) {
+		_, err := Changed("changed(skb->xxx)", getSkbBtf(t), "generations")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to access expression(skb->xxx)")
+	})
+}
+
+// minStackOffset returns the lowest stack offset accessed by the instructions,
+// including the buffers pointed by r10 + offset.
+func minStackOffset(insns asm.Instructions) int16 {
+	var minOff int16
+	for i, insn := range insns {
+		off := int16(0)
+		switch {
+		case insn.OpCode.Class().IsLoad() && insn.Src == asm.R10:
+			off = insn.Offset
+		case insn.OpCode.Class().IsStore() && insn.Dst == asm.R10:
+			off = insn.Offset
+		case insn.OpCode == asm.Mov.Op(asm.RegSource) && insn.Src == asm.R10 && i+1 < len(insns):
+			if next := insns[i+1]; next.OpCode == asm.Add.Op(asm.ImmSource) && next.Dst == insn.Dst {
+				off = int16(next.Constant)
+			}
+		}
+		minOff = min(minOff, off)
+	}
+	return minOff
+}
+
+func TestCaptureSlotReserved(t *testing.T) {
+	char := &btf.Int{Name: "char", Size: 1, Encoding: btf.Char}
+	bufType := &btf.Pointer{Target: &btf.Struct{
+		Name:    "s",
+		Size:    maxStrSize,
+		Members: []btf.Member{{Name: "buf", Type: &btf.Array{Type: char, Nelems: maxStrSize}}},
+	}}
+
+	for _, tt := range []struct {
+		name string
+		opts CompileOptions
+	}{
+		{"string", CompileOptions{Expr: `s->buf == "` + strings.Repeat("a", maxStrSize) + `"`, Type: bufType}},
+		{"hash", CompileOptions{Expr: "hash(skb->data[0:64]) == 0x1234", Type: getSkbBtf(t)}},
+		{"dynamic index", CompileOptions{Expr: "skb->cb[skb->queue_mapping] == 1", Type: getSkbBtf(t)}},
+		{"map lookup", CompileOptions{Expr: "skb->mark in @allowlist", Type: getSkbBtf(t)}},
+		{"LPM lookup", CompileOptions{Expr: "iph->saddr in @prefixes", Type: getIphdrBtf(t), LPMKey: true}},
+		{"scratch map", CompileOptions{Expr: `s->buf == "lo"`, Type: bufType, StackBudget: 1, ScratchMap: "scratch"}},
+		{"counter map", CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), CounterMap: "counter"}},
+		{"now", CompileOptions{Expr: "skb->tstamp > now()", Type: getSkbBtf(t)}},
+		{"difference", CompileOptions{Expr: "skb->end - skb->tail > 64", Type: getSkbBtf(t)}},
+		{"tuple", CompileOptions{Expr: "(iph->saddr, iph->daddr) == (10.0.0.1, 10.0.0.2)", Type: getIphdrBtf(t)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			captured, err := CaptureField("skb->len", getSkbBtf(t), maxCaptureSlot, "captured")
+			test.AssertNoErr(t, err)
+
+			res, err := Compile(tt.opts)
+			test.AssertNoErr(t, err)
+
+			insns := append(captured, res.Insns...)
+			test.AssertTrue(t, minStackOffset(res.Insns) >= stackOffsetCompiled)
+			test.AssertTrue(t, minStackOffset(insns) == maxCaptureSlot)
+		})
+	}
+
+	t.Run("compare exprs", func(t *testing.T) {
+		insns, err := CompareExprs("skb->len", "skb->data_len", ">", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertTrue(t, minStackOffset(insns) >= stackOffsetCompiled)
+	})
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// castInt converts the integer type of a cast like (unsigned short) to btf
+// int, which overrides the read width of the casted field.
+func castInt(t *cc.Type) (*btf.Int, error) {
+	if t == nil {
+		return nil, fmt.Errorf("cast type is nil")
+	}
+
+	var (
+		size   uint32
+		signed bool
+	)
+
+	switch t.Kind {
+	case cc.Char:
+		size, signed = 1, true
+	case cc.Uchar:
+		size = 1
+	case cc.Short:
+		size, signed = 2, true
+	case cc.Ushort:
+		size = 2
+	case cc.Int:
+		size, signed = 4, true
+	case cc.Uint:
+		size = 4
+	case cc.Long, cc.Longlong:
+		size, signed = 8, true
+	case cc.Ulong, cc.Ulonglong:
+		size = 8
+	default:
+		return nil, fmt.Errorf("unexpected cast type %s; must be integer type", t)
+	}
+
+	typ := &btf.Int{Name: t.String(), Size: size}
+	if signed {
+		typ.Encoding = btf.Signed
+	}
+
+	return typ, nil
+}
+
+// kernelIntCastRegexp matches the cast to kernel integer type like (u32) or
+// (__s16), which cannot be parsed by cc.
+var kernelIntCastRegexp = regexp.MustCompile(`\(\s*(?:__)?([us])(8|16|32|64)\s*\)`)
+
+// kernelIntTypes are the C integer types of the kernel integer types.
+var kernelIntTypes = map[string]string{
+	"u8":  "unsigned char",
+	"s8":  "char",
+	"u16": "unsigned short",
+	"s16": "short",
+	"u32": "unsigned int",
+	"s32": "int",
+	"u64": "unsigned long long",
+	"s64": "long long",
+}
+
+// foldKernelIntCast rewrites the casts to kernel integer types like (u32) to
+// the C integer types like (unsigned int), which override the signedness of
+// the casted field in the same width.
+func foldKernelIntCast(expr string) string {
+	return kernelIntCastRegexp.ReplaceAllStringFunc(expr, func(s string) string {
+		m := kernelIntCastRegexp.FindStringSubmatch(s)
+		return "(" + kernelIntTypes[m[1]+m[2]] + ")"
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestCastInt(t *testing.T) {
+	tests := []struct {
+		expr   string
+		size   uint32
+		signed bool
+	}{
+		{expr: "(char)skb->len", size: 1, signed: true},
+		{expr: "(unsigned char)skb->len", size: 1},
+		{expr: "(short)skb->len", size: 2, signed: true},
+		{expr: "(unsigned short)skb->len", size: 2},
+		{expr: "(int)skb->len", size: 4, signed: true},
+		{expr: "(unsigned int)skb->len", size: 4},
+		{expr: "(long)skb->len", size: 8, signed: true},
+		{expr: "(unsigned long long)skb->len", size: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			typ, err := castInt(expr.Type)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, typ.Size, tt.size)
+			test.AssertEqual(t, typ.Encoding == btf.Signed, tt.signed)
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		_, err := castInt(nil)
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("pointer", func(t *testing.T) {
+		expr, err := parse("(struct sk_buff *)skb")
+		test.AssertNoErr(t, err)
+
+		_, err = castInt(expr.Type)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected cast type")
+	})
+}
+
+func TestFoldKernelIntCast(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string
+	}{
+		{expr: "(u32)skb->skb_iif > 100", exp: "(unsigned int)skb->skb_iif > 100"},
+		{expr: "( __s16 )skb->len", exp: "(short)skb->len"},
+		{expr: "(s8)skb->len", exp: "(char)skb->len"},
+		{expr: "(u64)skb->len", exp: "(unsigned long long)skb->len"},
+		{expr: "(u24)skb->len", exp: "(u24)skb->len"},
+		{expr: "skb->len > 100", exp: "skb->len > 100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			test.AssertEqual(t, foldKernelIntCast(tt.expr), tt.exp)
+		})
+	}
+}
+
+func TestCompileSignednessCast(t *testing.T) {
+	for _, tt := range []struct {
+		expr  string
+		shift asm.Instruction
+		jmp   asm.Instruction
+	}{
+		{expr: "skb->skb_iif > 100", shift: asm.ArSh.Imm(asm.R3, 32), jmp: asm.JSGT.Imm(asm.R3, 100, labelReturn)},
+		{expr: "(u32)skb->skb_iif > 100", shift: asm.RSh.Imm(asm.R3, 32), jmp: asm.JGT.Imm(asm.R3, 100, labelReturn)},
+		{expr: "(s32)skb->mark > 100", shift: asm.ArSh.Imm(asm.R3, 32), jmp: asm.JSGT.Imm(asm.R3, 100, labelReturn)},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: tt.expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+
+			// The read is the same as the one without cast, and the signed
+			// value is sign-extended.
+			insns := res.Insns
+			test.AssertEqualSlice(t, insns[6:len(insns)-2], asm.Instructions{
+				asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+				asm.LSh.Imm(asm.R3, 32),
+				tt.shift,
+				asm.Mov.Imm(asm.R0, 1),
+				tt.jmp,
+			})
+		})
+	}
+}
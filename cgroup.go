@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// cgroupSkbFields are the fields of __sk_buff readable in cgroup_skb
+// programs, except the packet pointers like data and data_end.
+var cgroupSkbFields = map[string]bool{
+	"len":             true,
+	"pkt_type":        true,
+	"mark":            true,
+	"queue_mapping":   true,
+	"protocol":        true,
+	"vlan_present":    true,
+	"vlan_tci":        true,
+	"vlan_proto":      true,
+	"priority":        true,
+	"ingress_ifindex": true,
+	"ifindex":         true,
+	"tc_index":        true,
+	"cb":              true,
+	"hash":            true,
+	"napi_id":         true,
+	"family":          true,
+	"remote_ip4":      true,
+	"local_ip4":       true,
+	"remote_ip6":      true,
+	"local_ip6":       true,
+	"remote_port":     true,
+	"local_port":      true,
+	"tstamp":          true,
+	"gso_segs":        true,
+	"gso_size":        true,
+	"hwtstamp":        true,
+}
+
+// rootMember returns the member of the root like len of skb->len or cb of
+// skb->cb[0], or nil if it's not a member access.
+func rootMember(expr *cc.Expr) *cc.Expr {
+	for expr != nil && expr.Left != nil && expr.Left.Op != cc.Name {
+		expr = expr.Left
+	}
+
+	if expr == nil || (expr.Op != cc.Arrow && expr.Op != cc.Dot) {
+		return nil
+	}
+	return expr
+}
+
+// checkCgroupSkb checks the access of the __sk_buff context of cgroup_skb
+// programs, which must read one of the readable fields directly.
+func checkCgroupSkb(left *cc.Expr, ast astInfo, typ btf.Type) error {
+	root := typ
+	if ptr, ok := mybtf.UnderlyingType(typ).(*btf.Pointer); ok {
+		root = ptr.Target
+	}
+	if name := mybtf.UnderlyingType(root).TypeName(); name != "__sk_buff" {
+		return fmt.Errorf("unexpected type %s of cgroup_skb context; must be __sk_buff", typeName(typ))
+	}
+
+	member := rootMember(left)
+	if member == nil {
+		return fmt.Errorf("unexpected access %v of cgroup_skb context; must be member access", left)
+	}
+	if !cgroupSkbFields[member.Text] {
+		return fmt.Errorf("field %s of __sk_buff is not available in cgroup_skb context", member.Text)
+	}
+
+	if len(ast.offsets) != 1 {
+		return fmt.Errorf("cannot dereference %v in cgroup_skb context", left)
+	}
+
+	return nil
+}
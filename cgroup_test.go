@@ -0,0 +1,57 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func getSkbCtxBtf(t *testing.T) *btf.Pointer {
+	skb, err := testBtf.AnyTypeByName("__sk_buff")
+	test.AssertNoErr(t, err)
+	return &btf.Pointer{Target: skb}
+}
+
+func TestCompileCgroupSkb(t *testing.T) {
+	t.Run("skb->len > 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 0", Type: getSkbCtxBtf(t), CgroupSkb: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 0, asm.Word),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 0, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->cb[1] == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->cb[1] == 1", Type: getSkbCtxBtf(t), CgroupSkb: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 52, asm.Word),
+		})
+	})
+
+	t.Run("unavailable field", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->tc_classid == 1", Type: getSkbCtxBtf(t), CgroupSkb: true})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(skb->tc_classid == 1): field tc_classid of __sk_buff is not available in cgroup_skb context")
+	})
+
+	t.Run("unexpected type", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len > 0", Type: getSkbBtf(t), CgroupSkb: true})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(skb->len > 0): unexpected type sk_buff * of cgroup_skb context")
+	})
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"regexp"
+)
+
+// cidrRegexp matches the IPv4 prefix membership like iph->saddr in 10.0.0.0/8,
+// which cannot be parsed by cc.
+var cidrRegexp = regexp.MustCompile(`^\s*(.+?)\s+in\s+(\S+/\d+)\s*$`)
+
+// foldCIDR rewrites the prefix membership to the masked comparison like
+// (iph->saddr & 0xff000000) == 0xa000000. The mask and the network are in host
+// byte order, and converted to network byte order for the big endian fields
+// like __be32 as the other constants. The prefix must end the expression, so
+// the one in a string literal like "a in 10.0.0.0/8" is kept.
+func foldCIDR(expr string) (string, error) {
+	m := cidrRegexp.FindStringSubmatch(expr)
+	if m == nil {
+		return expr, nil
+	}
+
+	prefix, err := netip.ParsePrefix(m[2])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse CIDR %s: %w", m[2], err)
+	}
+	if !prefix.Addr().Is4() {
+		return "", fmt.Errorf("unsupported CIDR %s; must be IPv4", m[2])
+	}
+
+	addr := prefix.Masked().Addr().As4()
+	network := binary.BigEndian.Uint32(addr[:])
+	mask := uint32(math.MaxUint32) << (32 - prefix.Bits())
+
+	return fmt.Sprintf("(%s & 0x%x) == 0x%x", m[1], mask, network), nil
+}
+
+// ipv4Regexp matches the IPv4 address like 10.0.0.1, which cannot be parsed
+// by cc.
+var ipv4Regexp = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+
+// foldIPv4 rewrites the IPv4 addresses to the constants in host byte order
+// like 0xa000001, which are converted to network byte order for the big endian
+// fields as the other constants. The invalid addresses are kept to fail the
+// parsing, and so are the ones in string literals like "10.0.0.1".
+func foldIPv4(expr string) string {
+	expr, _ = foldOutsideLiterals(expr, func(expr string) (string, error) {
+		return ipv4Regexp.ReplaceAllStringFunc(expr, ipv4Const), nil
+	})
+	return expr
+}
+
+func ipv4Const(s string) string {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return s
+	}
+
+	a := addr.As4()
+	return fmt.Sprintf("0x%x", binary.BigEndian.Uint32(a[:]))
+}
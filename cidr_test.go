@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func getIphdrBtf(t *testing.T) *btf.Pointer {
+	iph, err := testBtf.AnyTypeByName("iphdr")
+	test.AssertNoErr(t, err)
+	return &btf.Pointer{Target: iph}
+}
+
+func TestFoldCIDR(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string
+		err  string
+	}{
+		{expr: "iph->saddr == 1", exp: "iph->saddr == 1"},
+		{expr: "iph->saddr in 10.0.0.0/8", exp: "(iph->saddr & 0xff000000) == 0xa000000"},
+		{expr: "iph->daddr in 192.168.1.7/24", exp: "(iph->daddr & 0xffffff00) == 0xc0a80100"},
+		{expr: "iph->daddr in 1.2.3.4/32", exp: "(iph->daddr & 0xffffffff) == 0x1020304"},
+		{expr: "iph->daddr in 0.0.0.0/0", exp: "(iph->daddr & 0x0) == 0x0"},
+		{expr: "iph->saddr in 10.0.0.0/33", err: "failed to parse CIDR"},
+		{expr: "iph->saddr in fe80::/10", err: "unsupported CIDR"},
+		{expr: `dev->name == "a in 10.0.0.0/8"`, exp: `dev->name == "a in 10.0.0.0/8"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := foldCIDR(tt.expr)
+			if tt.err != "" {
+				test.AssertHaveErr(t, err)
+				test.AssertStrPrefix(t, err.Error(), tt.err)
+				return
+			}
+
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, expr, tt.exp)
+		})
+	}
+}
+
+func TestFoldIPv4(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string
+	}{
+		{expr: "iph->saddr == 10.0.0.1", exp: "iph->saddr == 0xa000001"},
+		{expr: "(iph->saddr, iph->daddr) == (192.168.1.1, 1.2.3.4)", exp: "(iph->saddr, iph->daddr) == (0xc0a80101, 0x1020304)"},
+		{expr: "iph->saddr == 256.0.0.1", exp: "iph->saddr == 256.0.0.1"},
+		{expr: "iph->saddr == 1", exp: "iph->saddr == 1"},
+		{expr: `dev->name == "10.0.0.1"`, exp: `dev->name == "10.0.0.1"`},
+		{expr: `f("10.0.0.1") == 10.0.0.1`, exp: `f("10.0.0.1") == 0xa000001`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			test.AssertEqual(t, foldIPv4(tt.expr), tt.exp)
+		})
+	}
+
+	t.Run(`skb->dev->name == "10.0.0.1"`, func(t *testing.T) {
+		assertCompiledLiteral(t, `skb->dev->name == "10.0.0.1"`, "10.0.0.1")
+	})
+
+	t.Run(`skb->dev->name == "a in 10.0.0.0/8"`, func(t *testing.T) {
+		assertCompiledLiteral(t, `skb->dev->name == "a in 10.0.0.0/8"`, "a in 10.0.0.0/8")
+	})
+}
+
+func TestCompileCIDR(t *testing.T) {
+	res, err := Compile(CompileOptions{
+		Expr: "iph->saddr in 10.0.0.0/8",
+		Type: getIphdrBtf(t),
+	})
+	test.AssertNoErr(t, err)
+
+	// Emulate the instructions after reading the address.
+	insns := res.Insns
+	load := 0
+	for i, ins := range insns {
+		if ins.OpCode.Class().IsLoad() && ins.Src == asm.R10 {
+			load = i
+		}
+	}
+	jeq := insns[len(insns)-3]
+	test.AssertEqual(t, jeq.OpCode.JumpOp(), asm.JEq)
+
+	tests := []struct {
+		addr  string
+		match bool
+	}{
+		{"10.0.0.0", true},
+		{"10.1.2.3", true},
+		{"10.255.255.255", true},
+		{"11.0.0.1", false},
+		{"192.168.1.1", false},
+		{"9.255.255.255", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			addr := netip.MustParseAddr(tt.addr).As4()
+			regs := map[asm.Register]uint64{
+				asm.R3: uint64(nativeEndian.Uint32(addr[:])),
+			}
+			runALU64(t, insns[load+1:len(insns)-3], regs)
+			test.AssertEqual(t, regs[asm.R3] == uint64(jeq.Constant), tt.match)
+		})
+	}
+
+	t.Run("masked by network order", func(t *testing.T) {
+		test.AssertEqualSlice(t, insns[len(insns)-5:len(insns)-4], asm.Instructions{
+			asm.And.Imm(asm.R3, int32(h2nl(0xff000000, isBigEndian(nativeEndian)))),
+		})
+	})
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+const (
+	stackOffsetRoot = -16 // saved root pointer
+	stackOffsetLeft = -24 // saved value of the left expression
+)
+
+func parseOperator(op string) (cc.ExprOp, error) {
+	switch op {
+	case "=":
+		return cc.Eq, nil
+	case "==":
+		return cc.EqEq, nil
+	case "!=":
+		return cc.NotEq, nil
+	case "<":
+		return cc.Lt, nil
+	case "<=":
+		return cc.LtEq, nil
+	case ">":
+		return cc.Gt, nil
+	case ">=":
+		return cc.GtEq, nil
+	default:
+		return 0, fmt.Errorf("unexpected operator: %s; must be one of =, ==, !=, <, <=, >, >=", op)
+	}
+}
+
+// be2host converts the value in reg to host byte order if it's big endian,
+// which is detected by its type like __be32 or forced by ForceBigEndian.
+func be2host(insns asm.Instructions, typ btf.Type, bigEndian bool, reg asm.Register) (asm.Instructions, error) {
+	if !bigEndian {
+		return insns, nil
+	}
+
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get size of %s: %w", typ, err)
+	}
+
+	switch size {
+	case 2:
+		insns = append(insns, asm.HostTo(asm.BE, reg, asm.Half))
+	case 4:
+		insns = append(insns, asm.HostTo(asm.BE, reg, asm.Word))
+	case 8:
+		insns = append(insns, asm.HostTo(asm.BE, reg, asm.DWord))
+	}
+
+	return insns, nil
+}
+
+// CompareExprs compiles the comparison of two member access expressions of the
+// same root, like CompareExprs("skb->len", "skb->data_len", ">", skb). Both
+// sides are read via bpf_probe_read_kernel() and compared in registers.
+//
+// The big endian values are converted to host byte order, and the signed
+// values are sign-extended to 64 bits before comparing. The signedness of the
+// comparison is determined by the left expression.
+func CompareExprs(a, b, op string, typ btf.Type) (asm.Instructions, error) {
+	exprOp, err := parseOperator(op)
+	if err != nil {
+		return nil, err
+	}
+
+	left, err := parseOperand(a)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access left expression(%s): %w", a, err)
+	}
+
+	right, err := parseOperand(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access right expression(%s): %w", b, err)
+	}
+
+	root := func(*cc.Expr) (asm.Register, btf.Type) { return asm.R1, typ }
+	return compareOperands(left, right, exprOp, root, labelExitFail)
+}
+
+func parseOperand(expr string) (*cc.Expr, error) {
+	ast, err := parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile expression %s: %w", expr, err)
+	}
+
+	if err := validateLeftOperand(ast); err != nil {
+		return nil, fmt.Errorf("expression is not struct/union member access: %w", err)
+	}
+
+	return ast, nil
+}
+
+// compareOperands compiles the comparison of two validated member accesses,
+// whose root register and type are resolved by root. Both are read as 64-bit
+// values, see accessValue, and the signedness of the comparison is determined
+// by the left operand. The read failure jumps to labelFail.
+func compareOperands(left, right *cc.Expr, op cc.ExprOp, root func(*cc.Expr) (asm.Register, btf.Type), labelFail string) (asm.Instructions, error) {
+	insns := asm.Instructions{
+		asm.StoreMem(asm.R10, stackOffsetRoot, asm.R1, asm.DWord), // *(r10 - 16) = r1
+	}
+
+	var (
+		leftType  btf.Type
+		labelUsed bool
+	)
+	for i, operand := range []*cc.Expr{left, right} {
+		reg, typ := root(operand)
+		if reg != asm.R1 {
+			insns = append(insns, asm.Mov.Reg(asm.R1, reg)) // r1 = arg
+		} else if i != 0 {
+			insns = append(insns,
+				asm.LoadMem(asm.R1, asm.R10, stackOffsetRoot, asm.DWord), // r1 = *(r10 - 16)
+			)
+		}
+
+		res, err := accessValue(operand, AccessOptions{
+			Insns:     insns,
+			Type:      typ,
+			Src:       asm.R1,
+			Dst:       asm.R3,
+			LabelExit: labelFail,
+		})
+		if err != nil {
+			side := "left"
+			if i != 0 {
+				side = "right"
+			}
+			return nil, fmt.Errorf("failed to access %s expression(%s): %w", side, operand, err)
+		}
+
+		insns = res.Insns
+		labelUsed = labelUsed || res.LabelUsed
+		if i == 0 {
+			leftType = res.LastField
+			insns = append(insns,
+				asm.StoreMem(asm.R10, stackOffsetLeft, asm.R3, asm.DWord), // *(r10 - 24) = r3
+			)
+		}
+	}
+
+	// if r2 <op> r3, goto __return
+	jmpOpCode, err := op2jump(op, isSignedType(leftType))
+	if err != nil {
+		return nil, err
+	}
+
+	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+	if labelUsed && labelFail == labelExitFail {
+		xorR0 = xorR0.WithSymbol(labelExitFail)
+	}
+	insns = append(insns,
+		asm.LoadMem(asm.R2, asm.R10, stackOffsetLeft, asm.DWord), // r2 = *(r10 - 24)
+		asm.Mov.Imm(asm.R0, 1), // r0 = 1
+		jmpOpCode.Reg(asm.R2, asm.R3, labelReturn),
+		xorR0,                                // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestParseOperator(t *testing.T) {
+	tests := []struct {
+		op  string
+		exp cc.ExprOp
+	}{
+		{op: "=", exp: cc.Eq},
+		{op: "==", exp: cc.EqEq},
+		{op: "!=", exp: cc.NotEq},
+		{op: "<", exp: cc.Lt},
+		{op: "<=", exp: cc.LtEq},
+		{op: ">", exp: cc.Gt},
+		{op: ">=", exp: cc.GtEq},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			op, err := parseOperator(tt.op)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, op, tt.exp)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseOperator("+")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected operator")
+	})
+}
+
+func TestCompareExprs(t *testing.T) {
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := CompareExprs("skb->len", "skb->data_len", "+", getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected operator")
+	})
+
+	t.Run("invalid left expression", func(t *testing.T) {
+		_, err := CompareExprs("skb->xxx", "skb->data_len", "==", getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to access left expression(skb->xxx)")
+	})
+
+	t.Run("invalid right expression", func(t *testing.T) {
+		_, err := CompareExprs("skb->len", "skb->xxx", "==", getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to access right expression(skb->xxx)")
+	})
+
+	t.Run("skb->len > skb->data_len", func(t *testing.T) {
+		insns, err := CompareExprs("skb->len", "skb->data_len", ">", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.StoreMem(asm.R10, -16, asm.R1, asm.DWord),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.StoreMem(asm.R10, -24, asm.R3, asm.DWord),
+			asm.LoadMem(asm.R1, asm.R10, -16, asm.DWord),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 116),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.LoadMem(asm.R2, asm.R10, -24, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Reg(asm.R2, asm.R3, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->dev->ifindex == skb->protocol", func(t *testing.T) {
+		insns, err := CompareExprs("skb->dev->ifindex", "skb->protocol", "==", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-8:len(insns)-1], asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.Half),
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.HostTo(asm.BE, asm.R3, asm.Half),
+			asm.LoadMem(asm.R2, asm.R10, -24, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Reg(asm.R2, asm.R3, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+		})
+	})
+	t.Run("negative narrow fields s->a < s->b", func(t *testing.T) {
+		s8 := &btf.Int{Name: "signed char", Size: 1, Encoding: btf.Signed}
+		s16 := &btf.Int{Name: "short", Size: 2, Encoding: btf.Signed}
+		typ := &btf.Pointer{Target: &btf.Struct{
+			Name: "s",
+			Size: 4,
+			Members: []btf.Member{
+				{Name: "a", Type: s8},
+				{Name: "b", Type: s16, Offset: 16},
+			},
+		}}
+
+		insns, err := CompareExprs("s->a", "s->b", "<", typ)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[6:11], asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 0xFF),
+			asm.LSh.Imm(asm.R3, 56),
+			asm.ArSh.Imm(asm.R3, 56),
+			asm.StoreMem(asm.R10, -24, asm.R3, asm.DWord),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.LSh.Imm(asm.R3, 48),
+			asm.ArSh.Imm(asm.R3, 48),
+			asm.LoadMem(asm.R2, asm.R10, -24, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSLT.Reg(asm.R2, asm.R3, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+}
@@ -5,6 +5,7 @@ package bice
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/Asphaltt/mybtf"
 	"github.com/cilium/ebpf/asm"
@@ -12,6 +13,11 @@ import (
 	"rsc.io/c2go/cc"
 )
 
+// The labels of the generated instructions are fixed names instead of being
+// generated by a counter or a random source, as every compiled expression has
+// one exit and one return at most. So the same expression always compiles to
+// the same instructions and symbols, whose names are prefixed by __ and
+// suffixed by _bice_filter to avoid colliding with the symbols of the program.
 const (
 	labelExitFail = "__exit_bice_filter"
 	labelReturn   = "__return_bice_filter"
@@ -25,6 +31,7 @@ func IsMemberBitfield(member *btf.Member) bool {
 type rightInfo struct {
 	constant uint64
 	enum     string
+	expr     *cc.Expr // constant expression folded by enum2const, like (A | B)
 }
 
 func parseRightOperand(right *cc.Expr) (rightInfo, error) {
@@ -41,6 +48,46 @@ func parseRightOperand(right *cc.Expr) (rightInfo, error) {
 		}
 
 		ri.constant = constant
+
+	case cc.Minus:
+		// -1 is the two's complement of 1.
+		if right.Left == nil || right.Left.Op != cc.Number {
+			return ri, fmt.Errorf("unexpected right operand: %v; only number can be negated", right)
+		}
+
+		constant, err := parseNumber(right.Left.Text)
+		if err != nil {
+			return ri, fmt.Errorf("failed to parse number %s: %w", right.Left.Text, err)
+		}
+
+		ri.constant = -constant
+
+	case cc.Twid:
+		// ~0x3 is folded to the complement, which is truncated to the
+		// width of the field like 0xfc of u8.
+		if right.Left == nil || right.Left.Op != cc.Number {
+			return ri, fmt.Errorf("unexpected right operand: %v; only number can be complemented", right)
+		}
+
+		constant, err := parseNumber(right.Left.Text)
+		if err != nil {
+			return ri, fmt.Errorf("failed to parse number %s: %w", right.Left.Text, err)
+		}
+
+		ri.constant = ^constant
+
+	case cc.Paren:
+		return parseRightOperand(right.Left)
+
+	case cc.Or:
+		for _, operand := range []*cc.Expr{right.Left, right.Right} {
+			if _, err := parseRightOperand(operand); err != nil {
+				return ri, err
+			}
+		}
+
+		ri.expr = right
+
 	default:
 		return ri, fmt.Errorf("unexpected right operand: %v", right)
 	}
@@ -48,51 +95,196 @@ func parseRightOperand(right *cc.Expr) (rightInfo, error) {
 	return ri, nil
 }
 
+func enumValue(t btf.Type, name string) (uint64, error) {
+	enum, ok := mybtf.UnderlyingType(t).(*btf.Enum)
+	if !ok {
+		return 0, fmt.Errorf("unexpected type %T for %s", t, name)
+	}
+
+	for _, value := range enum.Values {
+		if value.Name == name {
+			return value.Value, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s not found in enum %s", name, enum.Name)
+}
+
+// hasEnumValue reports whether the enum has the value named by name.
+func hasEnumValue(enum *btf.Enum, name string) bool {
+	for _, value := range enum.Values {
+		if value.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findEnum finds the enum type having the value named by name in the spec, and
+// then by the resolver.
+func findEnum(opts *CompileOptions, name string) (*btf.Enum, error) {
+	if opts.Spec != nil {
+		for iter := opts.Spec.Iterate(); iter.Next(); {
+			if enum, ok := iter.Type.(*btf.Enum); ok && hasEnumValue(enum, name) {
+				return enum, nil
+			}
+		}
+	}
+
+	if opts.TypeResolver != nil {
+		if typ, ok := opts.TypeResolver(name); ok && typ != nil {
+			if enum, ok := mybtf.UnderlyingType(typ).(*btf.Enum); ok && hasEnumValue(enum, name) {
+				return enum, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("enum value %s: %w", name, ErrNotFound)
+}
+
+// foldConstant folds the constant expression to a single constant. The enum
+// names in the expression are resolved by the enum type t.
+func foldConstant(expr *cc.Expr, t btf.Type) (uint64, error) {
+	switch expr.Op {
+	case cc.Name:
+		return enumValue(t, expr.Text)
+
+	case cc.Number:
+		return parseNumber(expr.Text)
+
+	case cc.Paren:
+		return foldConstant(expr.Left, t)
+
+	case cc.Or:
+		left, err := foldConstant(expr.Left, t)
+		if err != nil {
+			return 0, err
+		}
+
+		right, err := foldConstant(expr.Right, t)
+		if err != nil {
+			return 0, err
+		}
+
+		return left | right, nil
+
+	default:
+		return 0, fmt.Errorf("unexpected constant expression: %v", expr)
+	}
+}
+
 func (ri *rightInfo) enum2const(t btf.Type) error {
-	if ri.enum == "" {
+	if ri.expr != nil {
+		constant, err := foldConstant(ri.expr, t)
+		if err != nil {
+			return err
+		}
+
+		ri.constant = constant
 		return nil
 	}
 
-	enum, ok := t.(*btf.Enum)
-	if !ok {
-		return fmt.Errorf("unexpected type %T for %s", t, ri.enum)
+	if ri.enum == "" {
+		return nil
 	}
 
-	for i, value := range enum.Values {
-		if value.Name == ri.enum {
-			ri.constant = uint64(i)
-			return nil
-		}
+	constant, err := enumValue(t, ri.enum)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("%s not found in enum %s", ri.enum, enum.Name)
+	ri.constant = constant
+	return nil
 }
 
 type astInfo struct {
 	offsets   []uint32
+	paths     []string // member access expression of each offset
 	member    *btf.Member
 	lastField btf.Type
-	bigEndian bool // true if the last field is big endian
+	bigEndian bool   // true if the last field is big endian
+	userReads []bool // true if the offset is read from the __user pointer
+	accessed  []accessedMember
+}
+
+// accessedMember is the member accessed of the struct/union, whose offset is
+// from the start of the struct/union even if it's in an anonymous member.
+type accessedMember struct {
+	parent btf.Type
+	member btf.Member
 }
 
 func expr2offset(expr *cc.Expr, typ btf.Type) (astInfo, error) {
+	return expr2offsetWithLookup(expr, typ, nil, nil)
+}
+
+// parseIndex parses the constant index of the array access, which is negative
+// like -1 to index from the end of the array.
+func parseIndex(index *cc.Expr) (uint64, bool, error) {
+	negative := index.Op == cc.Minus
+	if negative {
+		index = index.Left
+	}
+
+	n, err := parseNumber(index.Text)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to parse index %s: %w", index.Text, err)
+	}
+
+	return n, negative && n != 0, nil
+}
+
+// expr2offsetWithLookup is like expr2offset but resolves the container types
+// of container_of() by lookup.
+func expr2offsetWithLookup(expr *cc.Expr, typ btf.Type, lookup typeLookupFunc, aliases FieldAliases) (astInfo, error) {
 	var ast astInfo
 
 	var exprStack []*cc.Expr
 	for left := expr; left != nil; left = left.Left {
 		exprStack = append(exprStack, left)
+		if left.Op == cc.Call {
+			break
+		}
 	}
 
-	if len(exprStack) == 1 {
+	if len(exprStack) == 1 && expr.Op != cc.Call {
 		ast.lastField = typ
 		ast.bigEndian = mybtf.IsBigEndian(typ)
 		return ast, nil
 	}
 
-	var offsets []uint32
+	var (
+		offsets []uint32
+		paths   []string
+		users   []bool
+		adjust  uint32 // added to the next offset after container_of()
+	)
 
+	// The typedef of pointer root like typedef struct sk_buff *skb_t is
+	// resolved to the pointer, so that it's dereferenced by ->.
 	prev := mybtf.UnderlyingType(typ)
-	for i, j := len(exprStack)-2, -1; i >= 0; i-- {
+	start, j := len(exprStack)-2, -1
+	if root := exprStack[len(exprStack)-1]; root.Op == cc.Call {
+		// container_of(ptr, type, member)->field re-roots at the container
+		// type, whose base is at the negative offset of member from ptr.
+		container, err := containerOf(root, typ, lookup, aliases)
+		if err != nil {
+			return ast, err
+		}
+		if start < 0 {
+			return ast, fmt.Errorf("%s must be followed by member access", root)
+		}
+
+		offsets = container.offsets
+		paths = container.paths
+		users = make([]bool, len(offsets))
+		j = len(offsets) - 1
+		adjust = -container.offset
+		prev = container.ptr
+	}
+
+	for i := start; i >= 0; i-- {
 		var (
 			prevName string
 			member   *btf.Member
@@ -100,50 +292,63 @@ func expr2offset(expr *cc.Expr, typ btf.Type) (astInfo, error) {
 			err      error
 		)
 
-		ptr, useArrow := prev.(*btf.Pointer)
-		if useArrow {
-			prev = mybtf.UnderlyingType(ptr.Target)
-		}
-
 		expr := exprStack[i]
-		switch v := prev.(type) {
-		case *btf.Struct:
-			member, err = mybtf.FindStructMember(v, expr.Text)
-			prevName = v.Name
-		case *btf.Union:
-			member, err = mybtf.FindUnionMember(v, expr.Text)
-			prevName = v.Name
-		default:
-			return ast, fmt.Errorf("unexpected type %T of %s(%+v)", v, expr.Text, prev)
-		}
-		if err != nil {
-			return ast, fmt.Errorf("failed to find member %s of %s: %w", expr.Text, prevName, err)
-		}
+		switch expr.Op {
+		case cc.Arrow, cc.Dot:
+			// The access kind is determined by the type instead of the
+			// accessor, so skb.len is treated as skb->len when skb is a
+			// pointer, and skb->headers->protocol as skb->headers.protocol
+			// when headers is embedded.
+			ptr, useArrow := prev.(*btf.Pointer)
+			if useArrow {
+				prev = mybtf.UnderlyingType(ptr.Target)
+			}
 
-		switch v := prev.(type) {
-		case *btf.Struct:
-			offset, err = mybtf.StructMemberOffset(v, expr.Text)
-		case *btf.Union:
-			offset, err = mybtf.UnionMemberOffset(v, expr.Text)
-		}
-		if err != nil {
-			return ast, fmt.Errorf("failed to get offset of member %s of %s: %w", expr.Text, prevName, err)
-		}
+			name := aliases.resolve(prev, expr.Text)
+			switch v := prev.(type) {
+			case *btf.Struct:
+				member, err = mybtf.FindStructMember(v, name)
+				prevName = v.Name
+			case *btf.Union:
+				member, err = mybtf.FindUnionMember(v, name)
+				prevName = v.Name
+			default:
+				return ast, fmt.Errorf("unexpected type %T of %s(%+v)", v, expr.Text, prev)
+			}
+			if err != nil {
+				return ast, fmt.Errorf("failed to find member %s of %s: %w", name, prevName, err)
+			}
 
-		prev = mybtf.UnderlyingType(member.Type)
+			switch v := prev.(type) {
+			case *btf.Struct:
+				offset, err = mybtf.StructMemberOffset(v, name)
+			case *btf.Union:
+				offset, err = mybtf.UnionMemberOffset(v, name)
+			}
+			if err != nil {
+				return ast, fmt.Errorf("failed to get offset of member %s of %s: %w", name, prevName, err)
+			}
+
+			accessed := *member
+			if !IsMemberBitfield(member) {
+				accessed.Offset = btf.Bits(offset * 8)
+			}
+			ast.accessed = append(ast.accessed, accessedMember{parent: prev, member: accessed})
+
+			prev = mybtf.UnderlyingType(member.Type)
 
-		switch expr.Op {
-		case cc.Arrow, cc.Dot:
 			if !useArrow {
 				// access via .
 				if j >= 0 {
 					offsets[j] += offset
 				} else {
-					return ast, fmt.Errorf("unexpected access via .: %s", expr)
+					return ast, fmt.Errorf("cannot access member %s of %s value %s; use -> on a pointer to %s instead", expr.Text, prevName, expr.Left, prevName)
 				}
 			} else {
 				// access via ->
-				offsets = append(offsets, offset)
+				offsets = append(offsets, offset+adjust)
+				users = append(users, isUserPointer(ptr))
+				adjust = 0
 				j++
 			}
 
@@ -151,18 +356,82 @@ func expr2offset(expr *cc.Expr, typ btf.Type) (astInfo, error) {
 				offsets[j] = member.Offset.Bytes()
 			}
 
-			if i == 0 {
-				ast.offsets = offsets
-				ast.member = member
-				ast.lastField = member.Type
-				ast.bigEndian = mybtf.IsBigEndian(member.Type)
-				return ast, nil
+			ast.member = member
+			ast.lastField = member.Type
+
+		case cc.Index:
+			// The array is either embedded in the struct/union, or pointed
+			// by a pointer which has to be dereferenced before indexing.
+			ptr, isPtr := prev.(*btf.Pointer)
+			if isPtr {
+				prev = mybtf.UnderlyingType(ptr.Target)
+			}
+
+			arr, ok := prev.(*btf.Array)
+			if !ok {
+				return ast, fmt.Errorf("unexpected type %T of %s; must be array or pointer to array", prev, expr.Left)
+			}
+
+			index, negative, err := parseIndex(expr.Right)
+			if err != nil {
+				return ast, err
+			}
+			if negative {
+				// skb->cb[-1] is the last element like skb->cb[47].
+				if index > uint64(arr.Nelems) {
+					return ast, fmt.Errorf("index -%d out of range of %s[%d]", index, expr.Left, arr.Nelems)
+				}
+				index = uint64(arr.Nelems) - index
+			}
+			if index >= uint64(arr.Nelems) {
+				return ast, fmt.Errorf("index %d out of range of %s[%d]", index, expr.Left, arr.Nelems)
+			}
+
+			size, err := btf.Sizeof(arr.Type)
+			if err != nil {
+				return ast, fmt.Errorf("failed to get size of element of %s: %w", expr.Left, err)
 			}
 
+			offset = uint32(index) * uint32(size)
+			if isPtr {
+				// access via pointer to array
+				offsets = append(offsets, offset+adjust)
+				users = append(users, isUserPointer(ptr))
+				adjust = 0
+				j++
+			} else if j >= 0 {
+				// access via array embedded in struct/union
+				offsets[j] += offset
+			} else {
+				return ast, fmt.Errorf("unexpected array access: %s", expr)
+			}
+
+			// The element like files->fd_array[3] of an array of pointers
+			// is dereferenced by the following ->.
+			prev = mybtf.UnderlyingType(arr.Type)
+
+			ast.member = nil
+			ast.lastField = arr.Type
+
 		default:
 			// protected by validateLeftOperand()
 			return ast, fmt.Errorf("unexpected operator: %s", expr.Op)
 		}
+
+		if j >= 0 {
+			for len(paths) < len(offsets) {
+				paths = append(paths, "")
+			}
+			paths[j] = expr.String()
+		}
+
+		if i == 0 {
+			ast.offsets = offsets
+			ast.paths = paths
+			ast.userReads = users
+			ast.bigEndian = mybtf.IsBigEndian(ast.lastField)
+			return ast, nil
+		}
 	}
 
 	return ast, fmt.Errorf("unexpected expression: %s", expr)
@@ -203,6 +472,41 @@ func offset2insns(insns asm.Instructions, offsets []uint32, dst asm.Register, la
 	return insns, labelUsed
 }
 
+// narrowLastLoad loads the last field read by offset2insns in the field width
+// instead of 8 bytes, so that the loaded value is the field itself regardless
+// of the host byte order.
+func narrowLastLoad(insns asm.Instructions, size int) asm.Instructions {
+	last := insns[len(insns)-1]
+	if size > 0 && size < 8 && last.OpCode.Class().IsLoad() && last.Src == asm.R10 {
+		insns[len(insns)-1] = asm.LoadMem(last.Dst, asm.R10, last.Offset, sizeOf(size)).WithMetadata(last.Metadata)
+	}
+
+	return insns
+}
+
+// directLoad2insns is like offset2insns but dereferences the trusted btf
+// pointers directly instead of bpf_probe_read_kernel() round-trips through the
+// stack, which is preferred in fentry/fexit programs.
+func directLoad2insns(insns asm.Instructions, offsets []uint32, dst asm.Register, labelExit string, size asm.Size) (asm.Instructions, bool) {
+	labelUsed := false
+	lastIndex := len(offsets) - 1
+	for i := 0; i <= lastIndex; i++ {
+		if i != lastIndex { // not last member access
+			labelUsed = true
+			insns = append(insns,
+				asm.LoadMem(asm.R3, asm.R3, int16(offsets[i]), asm.DWord), // r3 = *(r3 + offset)
+				asm.JEq.Imm(asm.R3, 0, labelExit),                         // if r3 == 0, goto __exit
+			)
+		} else {
+			insns = append(insns,
+				asm.LoadMem(dst, asm.R3, int16(offsets[i]), size), // dst = *(r3 + offset)
+			)
+		}
+	}
+
+	return insns, labelUsed
+}
+
 func bitfield2insns(insns asm.Instructions, constant uint64, member *btf.Member, reg asm.Register) (asm.Instructions, uint64) {
 	delta := member.Offset & 0x7
 	if delta != 0 {
@@ -220,14 +524,42 @@ func bitfield2insns(insns asm.Instructions, constant uint64, member *btf.Member,
 	return insns, constant
 }
 
+// checkBitfieldConstant checks the constant compared with the bitfield fits in
+// the bitfield width, as the constant is truncated to the width like the
+// extracted bitfield. The signed bitfield can be compared with the negative
+// constant in the width too.
+func checkBitfieldConstant(constant uint64, member *btf.Member, signed bool) error {
+	bits := uint64(member.BitfieldSize)
+	if bits >= 64 || constant <= (uint64(1)<<bits)-1 {
+		return nil
+	}
+	if signed && int64(constant) < 0 && int64(constant) >= -(int64(1)<<(bits-1)) {
+		return nil
+	}
+
+	if signed {
+		return fmt.Errorf("constant %d exceeds %d bits of bitfield '%s'", int64(constant), bits, member.Name)
+	}
+	return fmt.Errorf("constant %d exceeds %d bits of bitfield '%s'", constant, bits, member.Name)
+}
+
 type tgtInfo struct {
 	constant  uint64
 	typ       btf.Type
 	sizof     int
 	bigEndian bool
+	targetBE  bool         // the target is big endian, see CompileOptions.ByteOrder
+	srcReg    asm.Register // compare with the register instead of constant if set
+
+	zeroExtended bool // loaded in the field width, which needn't masking
+	signExtended bool // sign-extend the signed field instead of masking
 }
 
 func tgt2insns(insns asm.Instructions, tgt tgtInfo, reg asm.Register) (asm.Instructions, uint64) {
+	if tgt.signExtended && tgt.sizof > 0 && tgt.sizof < 8 {
+		return signExtend2insns(insns, tgt, reg)
+	}
+
 	tgtConst := tgt.constant
 	switch tgt.sizof {
 	case 1:
@@ -239,7 +571,7 @@ func tgt2insns(insns asm.Instructions, tgt tgtInfo, reg asm.Register) (asm.Instr
 	case 2:
 		tgtConst = uint64(uint16(tgtConst))
 		if tgt.bigEndian {
-			tgtConst = uint64(h2ns(uint16(tgtConst)))
+			tgtConst = uint64(h2ns(uint16(tgtConst), tgt.targetBE))
 		}
 
 		insns = append(insns,
@@ -248,86 +580,142 @@ func tgt2insns(insns asm.Instructions, tgt tgtInfo, reg asm.Register) (asm.Instr
 	case 4:
 		tgtConst = uint64(uint32(tgtConst))
 		if tgt.bigEndian {
-			tgtConst = uint64(h2nl(uint32(tgtConst)))
+			tgtConst = uint64(h2nl(uint32(tgtConst), tgt.targetBE))
 		}
 
-		insns = append(insns,
-			asm.LSh.Imm(reg, 32), // reg <<= 32
-			asm.RSh.Imm(reg, 32), // reg >>= 32
-		)
+		if !tgt.zeroExtended {
+			insns = append(insns,
+				asm.LSh.Imm(reg, 32), // reg <<= 32
+				asm.RSh.Imm(reg, 32), // reg >>= 32
+			)
+		}
 
 	case 8:
 		if tgt.bigEndian {
-			tgtConst = h2nll(tgtConst)
+			tgtConst = h2nll(tgtConst, tgt.targetBE)
 		}
 	}
 
 	return insns, tgtConst
 }
 
-func op2insns(insns asm.Instructions, op cc.ExprOp, tgt tgtInfo) (asm.Instructions, error) {
-	isSigned := false
-	intType, isInt := tgt.typ.(*btf.Int)
-	if isInt {
-		isSigned = intType.Encoding == btf.Signed
+// signExtend2insns sign-extends the signed field of less than 8 bytes in reg,
+// and the constant in the same width, so that both sides are compared as
+// 64-bit like -1 == -1.
+func signExtend2insns(insns asm.Instructions, tgt tgtInfo, reg asm.Register) (asm.Instructions, uint64) {
+	tgtConst := tgt.constant
+	switch tgt.sizof {
+	case 1:
+		tgtConst = uint64(int64(int8(tgtConst)))
+	case 2:
+		tgtConst = uint64(int64(int16(tgtConst)))
+	case 4:
+		tgtConst = uint64(int64(int32(tgtConst)))
 	}
 
-	const leftOperandReg = asm.R3
+	shift := int32(64 - tgt.sizof*8)
+	insns = append(insns,
+		asm.LSh.Imm(reg, shift),  // reg <<= shift
+		asm.ArSh.Imm(reg, shift), // reg s>>= shift
+	)
+
+	return insns, tgtConst
+}
 
-	var jmpOpCode asm.JumpOp
+func op2jump(op cc.ExprOp, isSigned bool) (asm.JumpOp, error) {
 	switch op {
 	case cc.Eq, cc.EqEq:
-		// if r3 == tgtConst, goto __return
-		jmpOpCode = asm.JEq
+		return asm.JEq, nil
 
 	case cc.NotEq:
-		// if r3 != tgtConst, goto __return
-		jmpOpCode = asm.JNE
+		return asm.JNE, nil
 
 	case cc.Lt:
-		// if r3 < tgtConst, goto __return
 		if isSigned {
-			jmpOpCode = asm.JSLT
-		} else {
-			jmpOpCode = asm.JLT
+			return asm.JSLT, nil
 		}
+		return asm.JLT, nil
 
 	case cc.LtEq:
-		// if r3 <= tgtConst, goto __return
 		if isSigned {
-			jmpOpCode = asm.JSLE
-		} else {
-			jmpOpCode = asm.JLE
+			return asm.JSLE, nil
 		}
+		return asm.JLE, nil
 
 	case cc.Gt:
-		// if r3 > tgtConst, goto __return
 		if isSigned {
-			jmpOpCode = asm.JSGT
-		} else {
-			jmpOpCode = asm.JGT
+			return asm.JSGT, nil
 		}
+		return asm.JGT, nil
 
 	case cc.GtEq:
-		// if r3 >= tgtConst, goto __return
 		if isSigned {
-			jmpOpCode = asm.JSGE
-		} else {
-			jmpOpCode = asm.JGE
+			return asm.JSGE, nil
 		}
+		return asm.JGE, nil
 
 	default:
-		return nil, fmt.Errorf("unexpected operator: %s; must be one of =, ==, !=, <, <=, >, >=", op)
+		return asm.InvalidJumpOp, fmt.Errorf("unexpected operator: %s; must be one of =, ==, !=, <, <=, >, >=", op)
 	}
+}
 
-	insns = append(insns,
-		asm.Mov.Imm(asm.R0, 1), // r0 = 1
-		jmpOpCode.Imm(leftOperandReg, int32(tgt.constant), labelReturn),
-	)
+// isSignedType reports whether the type is a signed integer or a signed enum,
+// whose typedefs and qualifiers are resolved.
+func isSignedType(t btf.Type) bool {
+	switch v := mybtf.UnderlyingType(t).(type) {
+	case *btf.Int:
+		return v.Encoding == btf.Signed
+	case *btf.Enum:
+		return v.Signed
+	default:
+		return false
+	}
+}
+
+func op2insns(insns asm.Instructions, op cc.ExprOp, tgt tgtInfo) (asm.Instructions, error) {
+	isSigned := isSignedType(tgt.typ)
+
+	const leftOperandReg = asm.R3
+
+	// if r3 <op> tgtConst, goto __return
+	jmpOpCode, err := op2jump(op, isSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	insns = append(insns, asm.Mov.Imm(asm.R0, 1)) // r0 = 1
+	switch c := int64(tgt.constant); {
+	case tgt.srcReg != 0:
+		insns = append(insns, jmpOpCode.Reg(leftOperandReg, tgt.srcReg, labelReturn))
+
+	case c < math.MinInt32 || c > math.MaxInt32:
+		// The imm32 of the jump is sign-extended to 64 bits, so the
+		// constant out of its range is compared in r2.
+		insns = append(insns,
+			asm.LoadImm(asm.R2, c, asm.DWord),                  // r2 = constant
+			jmpOpCode.Reg(leftOperandReg, asm.R2, labelReturn), // if r3 <op> r2, goto __return
+		)
+
+	default:
+		insns = append(insns, jmpOpCode.Imm(leftOperandReg, int32(c), labelReturn))
+	}
 
 	return insns, nil
 }
 
+// checkConstWidth rejects the constant wider than the field of bits, which
+// must be either unsigned or negative in the width of the field.
+func checkConstWidth(constant uint64, bits int) error {
+	if bits >= 64 || constant>>bits == 0 {
+		return nil
+	}
+	if c := int64(constant); c < 0 && c >= -(1<<(bits-1)) {
+		return nil
+	}
+
+	return fmt.Errorf("constant %#x is wider than the %d-bit field", constant, bits)
+}
+
 func checkLastField(member *btf.Member, t btf.Type) (int, error) {
 	if IsMemberBitfield(member) {
 		bits := (member.Offset & 0x7) + member.BitfieldSize
@@ -348,54 +736,354 @@ func checkLastField(member *btf.Member, t btf.Type) (int, error) {
 	}
 }
 
-func compile(expr *cc.Expr, typ btf.Type) (asm.Instructions, error) {
+func directLoadInsns(insns asm.Instructions, ast astInfo, sizofLastField int, labelExit string) (asm.Instructions, bool, error) {
+	for _, off := range ast.offsets {
+		if off := int32(off); off < math.MinInt16 || off > math.MaxInt16 {
+			return nil, false, fmt.Errorf("offset %d is too large to load directly", off)
+		}
+	}
+
+	// The bitfield is read in 8 bytes like bpf_probe_read_kernel(), as it
+	// may span the boundary of its type.
+	size := asm.DWord
+	if !IsMemberBitfield(ast.member) {
+		switch sizofLastField {
+		case 1:
+			size = asm.Byte
+		case 2:
+			size = asm.Half
+		case 4:
+			size = asm.Word
+		}
+	}
+
+	insns, labelUsed := directLoad2insns(insns, ast.offsets, asm.R3, labelExit, size)
+	return insns, labelUsed, nil
+}
+
+// contextLoadInsns loads the field of the context like ctx->di or
+// ctx->args[0] directly, as the context is a valid pointer, and reads the
+// following pointers by bpf_probe_read_kernel().
+func contextLoadInsns(insns asm.Instructions, ast astInfo, sizofLastField int, labelExit string) (asm.Instructions, bool, error) {
+	if len(ast.offsets) <= 1 {
+		return directLoadInsns(insns, ast, sizofLastField, labelExit)
+	}
+
+	off := int32(ast.offsets[0])
+	if off < math.MinInt16 || off > math.MaxInt16 {
+		return nil, false, fmt.Errorf("offset %d is too large to load directly", off)
+	}
+
+	insns = append(insns,
+		asm.LoadMem(asm.R3, asm.R3, int16(off), asm.DWord), // r3 = *(r3 + offset)
+		asm.JEq.Imm(asm.R3, 0, labelExit),                  // if r3 == 0, goto __exit
+	)
+
+	n := len(insns)
+	insns, _ = offset2insns(insns, ast.offsets[1:], asm.R3, labelExit, false)
+	if len(ast.userReads) > 1 {
+		userRead2insns(insns[n:], ast.userReads[1:])
+	}
+	if ast.bigEndian && !IsMemberBitfield(ast.member) {
+		insns = narrowLastLoad(insns, sizofLastField)
+	}
+
+	return insns, true, nil
+}
+
+func compile(expr *cc.Expr, opts CompileOptions) (asm.Instructions, error) {
+	insns, _, err := compileTarget(expr, opts)
+	return insns, err
+}
+
+// noTarget returns the instructions of the comparison without target constant,
+// e.g. the string comparison.
+func noTarget(insns asm.Instructions, err error) (asm.Instructions, tgtInfo, error) {
+	return insns, tgtInfo{}, err
+}
+
+// compileTarget is like compile but returns the target of the comparison too,
+// whose constant is in the width and byte order of the field.
+func compileTarget(expr *cc.Expr, opts CompileOptions) (asm.Instructions, tgtInfo, error) {
 	if expr == nil || expr.Right == nil {
-		return nil, fmt.Errorf("expression or right operand is nil")
+		return nil, tgtInfo{}, fmt.Errorf("expression or right operand is nil")
 	}
 
-	ri, err := parseRightOperand(expr.Right)
+	if opts.CompareReg != 0 {
+		if expr.Right.Op == cc.String || (expr.Left != nil && expr.Left.Op == cc.SizeofExpr) {
+			return nil, tgtInfo{}, fmt.Errorf("cannot compare string or sizeof with register %s", opts.CompareReg)
+		}
+	}
+
+	// skb->tstamp < now() compares with the current time instead of constant.
+	now := isNow(expr.Right)
+	if now {
+		if err := checkValueRef(expr.Left, opts, nowFunc+"()"); err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	// skb->len > @rodata.threshold compares with the .rodata variable.
+	rodataName, rodata := rodataRef(expr.Right)
+	if rodata {
+		if err := checkValueRef(expr.Left, opts, "@rodata."+rodataName); err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	if expr.Right.Op == cc.String {
+		return noTarget(compileString(expr, opts))
+	}
+
+	if name, ok := mapRef(expr.Right); ok {
+		return noTarget(compileMapLookup(expr, name, opts))
+	}
+
+	var (
+		rodataOff    uint32
+		rodataSize   int
+		rodataSigned bool
+		err          error
+	)
+	if rodata {
+		rodataOff, rodataSize, rodataSigned, err = rodataVar(opts.Rodata, rodataName)
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	// The right operand is only a placeholder when comparing with the
+	// register, like skb->len > threshold.
+	var ri rightInfo
+	if opts.CompareReg == 0 && !now && !rodata {
+		ri, err = parseRightOperand(expr.Right)
+		if err != nil {
+			return nil, tgtInfo{}, fmt.Errorf("failed to parse right operand: %w", err)
+		}
+	}
+
+	if insns, ok, err := compileStandalone(expr, ri, opts); ok {
+		return noTarget(insns, err)
+	}
+
+	left, m, err := peelLeft(expr.Left, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse right operand: %w", err)
+		return nil, tgtInfo{}, err
 	}
 
-	ast, err := expr2offset(expr.Left, typ)
+	var ast astInfo
+	if left != nil && left.Op == cc.Indir {
+		ast, err = rawAccess(left)
+	} else {
+		ast, err = expr2offsetWithLookup(left, opts.Type, opts.typeLookup(), opts.fieldAliases())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert expr to access offsets: %w", err)
+		return nil, tgtInfo{}, fmt.Errorf("failed to convert expr to access offsets: %w", err)
+	}
+
+	if opts.CgroupSkb {
+		if err := checkCgroupSkb(left, ast, opts.Type); err != nil {
+			return nil, tgtInfo{}, err
+		}
 	}
 
-	err = ri.enum2const(ast.lastField)
+	enumType := ast.lastField
+	if _, isEnum := mybtf.UnderlyingType(enumType).(*btf.Enum); !isEnum && ri.enum != "" {
+		// Compare a non-enum field with an enum value, e.g. sk->sk_protocol ==
+		// IPPROTO_TCP, whose enum type has to be found in the spec or by
+		// the resolver.
+		if enum, err := findEnum(&opts, ri.enum); err == nil {
+			enumType = enum
+		}
+	}
+
+	err = ri.enum2const(enumType)
+	if constant, ok := opts.Constants[ri.enum]; err != nil && ok && ri.expr == nil {
+		// skb->protocol == ETH_P_IP with user-defined ETH_P_IP.
+		ri.constant, err = constant, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert enum to constant: %w", err)
+		return nil, tgtInfo{}, fmt.Errorf("failed to convert enum to constant: %w", err)
+	}
+
+	if m.cast != nil {
+		if IsMemberBitfield(ast.member) {
+			return nil, tgtInfo{}, fmt.Errorf("cannot cast bitfield '%s'", ast.member.Name)
+		}
+
+		ast.member = nil
+		ast.lastField = m.cast
+		ast.bigEndian = false
+	}
+
+	if opts.ForceLittleEndian {
+		// The __be32 field is in host byte order in the context, so it's
+		// neither narrowed nor swapped.
+		ast.bigEndian = false
 	}
 
 	sizofLastField, err := checkLastField(ast.member, ast.lastField)
 	if err != nil {
-		return nil, err
+		return nil, tgtInfo{}, err
+	}
+
+	if m.abs {
+		err = checkAbsField(ast)
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	var satMax uint64
+	if m.addend != 0 {
+		satMax, err = satAddMax(ast, sizofLastField, m.addend)
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	if m.byteIndex >= 0 {
+		r, err := byteRange(m.byteIndex, sizofLastField, ast.bigEndian || opts.ForceBigEndian, isBigEndian(opts.byteOrder()))
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+		m.bitsRange = &r
+	}
+
+	if m.bitsRange != nil {
+		if IsMemberBitfield(ast.member) {
+			return nil, tgtInfo{}, fmt.Errorf("cannot extract bits of bitfield '%s'", ast.member.Name)
+		}
+		if err := m.bitsRange.check(sizofLastField); err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	cmpType := ast.lastField
+	if m.transformed() {
+		cmpType = nil
+	}
+	if IsMemberBitfield(ast.member) && cmpType != nil && opts.CompareReg == 0 && !now && !rodata {
+		if err := checkBitfieldConstant(ri.constant, ast.member, isSignedType(ast.lastField)); err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+	if match, ok := foldUnsignedZero(expr.Op, ri.constant, cmpType); ok && opts.CompareReg == 0 && !now && !rodata {
+		return verdict2insns(match), tgtInfo{constant: ri.constant}, nil
 	}
 
 	// Use R1/R2/R3 caller-saved registers directly.
 
-	var insns asm.Instructions
+	var (
+		insns     asm.Instructions
+		labelUsed bool
+	)
+	labelFail := opts.failLabel()
+	if opts.SkStorage != nil {
+		insns = skStorage2insns(insns, opts.SkStorage, labelFail)
+		labelUsed = true
+	}
+
 	insns = append(insns,
 		asm.Mov.Reg(asm.R3, asm.R1), // r3 = r1
 	)
 
-	insns, labelUsed := offset2insns(insns, ast.offsets, asm.R3, labelExitFail, false)
+	var used bool
+	start := len(insns)
+	if (opts.UseDirectLoad || opts.CgroupSkb) && ast.hasUserRead() {
+		return nil, tgtInfo{}, fmt.Errorf("cannot load the field of __user pointer directly")
+	}
+	if opts.UseDirectLoad || opts.CgroupSkb {
+		insns, used, err = directLoadInsns(insns, ast, sizofLastField, labelFail)
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+	} else if opts.DirectContext {
+		insns, used, err = contextLoadInsns(insns, ast, sizofLastField, labelFail)
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+	} else {
+		n := len(insns)
+		insns, used = offset2insns(insns, ast.offsets, asm.R3, labelFail, false)
+		userRead2insns(insns[n:], ast.userReads)
+		if ast.bigEndian && !IsMemberBitfield(ast.member) {
+			// Masking the 8 bytes to be16/be32 is right only on little
+			// endian hosts.
+			insns = narrowLastLoad(insns, sizofLastField)
+		}
+	}
+	labelUsed = labelUsed || used
+
+	// The 32-bit load zero-extends the 4-byte field without masking.
+	wordLoad := opts.WordLoad && sizofLastField == 4 && !IsMemberBitfield(ast.member)
+	if wordLoad {
+		insns = narrowLastLoad(insns, sizofLastField)
+	}
+	if opts.Annotate {
+		annotateReads(insns[start:], ast.paths)
+	}
 
-	tgt := tgtInfo{ri.constant, ast.lastField, sizofLastField, ast.bigEndian}
+	if !m.transformed() && !m.masked && opts.CompareReg == 0 && !now && !rodata {
+		bits := sizofLastField * 8
+		if IsMemberBitfield(ast.member) {
+			bits = int(ast.member.BitfieldSize)
+		}
+		if err := checkConstWidth(ri.constant, bits); err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	bigEndian := ast.bigEndian || opts.ForceBigEndian
+	tgt := tgtInfo{constant: ri.constant, typ: ast.lastField, sizof: sizofLastField, bigEndian: bigEndian, targetBE: isBigEndian(opts.byteOrder()), zeroExtended: wordLoad}
+	// The signed field is compared with the negative constant like -1 in
+	// 64-bit, so it's sign-extended unless it's transformed or masked.
+	tgt.signExtended = isSignedType(ast.lastField) && !bigEndian && cmpType != nil && !m.masked
 	if IsMemberBitfield(ast.member) {
 		insns, tgt.constant = bitfield2insns(insns, tgt.constant, ast.member, asm.R3)
 	} else {
 		insns, tgt.constant = tgt2insns(insns, tgt, asm.R3)
 	}
 
-	insns, err = op2insns(insns, expr.Op, tgt)
+	if m.twiddle && m.bitsRange == nil {
+		bits := sizofLastField * 8
+		if IsMemberBitfield(ast.member) {
+			bits = int(ast.member.BitfieldSize)
+		}
+		insns = twiddle2insns(insns, bits, tgt.signExtended, asm.R3)
+	}
+
+	// The quotient, the remainder and the value of the register are in host
+	// byte order.
+	if (m.transformed() || opts.CompareReg != 0 || now || rodata) && bigEndian && !m.popcount {
+		insns, err = be2host(insns, ast.lastField, bigEndian, asm.R3)
+		if err != nil {
+			return nil, tgtInfo{}, err
+		}
+	}
+
+	if opts.ZeroExtend && (m.divisor != 0 || m.modulus != 0 || m.addend != 0) {
+		insns = zext2insns(insns, sizofLastField, asm.R3)
+	}
+
+	insns, tgt = m.apply(insns, tgt, ri.constant, sizofLastField, satMax)
+
+	tgt.srcReg = opts.CompareReg
+	if now {
+		insns = now2insns(insns, asm.R3)
+		tgt.srcReg = asm.R2
+	}
+	if rodata {
+		insns = rodata2insns(insns, opts.Rodata.Name, rodataOff, rodataSize, rodataSigned)
+		tgt.srcReg = asm.R2
+	}
+	insns, err = emitOp(insns, expr.Op, tgt, opts.OpEmitters)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert operator to instructions: %w", err)
+		return nil, tgtInfo{}, fmt.Errorf("failed to convert operator to instructions: %w", err)
 	}
 
 	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
-	if labelUsed {
+	if labelUsed && labelFail == labelExitFail {
 		xorR0 = xorR0.WithSymbol(labelExitFail)
 	}
 	insns = append(insns,
@@ -403,5 +1091,231 @@ func compile(expr *cc.Expr, typ btf.Type) (asm.Instructions, error) {
 		asm.Return().WithSymbol(labelReturn), // return; __return
 	)
 
-	return insns, nil
+	return insns, tgt, nil
+}
+
+// apply applies the modifiers to the field read in r3, whose target tgt is
+// replaced by the right operand constant if the field is transformed.
+func (m *leftMods) apply(insns asm.Instructions, tgt tgtInfo, constant uint64, sizof int, satMax uint64) (asm.Instructions, tgtInfo) {
+	if m.bitsRange != nil {
+		// The extracted bits are unsigned and in host byte order.
+		insns = bits2insns(insns, *m.bitsRange, asm.R3)
+		tgt = tgtInfo{constant: constant}
+		if m.twiddle {
+			insns = twiddle2insns(insns, m.bitsRange.width(), false, asm.R3)
+		}
+	}
+
+	if m.abs {
+		// The absolute value is compared as unsigned.
+		insns = abs2insns(insns, sizof, asm.R3)
+		tgt = tgtInfo{constant: constant}
+	}
+
+	if m.addend != 0 {
+		// The saturated sum is unsigned and in host byte order.
+		insns = satAdd2insns(insns, m.addend, satMax, asm.R3)
+		tgt = tgtInfo{constant: constant}
+	}
+
+	if m.table != nil {
+		// The translated value is unsigned and in host byte order.
+		insns = translate2insns(insns, m.table, asm.R3)
+		tgt = tgtInfo{constant: constant}
+	}
+
+	if m.popcount {
+		// The number of set bits is unsigned and independent of byte order.
+		insns = popcount2insns(insns, asm.R3)
+		tgt = tgtInfo{constant: constant}
+	}
+
+	if m.divisor != 0 {
+		// The quotient is unsigned and in host byte order.
+		insns = div2insns(insns, m.divisor, asm.R3)
+		tgt = tgtInfo{constant: constant}
+	}
+
+	if m.modulus != 0 {
+		// The remainder is unsigned and in host byte order, as the pointer
+		// is an unsigned 64-bit value.
+		insns = mod2insns(insns, m.modulus, asm.R3)
+		tgt = tgtInfo{constant: constant}
+	}
+
+	if m.masked {
+		insns = mask2insns(insns, m.mask, tgt, asm.R3)
+	}
+
+	return insns, tgt
+}
+
+// isStandaloneLeft reports whether the left operand is compiled by its own
+// compiler, i.e. sizeof, difference, dynamic index, field mask, hash or pid.
+func isStandaloneLeft(left *cc.Expr) bool {
+	return (left != nil && left.Op == cc.SizeofExpr) || isDiff(left) || isDynamicIndex(left) ||
+		isFieldMask(left) || isHash(left) || isPid(left)
+}
+
+// checkValueRef checks the left operand compared with the value reference like
+// now() or @rodata.threshold, which must be a field compared without register.
+func checkValueRef(left *cc.Expr, opts CompileOptions, ref string) error {
+	if opts.CompareReg != 0 || isStandaloneLeft(left) {
+		return fmt.Errorf("cannot compare register, sizeof, difference, dynamic index, field mask, hash or pid with %s", ref)
+	}
+
+	return nil
+}
+
+// compileStandalone compiles the left operand by its own compiler, and reports
+// false if it isn't standalone.
+func compileStandalone(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, bool, error) {
+	var (
+		insns asm.Instructions
+		err   error
+	)
+	switch left := expr.Left; {
+	case left != nil && left.Op == cc.SizeofExpr:
+		insns, err = compileSizeof(expr, ri, opts)
+	case isDiff(left):
+		insns, err = compileDiff(expr, ri, opts)
+	case isDynamicIndex(left):
+		insns, err = compileDynamicIndex(expr, ri, opts)
+	case isFieldMask(left):
+		insns, err = compileFieldMask(expr, ri, opts)
+	case isHash(left):
+		insns, err = compileHash(expr, ri, opts)
+	case isPid(left):
+		insns, err = compilePid(expr, ri, opts)
+	default:
+		return nil, false, nil
+	}
+
+	return insns, true, err
+}
+
+// leftMods are the modifiers of the field peeled off the left operand like
+// (skb->mark & 0xff) or skb->len / 64, which are applied after reading the
+// field in the reverse order.
+type leftMods struct {
+	masked    bool
+	mask      uint64
+	twiddle   bool
+	modulus   uint64
+	divisor   uint64
+	popcount  bool
+	abs       bool
+	addend    uint64
+	table     []translation
+	bitsRange *bitRange
+	byteIndex int // -1 if not byte()
+	cast      *btf.Int
+}
+
+// transformed reports whether the field is transformed to an unsigned value,
+// which isn't compared in the type of the field.
+func (m *leftMods) transformed() bool {
+	return m.popcount || m.abs || m.table != nil || m.divisor != 0 || m.modulus != 0 || m.addend != 0 || m.bitsRange != nil
+}
+
+// peelLeft peels the modifiers off the left operand, and returns the field.
+func peelLeft(left *cc.Expr, opts CompileOptions) (*cc.Expr, leftMods, error) {
+	var err error
+	m := leftMods{byteIndex: -1}
+
+	// (skb->mark & 0xff) compares the masked field.
+	m.masked = isMask(left)
+	if m.masked {
+		m.mask, err = parseMask(left.Left.Right.Text)
+		if err != nil {
+			return nil, m, err
+		}
+		left = left.Left.Left
+	}
+
+	// (~skb->mark & 0x1) negates the field before masking.
+	m.twiddle = isTwiddle(left)
+	if m.twiddle {
+		left = left.Left
+	}
+
+	// skb->data % 8 compares the remainder of the field, e.g. the alignment of
+	// the pointer.
+	if left != nil && left.Op == cc.Mod {
+		m.modulus, err = parseDivisor(left.Right.Text)
+		if err != nil {
+			return nil, m, err
+		}
+		left = left.Left
+	}
+
+	// skb->len / 64 compares the quotient of the field.
+	if left != nil && left.Op == cc.Div {
+		m.divisor, err = parseDivisor(left.Right.Text)
+		if err != nil {
+			return nil, m, err
+		}
+		left = left.Left
+	}
+
+	// popcount(skb->mark) compares the number of set bits of the field.
+	m.popcount = isPopcount(left)
+	if m.popcount {
+		left = left.List[0]
+	}
+
+	// abs(skb->skb_iif) compares the absolute value of the signed field.
+	m.abs = isAbs(left)
+	if m.abs {
+		left = left.List[0]
+	}
+
+	// sat_add(skb->len, 10) compares the sum saturating to the max.
+	if isSatAdd(left) {
+		m.addend, err = parseAddend(left.List[1].Text)
+		if err != nil {
+			return nil, m, err
+		}
+		left = left.List[0]
+	}
+
+	// map(iph->protocol, {1:1, 6:2, 17:3}) compares the translated value.
+	if isTranslate(left) {
+		m.table, err = parseTranslate(left, opts.Constants)
+		if err != nil {
+			return nil, m, err
+		}
+		left = left.List[0]
+	}
+
+	// bits(*(unsigned int *)(skb + 4), 3, 7) compares the bits 3-7 of the
+	// field.
+	if isBits(left) {
+		r, err := parseBitRange(left)
+		if err != nil {
+			return nil, m, err
+		}
+		m.bitsRange, left = &r, left.List[0]
+	}
+
+	// byte(skb->protocol, 0) compares the byte 0 of the field in memory.
+	if isByte(left) {
+		m.byteIndex, err = parseByteIndex(left)
+		if err != nil {
+			return nil, m, err
+		}
+		left = left.List[0]
+	}
+
+	// A cast like (unsigned short)hdr->field reads the field in the width of
+	// the cast type instead of its btf size.
+	if left != nil && left.Op == cc.Cast {
+		m.cast, err = castInt(left.Type)
+		if err != nil {
+			return nil, m, fmt.Errorf("failed to cast left operand: %w", err)
+		}
+		left = left.Left
+	}
+
+	return left, m, nil
 }
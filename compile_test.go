@@ -6,6 +6,7 @@ package bice
 import (
 	"bytes"
 	_ "embed"
+	"encoding/binary"
 	"log"
 	"slices"
 	"testing"
@@ -38,6 +39,28 @@ func getSkbBtf(t *testing.T) *btf.Pointer {
 	return &btf.Pointer{Target: skb}
 }
 
+// getFilesBtf synthesizes the files_struct with an array of pointers to file.
+func getFilesBtf() *btf.Pointer {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4}
+	file := &btf.Struct{
+		Name: "file",
+		Size: 16,
+		Members: []btf.Member{
+			{Name: "f_mode", Type: u32},
+			{Name: "f_flags", Type: u32, Offset: 64},
+		},
+	}
+	files := &btf.Struct{
+		Name: "files_struct",
+		Size: 48,
+		Members: []btf.Member{
+			{Name: "count", Type: &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}},
+			{Name: "fd_array", Type: &btf.Array{Type: &btf.Pointer{Target: file}, Nelems: 4}, Offset: 128},
+		},
+	}
+	return &btf.Pointer{Target: files}
+}
+
 func getBpfProgBtf(t *testing.T) *btf.Pointer {
 	bpfProg, err := testBtf.AnyTypeByName("bpf_prog")
 	test.AssertNoErr(t, err)
@@ -62,6 +85,12 @@ func getKobjBtf(t *testing.T) *btf.Pointer {
 	return &btf.Pointer{Target: kobj}
 }
 
+func getUsbHubBtf(t *testing.T) *btf.Pointer {
+	hub, err := testBtf.AnyTypeByName("usb_hub")
+	test.AssertNoErr(t, err)
+	return &btf.Pointer{Target: hub}
+}
+
 func TestParseRightOperand(t *testing.T) {
 	t.Run("name", func(t *testing.T) {
 		right, err := parse("BPF_PROG_TYPE_SOCKET_FILTER")
@@ -81,6 +110,46 @@ func TestParseRightOperand(t *testing.T) {
 		test.AssertEqual(t, ri.constant, uint64(0x1234))
 	})
 
+	t.Run("twid", func(t *testing.T) {
+		right, err := parse("~0x3")
+		test.AssertNoErr(t, err)
+
+		ri, err := parseRightOperand(right)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, ri.constant, ^uint64(0x3))
+	})
+
+	t.Run("~0x3 on byte field", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "iph->tos == ~0x3", Type: getIphdrBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-5:len(insns)-2], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xFF),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0xFC, labelReturn),
+		})
+	})
+
+	t.Run("or", func(t *testing.T) {
+		right, err := parse("(FAULT_FLAG_WRITE | 0x4)")
+		test.AssertNoErr(t, err)
+
+		ri, err := parseRightOperand(right)
+		test.AssertNoErr(t, err)
+		test.AssertTrue(t, ri.expr != nil)
+		test.AssertEqual(t, ri.expr.Op, cc.Or)
+	})
+
+	t.Run("invalid or", func(t *testing.T) {
+		right, err := parse("FAULT_FLAG_WRITE | 4x")
+		test.AssertNoErr(t, err)
+
+		_, err = parseRightOperand(right)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to parse number 4x")
+	})
+
 	t.Run("unexpected right operand", func(t *testing.T) {
 		right := &cc.Expr{Text: "skb"}
 		_, err := parseRightOperand(right)
@@ -123,6 +192,38 @@ func TestEnum2const(t *testing.T) {
 	})
 }
 
+func TestFoldConstant(t *testing.T) {
+	faultFlag, err := testBtf.AnyTypeByName("fault_flag")
+	test.AssertNoErr(t, err)
+
+	t.Run("or of enum flags", func(t *testing.T) {
+		expr, err := parse("(FAULT_FLAG_WRITE | FAULT_FLAG_ALLOW_RETRY) | 0x100")
+		test.AssertNoErr(t, err)
+
+		constant, err := foldConstant(expr, faultFlag)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, constant, 0x1|0x4|0x100)
+	})
+
+	t.Run("enum not found", func(t *testing.T) {
+		expr, err := parse("FAULT_FLAG_WRITE | FAULT_FLAG_XXX")
+		test.AssertNoErr(t, err)
+
+		_, err = foldConstant(expr, faultFlag)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "FAULT_FLAG_XXX not found in enum fault_flag")
+	})
+
+	t.Run("unexpected constant expression", func(t *testing.T) {
+		expr, err := parse("FAULT_FLAG_WRITE + 1")
+		test.AssertNoErr(t, err)
+
+		_, err = foldConstant(expr, faultFlag)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected constant expression")
+	})
+}
+
 func TestExpr2offset(t *testing.T) {
 	t.Run("empty expr", func(t *testing.T) {
 		_, err := expr2offset(&cc.Expr{}, nil)
@@ -153,6 +254,21 @@ func TestExpr2offset(t *testing.T) {
 		test.AssertTrue(t, ast.lastField == u64)
 	})
 
+	t.Run("typedef of pointer", func(t *testing.T) {
+		expr, err := parse("skb->dev->ifindex == 1")
+		test.AssertNoErr(t, err)
+
+		skb := &btf.Typedef{Name: "skb_handle_t", Type: &btf.Const{Type: getSkbBtf(t)}}
+		ast, err := expr2offset(expr.Left, skb)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{16, 224})
+		test.AssertEqual(t, typeName(ast.lastField), "int")
+
+		res, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 1", Type: skb})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, res.Describe(), "read sk_buff.dev(+16)->ifindex(+224) as int; match if == 1")
+	})
+
 	t.Run("skb->len > 1024", func(t *testing.T) {
 		expr, err := parse("skb->len > 1024")
 		test.AssertNoErr(t, err)
@@ -168,6 +284,40 @@ func TestExpr2offset(t *testing.T) {
 		test.AssertFalse(t, ast.bigEndian)
 	})
 
+	t.Run("skb.len > 1024", func(t *testing.T) {
+		expr, err := parse("skb.len > 1024")
+		test.AssertNoErr(t, err)
+
+		ast, err := expr2offset(expr.Left, getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{112})
+	})
+
+	t.Run("skb->dev.ifindex == 1", func(t *testing.T) {
+		expr, err := parse("skb->dev->ifindex == 1")
+		test.AssertNoErr(t, err)
+
+		want, err := expr2offset(expr.Left, getSkbBtf(t))
+		test.AssertNoErr(t, err)
+
+		expr, err = parse("skb->dev.ifindex == 1")
+		test.AssertNoErr(t, err)
+
+		ast, err := expr2offset(expr.Left, getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, want.offsets)
+		test.AssertTrue(t, ast.lastField == want.lastField)
+	})
+
+	t.Run("struct value skb->len > 1024", func(t *testing.T) {
+		expr, err := parse("skb->len > 1024")
+		test.AssertNoErr(t, err)
+
+		_, err = expr2offset(expr.Left, getSkbBtf(t).Target)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "cannot access member len of sk_buff value skb; use -> on a pointer to sk_buff instead")
+	})
+
 	t.Run("skb->vlan_tci == 1000", func(t *testing.T) {
 		expr, err := parse("skb->vlan_tci == 1000")
 		test.AssertNoErr(t, err)
@@ -252,6 +402,112 @@ func TestExpr2offset(t *testing.T) {
 		test.AssertEqual(t, len(ast.offsets), 1)
 		test.AssertEqual(t, ast.offsets[0], 3)
 	})
+
+	t.Run("skb->cb[2] == 0", func(t *testing.T) {
+		expr, err := parse("skb->cb[2] == 0")
+		test.AssertNoErr(t, err)
+
+		skb := getSkbBtf(t)
+
+		ast, err := expr2offset(expr.Left, skb)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{40 + 2})
+		test.AssertTrue(t, ast.member == nil)
+		test.AssertTrue(t, mybtf.IsChar(ast.lastField))
+	})
+
+	t.Run("hub->buffer[2] == 0", func(t *testing.T) {
+		expr, err := parse("hub->buffer[2] == 0")
+		test.AssertNoErr(t, err)
+
+		ast, err := expr2offset(expr.Left, getUsbHubBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{32, 2})
+		test.AssertTrue(t, ast.member == nil)
+
+		size, err := btf.Sizeof(ast.lastField)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, size, 1)
+	})
+
+	t.Run("files->fd_array[3]->f_flags == 0", func(t *testing.T) {
+		expr, err := parse("files->fd_array[3]->f_flags == 0")
+		test.AssertNoErr(t, err)
+
+		ast, err := expr2offset(expr.Left, getFilesBtf())
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{16 + 3*8, 8})
+		test.AssertEqualSlice(t, ast.paths, []string{"files->fd_array[3]", "files->fd_array[3]->f_flags"})
+		test.AssertEqual(t, ast.member.Name, "f_flags")
+
+		res, err := Compile(CompileOptions{Expr: "files->fd_array[3]->f_flags == 0", Type: getFilesBtf()})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:16], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 16+3*8),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Add.Imm(asm.R3, 8),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+		})
+	})
+
+	t.Run("index out of range", func(t *testing.T) {
+		expr, err := parse("hub->buffer[8] == 0")
+		test.AssertNoErr(t, err)
+
+		_, err = expr2offset(expr.Left, getUsbHubBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "index 8 out of range")
+	})
+
+	t.Run("skb->cb[-1] == 0", func(t *testing.T) {
+		expr, err := parse("skb->cb[-1] == 0")
+		test.AssertNoErr(t, err)
+		test.AssertNoErr(t, validate(expr))
+
+		ast, err := expr2offset(expr.Left, getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{40 + 47})
+		test.AssertTrue(t, mybtf.IsChar(ast.lastField))
+	})
+
+	t.Run("hub->buffer[-8] == 0", func(t *testing.T) {
+		expr, err := parse("hub->buffer[-8] == 0")
+		test.AssertNoErr(t, err)
+
+		ast, err := expr2offset(expr.Left, getUsbHubBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{32, 0})
+	})
+
+	t.Run("negative index out of range", func(t *testing.T) {
+		expr, err := parse("hub->buffer[-9] == 0")
+		test.AssertNoErr(t, err)
+
+		_, err = expr2offset(expr.Left, getUsbHubBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "index -9 out of range")
+	})
+
+	t.Run("index of non-array", func(t *testing.T) {
+		expr, err := parse("skb->len[0] == 0")
+		test.AssertNoErr(t, err)
+
+		_, err = expr2offset(expr.Left, getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected type *btf.Int")
+	})
 }
 
 type offsetinsns struct {
@@ -331,6 +587,51 @@ var testOffsetsInsnsCases = []offsetinsns{
 	},
 }
 
+func TestNarrowLastLoad(t *testing.T) {
+	insns := narrowLastLoad(asm.Instructions{
+		asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+	}, 2)
+	test.AssertEqualSlice(t, insns, asm.Instructions{
+		asm.LoadMem(asm.R3, asm.R10, -8, asm.Half),
+	})
+
+	insns = narrowLastLoad(asm.Instructions{
+		asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+	}, 8)
+	test.AssertEqualSlice(t, insns, asm.Instructions{
+		asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+	})
+
+	insns = narrowLastLoad(asm.Instructions{
+		asm.Mov.Reg(asm.R3, asm.R1),
+	}, 4)
+	test.AssertEqualSlice(t, insns, asm.Instructions{
+		asm.Mov.Reg(asm.R3, asm.R1),
+	})
+}
+
+func TestCompileBigEndianPacket(t *testing.T) {
+	// The read buffer of skb->protocol, which is ETH_P_IP followed by
+	// skb->inner_ipproto and others.
+	buf := []byte{0x08, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: "skb->protocol == 0x0800", Type: getSkbBtf(t), ByteOrder: order})
+			test.AssertNoErr(t, err)
+
+			insns := res.Insns
+			load := insns[6]
+			test.AssertEqual(t, load.OpCode.Size(), asm.Half)
+
+			jeq := insns[len(insns)-3]
+			regs := map[asm.Register]uint64{asm.R3: uint64(order.Uint16(buf))}
+			runALU64(t, insns[7:len(insns)-3], regs)
+			test.AssertEqual(t, regs[asm.R3], uint64(jeq.Constant))
+		})
+	}
+}
+
 func TestOffset2insns(t *testing.T) {
 	t.Run("empty offset", func(t *testing.T) {
 		insns, _ := offset2insns(nil, nil, asm.R3, labelExitFail, false)
@@ -355,6 +656,20 @@ func TestOffset2insns(t *testing.T) {
 		test.AssertEqualSlice(t, insns, cas.insns)
 	})
 
+	t.Run("negative offset", func(t *testing.T) {
+		neg := int32(-8)
+		insns, labelUsed := offset2insns(nil, []uint32{uint32(neg)}, asm.R3, labelExitFail, false)
+		test.AssertFalse(t, labelUsed)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Add.Imm(asm.R3, -8),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+		})
+	})
+
 	t.Run("dont read last field", func(t *testing.T) {
 		cas := testOffsetsInsnsCases[3]
 		insns, _ := offset2insns(nil, cas.offsets, asm.R1, labelExitFail, true)
@@ -362,6 +677,24 @@ func TestOffset2insns(t *testing.T) {
 	})
 }
 
+func TestDirectLoad2insns(t *testing.T) {
+	t.Run("empty offset", func(t *testing.T) {
+		insns, labelUsed := directLoad2insns(nil, nil, asm.R3, labelExitFail, asm.DWord)
+		test.AssertEmptySlice(t, insns)
+		test.AssertFalse(t, labelUsed)
+	})
+
+	t.Run("offsets = [16, 224]", func(t *testing.T) {
+		insns, labelUsed := directLoad2insns(nil, []uint32{16, 224}, asm.R3, labelExitFail, asm.Word)
+		test.AssertTrue(t, labelUsed)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R3, 16, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.LoadMem(asm.R3, asm.R3, 224, asm.Word),
+		})
+	})
+}
+
 func TestBitfield2insns(t *testing.T) {
 	t.Run("non-zero delta", func(t *testing.T) {
 		var member btf.Member
@@ -483,6 +816,39 @@ func TestTgt2insns(t *testing.T) {
 			test.AssertEqual(t, constant, tt.expConst)
 		})
 	}
+
+	t.Run("big-endian target", func(t *testing.T) {
+		for _, tt := range tests {
+			// The big endian constant is in target byte order already.
+			tt.tgt.targetBE = true
+			constant := tt.tgt.constant
+			switch tt.tgt.sizof {
+			case 1:
+				constant = uint64(uint8(constant))
+			case 2:
+				constant = uint64(uint16(constant))
+			case 4:
+				constant = uint64(uint32(constant))
+			}
+
+			insns, got := tgt2insns(nil, tt.tgt, asm.R3)
+			test.AssertEqualSlice(t, insns, tt.expInsns)
+			test.AssertEqual(t, got, constant)
+		}
+	})
+}
+
+func TestCheckConstWidth(t *testing.T) {
+	test.AssertNoErr(t, checkConstWidth(0xff, 8))
+	test.AssertNoErr(t, checkConstWidth(0xfffffffffffffffc, 8))
+	test.AssertNoErr(t, checkConstWidth(0xffffffffffffffff, 64))
+
+	err := checkConstWidth(0x100, 8)
+	test.AssertHaveErr(t, err)
+	test.AssertStrPrefix(t, err.Error(), "constant 0x100 is wider than the 8-bit field")
+
+	err = checkConstWidth(0xffffffffffffff7f, 8)
+	test.AssertHaveErr(t, err)
 }
 
 func TestOp2insns(t *testing.T) {
@@ -626,6 +992,31 @@ func TestOp2insns(t *testing.T) {
 				asm.JSGE.Imm(asm.R3, tgtConst, labelReturn),
 			},
 		},
+		{
+			name: "u32 high bit",
+			op:   cc.EqEq,
+			tgt: tgtInfo{
+				typ:      &btf.Int{Encoding: btf.Unsigned},
+				constant: 0x80000000,
+			},
+			expInsns: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 1),
+				asm.LoadImm(asm.R2, 0x80000000, asm.DWord),
+				asm.JEq.Reg(asm.R3, asm.R2, labelReturn),
+			},
+		},
+		{
+			name: "negative",
+			op:   cc.Lt,
+			tgt: tgtInfo{
+				typ:      &btf.Int{Encoding: btf.Signed},
+				constant: 0xffffffffffffffff,
+			},
+			expInsns: asm.Instructions{
+				asm.Mov.Imm(asm.R0, 1),
+				asm.JSLT.Imm(asm.R3, -1, labelReturn),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -683,10 +1074,10 @@ func TestCheckLastField(t *testing.T) {
 
 func TestCompile(t *testing.T) {
 	t.Run("nil expr", func(t *testing.T) {
-		_, err := compile(nil, nil)
+		_, err := compile(nil, CompileOptions{})
 		test.AssertHaveErr(t, err)
 
-		_, err = compile(&cc.Expr{}, nil)
+		_, err = compile(&cc.Expr{}, CompileOptions{})
 		test.AssertHaveErr(t, err)
 	})
 
@@ -694,7 +1085,7 @@ func TestCompile(t *testing.T) {
 		expr, err := parse("skb->len > 1024x")
 		test.AssertNoErr(t, err)
 
-		_, err = compile(expr, nil)
+		_, err = compile(expr, CompileOptions{})
 		test.AssertHaveErr(t, err)
 		test.AssertStrPrefix(t, err.Error(), "failed to parse right operand")
 	})
@@ -703,7 +1094,7 @@ func TestCompile(t *testing.T) {
 		expr, err := parse("skb->xxx == 0")
 		test.AssertNoErr(t, err)
 
-		_, err = compile(expr, getSkbBtf(t))
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
 		test.AssertHaveErr(t, err)
 		test.AssertStrPrefix(t, err.Error(), "failed to convert expr to access offsets")
 	})
@@ -712,7 +1103,7 @@ func TestCompile(t *testing.T) {
 		expr, err := parse("prog->type == BPF_PROG_TYPE_XXX")
 		test.AssertNoErr(t, err)
 
-		_, err = compile(expr, getBpfProgBtf(t))
+		_, err = compile(expr, CompileOptions{Type: getBpfProgBtf(t)})
 		test.AssertHaveErr(t, err)
 		test.AssertStrPrefix(t, err.Error(), "failed to convert enum to constant")
 	})
@@ -721,7 +1112,7 @@ func TestCompile(t *testing.T) {
 		expr, err := parse("skb->users == 0")
 		test.AssertNoErr(t, err)
 
-		_, err = compile(expr, getSkbBtf(t))
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
 		test.AssertHaveErr(t, err)
 		test.AssertStrPrefix(t, err.Error(), "unexpected type of last field")
 	})
@@ -731,7 +1122,7 @@ func TestCompile(t *testing.T) {
 		test.AssertNoErr(t, err)
 		test.AssertEqual(t, expr.Op, cc.Mul)
 
-		_, err = compile(expr, getSkbBtf(t))
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
 		test.AssertHaveErr(t, err)
 		test.AssertStrPrefix(t, err.Error(), "failed to convert operator to instructions")
 	})
@@ -740,7 +1131,7 @@ func TestCompile(t *testing.T) {
 		expr, err := parse("skb != 0")
 		test.AssertNoErr(t, err)
 
-		insns, err := compile(expr, getSkbBtf(t))
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
 		test.AssertNoErr(t, err)
 
 		test.AssertEqualSlice(t, insns, asm.Instructions{
@@ -756,64 +1147,663 @@ func TestCompile(t *testing.T) {
 		expr, err := parse("skb->len > 1024")
 		test.AssertNoErr(t, err)
 
-		insns, err := compile(expr, getSkbBtf(t))
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
 		test.AssertNoErr(t, err)
 
 		test.AssertEqualSlice(t, insns, cloneSkbLen1024InsnsWithoutExitLabel())
 	})
 
-	t.Run("skb->pkt_type == 3", func(t *testing.T) {
-		expr, err := parse("skb->pkt_type == 3")
+	t.Run("force big endian skb->len == 1024", func(t *testing.T) {
+		expr, err := parse("skb->len == 1024")
 		test.AssertNoErr(t, err)
 
-		insns, err := compile(expr, getSkbBtf(t))
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t), ForceBigEndian: true})
 		test.AssertNoErr(t, err)
 
-		test.AssertEqualSlice(t, insns, asm.Instructions{
-			asm.Mov.Reg(asm.R3, asm.R1),
-			asm.Mov.Imm(asm.R2, 8),
-			asm.Mov.Reg(asm.R1, asm.R10),
-			asm.Add.Imm(asm.R1, -8),
-			asm.FnProbeReadKernel.Call(),
-			asm.LoadMem(asm.R3, asm.RFP, -8, asm.DWord),
-			asm.And.Imm(asm.R3, 0x7),
+		test.AssertEqualSlice(t, insns[len(insns)-3:], asm.Instructions{
+			asm.JEq.Imm(asm.R3, int32(h2nl(1024, isBigEndian(nativeEndian))), labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("force big endian skb->len == r9", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len == 1", Type: getSkbBtf(t), ForceBigEndian: true, CompareReg: asm.R9})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-5:len(insns)-2], asm.Instructions{
+			asm.HostTo(asm.BE, asm.R3, asm.Word),
 			asm.Mov.Imm(asm.R0, 1),
-			asm.JEq.Imm(asm.R3, 3, labelReturn),
+			asm.JEq.Reg(asm.R3, asm.R9, labelReturn),
+		})
+	})
+
+	t.Run("force big endian skb->len / 4 == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len / 4 == 1", Type: getSkbBtf(t), ForceBigEndian: true})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-6:len(insns)-2], asm.Instructions{
+			asm.HostTo(asm.BE, asm.R3, asm.Word),
+			asm.RSh.Imm(asm.R3, 2),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 1, labelReturn),
+		})
+	})
+
+	t.Run("force little endian iph->saddr == 0x0a000001", func(t *testing.T) {
+		expr, err := parse("iph->saddr == 0x0a000001")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getIphdrBtf(t), ForceLittleEndian: true})
+		test.AssertNoErr(t, err)
+
+		for _, insn := range insns {
+			test.AssertFalse(t, insn.OpCode.ALUOp() == asm.Swap)
+		}
+		test.AssertEqualSlice(t, insns[len(insns)-7:], asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0x0a000001, labelReturn),
 			asm.Xor.Reg(asm.R0, asm.R0),
 			asm.Return().WithSymbol(labelReturn),
 		})
+
+		_, err = Compile(CompileOptions{Expr: "iph->saddr == 0x0a000001", Type: getIphdrBtf(t), ForceBigEndian: true, ForceLittleEndian: true})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "cannot force both big endian and little endian")
 	})
 
-	t.Run("use label", func(t *testing.T) {
+	t.Run("direct load skb->dev->ifindex == 9", func(t *testing.T) {
 		expr, err := parse("skb->dev->ifindex == 9")
 		test.AssertNoErr(t, err)
 
-		insns, err := compile(expr, getSkbBtf(t))
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t), UseDirectLoad: true})
 		test.AssertNoErr(t, err)
 
+		for _, insn := range insns {
+			test.AssertFalse(t, insn.IsBuiltinCall())
+		}
 		test.AssertEqualSlice(t, insns, asm.Instructions{
 			asm.Mov.Reg(asm.R3, asm.R1),
-			asm.Add.Imm(asm.R3, 16),
-			asm.Mov.Imm(asm.R2, 8),
-			asm.Mov.Reg(asm.R1, asm.R10),
-			asm.Add.Imm(asm.R1, -8),
-			asm.FnProbeReadKernel.Call(),
-			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LoadMem(asm.R3, asm.R3, 16, asm.DWord),
 			asm.JEq.Imm(asm.R3, 0, labelExitFail),
-			asm.Add.Imm(asm.R3, 224),
-			asm.Mov.Imm(asm.R2, 8),
-			asm.Mov.Reg(asm.R1, asm.R10),
-			asm.Add.Imm(asm.R1, -8),
-			asm.FnProbeReadKernel.Call(),
-			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LoadMem(asm.R3, asm.R3, 224, asm.Word),
 			asm.LSh.Imm(asm.R3, 32),
-			asm.RSh.Imm(asm.R3, 32),
+			asm.ArSh.Imm(asm.R3, 32),
 			asm.Mov.Imm(asm.R0, 1),
 			asm.JEq.Imm(asm.R3, 9, labelReturn),
 			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
 			asm.Return().WithSymbol(labelReturn),
 		})
 	})
+
+	t.Run("direct load skb->pkt_type == 3", func(t *testing.T) {
+		expr, err := parse("skb->pkt_type == 3")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t), UseDirectLoad: true})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns[:3], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 0, asm.DWord),
+			asm.And.Imm(asm.R3, 0x7),
+		})
+	})
+
+	t.Run("skb->pkt_type == 3", func(t *testing.T) {
+		expr, err := parse("skb->pkt_type == 3")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.RFP, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 0x7),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 3, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->pkt_type == 0b101", func(t *testing.T) {
+		expr, err := parse("skb->pkt_type == 0b101")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns[len(insns)-5:], asm.Instructions{
+			asm.And.Imm(asm.R3, 0x7),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 5, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->pkt_type == 8", func(t *testing.T) {
+		expr, err := parse("skb->pkt_type == 8")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "constant 8 exceeds 3 bits of bitfield 'pkt_type'")
+	})
+
+	t.Run("signed bitfield", func(t *testing.T) {
+		s32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+		typ := &btf.Pointer{Target: &btf.Struct{
+			Name:    "s",
+			Size:    4,
+			Members: []btf.Member{{Name: "v", Type: s32, Offset: 4, BitfieldSize: 4}},
+		}}
+
+		expr, err := parse("s->v == -8")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: typ})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns[len(insns)-6:len(insns)-2], asm.Instructions{
+			asm.RSh.Imm(asm.R3, 4),
+			asm.And.Imm(asm.R3, 0xF),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 8, labelReturn),
+		})
+
+		expr, err = parse("s->v == -9")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: typ})
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "constant -9 exceeds 4 bits of bitfield 'v'")
+	})
+
+	t.Run("use label", func(t *testing.T) {
+		expr, err := parse("skb->dev->ifindex == 9")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 16),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Add.Imm(asm.R3, 224),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.ArSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 9, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("hub->buffer[2] == 1", func(t *testing.T) {
+		expr, err := parse("hub->buffer[2] == 1")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getUsbHubBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Add.Imm(asm.R3, 2),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 0xFF),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 1, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("no read for dot hops", func(t *testing.T) {
+		tests := []struct {
+			expr  string
+			adds  []int64
+			reads int
+		}{
+			{"skb->headers.transport_header == 1", []int64{182}, 1},
+			{"skb->dev->dev.kobj.name == 0", []int64{16, 1464}, 2},
+		}
+
+		for _, tt := range tests {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+
+			var adds []int64
+			reads := 0
+			for _, ins := range insns {
+				if ins.IsBuiltinCall() {
+					reads++
+				}
+				if ins.OpCode.ALUOp() == asm.Add && ins.Dst == asm.R3 {
+					adds = append(adds, ins.Constant)
+				}
+			}
+			test.AssertEqual(t, reads, tt.reads)
+			test.AssertEqualSlice(t, adds, tt.adds)
+		}
+	})
+
+	t.Run("(unsigned short)hub->buffer[2] == 0x0102", func(t *testing.T) {
+		expr, err := parse("(unsigned short)hub->buffer[2] == 0x0102")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getUsbHubBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns[len(insns)-5:], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0x0102, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("(unsigned int)skb->cb == 1", func(t *testing.T) {
+		expr, err := parse("(unsigned int)skb->cb == 1")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 40),
+		})
+	})
+
+	t.Run("cast bitfield", func(t *testing.T) {
+		expr, err := parse("(unsigned short)skb->pkt_type == 3")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "cannot cast bitfield 'pkt_type'")
+	})
+
+	t.Run("cast to pointer", func(t *testing.T) {
+		expr, err := parse("(struct sk_buff *)skb->dev == 0")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to cast left operand")
+	})
+
+	t.Run("*(unsigned short *)(skb - 2) == 0x0800", func(t *testing.T) {
+		expr, err := parse("*(unsigned short *)(skb - 2) == 0x0800")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, -2),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0x0800, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("direct load *(unsigned int *)(skb - 8) == 1", func(t *testing.T) {
+		expr, err := parse("*(unsigned int *)(skb - 8) == 1")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t), UseDirectLoad: true})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, -8, asm.Word),
+		})
+	})
+
+	t.Run("direct load *(unsigned int *)(skb - 0x10000) == 1", func(t *testing.T) {
+		expr, err := parse("*(unsigned int *)(skb - 0x10000) == 1")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t), UseDirectLoad: true})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "offset -65536 is too large to load directly")
+	})
+
+	t.Run("gc->gc_flags == (IRQ_GC_INIT_MASK_CACHE | IRQ_GC_MASK_CACHE_PER_TYPE)", func(t *testing.T) {
+		expr, err := parse("gc->gc_flags == (IRQ_GC_INIT_MASK_CACHE | IRQ_GC_MASK_CACHE_PER_TYPE)")
+		test.AssertNoErr(t, err)
+
+		gc, err := testBtf.AnyTypeByName("irq_domain_chip_generic")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: &btf.Pointer{Target: gc}})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 16),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0x1|0x4, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+}
+
+func TestCompileDirectContext(t *testing.T) {
+	getCtxBtf := func(t *testing.T, name string) *btf.Pointer {
+		typ, err := testBtf.AnyTypeByName(name)
+		test.AssertNoErr(t, err)
+		return &btf.Pointer{Target: typ}
+	}
+
+	t.Run("ctx->di > 100", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "ctx->di > 100", Type: getCtxBtf(t, "pt_regs"), DirectContext: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 112, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 100, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("ctx->args[1] == 3", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "ctx->args[1] == 3", Type: getCtxBtf(t, "trace_event_raw_sys_enter"), DirectContext: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 16+8, asm.DWord),
+		})
+	})
+
+	t.Run("ctx->ent.pid == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "ctx->ent.pid == 1", Type: getCtxBtf(t, "trace_event_raw_sys_enter"), DirectContext: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 4, asm.Word),
+		})
+	})
+
+	t.Run("ctx->skb->len > 100", func(t *testing.T) {
+		skb := getSkbBtf(t)
+		ctx := &btf.Pointer{Target: &btf.Struct{
+			Name: "ctx",
+			Size: 16,
+			Members: []btf.Member{
+				{Name: "pad", Type: getU64Btf(t)},
+				{Name: "skb", Type: skb, Offset: 64},
+			},
+		}}
+
+		res, err := Compile(CompileOptions{Expr: "ctx->skb->len > 100", Type: ctx, DirectContext: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 8, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Add.Imm(asm.R3, 112),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 100, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+}
+
+func TestCompileNegative(t *testing.T) {
+	short := &btf.Int{Name: "short", Size: 2, Encoding: btf.Signed}
+	typ := &btf.Pointer{Target: &btf.Struct{
+		Name:    "s",
+		Size:    2,
+		Members: []btf.Member{{Name: "a", Type: short}},
+	}}
+
+	for _, tt := range []struct {
+		expr string
+		jmp  asm.Instruction
+	}{
+		{expr: "s->a == -1", jmp: asm.JEq.Imm(asm.R3, -1, labelReturn)},
+		{expr: "s->a < -1", jmp: asm.JSLT.Imm(asm.R3, -1, labelReturn)},
+		{expr: "s->a != 65535", jmp: asm.JNE.Imm(asm.R3, -1, labelReturn)},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: tt.expr, Type: typ})
+			test.AssertNoErr(t, err)
+
+			insns := res.Insns
+			test.AssertEqualSlice(t, insns[5:len(insns)-2], asm.Instructions{
+				asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+				asm.LSh.Imm(asm.R3, 48),
+				asm.ArSh.Imm(asm.R3, 48),
+				asm.Mov.Imm(asm.R0, 1),
+				tt.jmp,
+			})
+		})
+	}
+
+	t.Run("skb->len == -skb->mark", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len == -skb->mark", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
+
+func TestCompileWordLoad(t *testing.T) {
+	t.Run("skb->len > 1024", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), WordLoad: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.Word),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 1024, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->tstamp > 1024", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->tstamp > 1024", Type: getSkbBtf(t), WordLoad: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[6:7], asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+		})
+	})
+
+	t.Run("ctx->ent.pid == 1", func(t *testing.T) {
+		typ, err := testBtf.AnyTypeByName("trace_event_raw_sys_enter")
+		test.AssertNoErr(t, err)
+
+		// The signed field is sign-extended still.
+		res, err := Compile(CompileOptions{Expr: "ctx->ent.pid == 1", Type: &btf.Pointer{Target: typ}, DirectContext: true, WordLoad: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:5], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 4, asm.Word),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.ArSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+		})
+	})
+}
+
+func TestCompileUnalignedField(t *testing.T) {
+	// A packed struct whose u32 field is at the unaligned offset 3.
+	packed := &btf.Pointer{Target: &btf.Struct{
+		Name: "packed",
+		Size: 7,
+		Members: []btf.Member{
+			{Name: "a", Type: &btf.Int{Name: "u8", Size: 1}},
+			{Name: "b", Type: &btf.Int{Name: "u16", Size: 2}, Offset: 8},
+			{Name: "c", Type: &btf.Int{Name: "u32", Size: 4}, Offset: 24},
+		},
+	}}
+
+	res, err := Compile(CompileOptions{Expr: "p->c == 0x11223344", Type: packed})
+	test.AssertNoErr(t, err)
+
+	// The unaligned source is read by the helper to the aligned stack slot.
+	insns := res.Insns
+	test.AssertEqualSlice(t, insns[:9], asm.Instructions{
+		asm.Mov.Reg(asm.R3, asm.R1),
+		asm.Add.Imm(asm.R3, 3),
+		asm.Mov.Imm(asm.R2, 8),
+		asm.Mov.Reg(asm.R1, asm.R10),
+		asm.Add.Imm(asm.R1, -8),
+		asm.FnProbeReadKernel.Call(),
+		asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+		asm.LSh.Imm(asm.R3, 32),
+		asm.RSh.Imm(asm.R3, 32),
+	})
+
+	// Emulate the read of the 8 bytes from offset 3 and the masking.
+	var data [16]byte
+	nativeEndian.PutUint32(data[3:], 0x11223344)
+	data[7], data[8] = 0xaa, 0xbb // the bytes following the field
+	regs := map[asm.Register]uint64{asm.R3: nativeEndian.Uint64(data[3:])}
+	runALU64(t, insns[7:9], regs)
+	test.AssertEqual(t, regs[asm.R3], 0x11223344)
+	test.AssertEqual(t, insns[10].Constant, 0x11223344)
+}
+
+func TestCompileEnumOrdering(t *testing.T) {
+	t.Run("signed enum", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "dev->power.runtime_status < RPM_SUSPENDED", Type: getDeviceBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JSLT.Imm(asm.R3, 2, labelReturn),
+		})
+	})
+
+	t.Run("unsigned enum", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "prog->type < BPF_PROG_TYPE_KPROBE", Type: getBpfProgBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JLT.Imm(asm.R3, 2, labelReturn),
+		})
+	})
+
+	t.Run("signed typedef", func(t *testing.T) {
+		typ := &btf.Typedef{Name: "s32", Type: &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}}
+		test.AssertTrue(t, isSignedType(typ))
+		test.AssertFalse(t, isSignedType(nil))
+	})
+}
+
+func TestCompileConstants(t *testing.T) {
+	constants := map[string]uint64{"ETH_P_IP": 0x0800, "MARK_DROP": 0x10}
+
+	t.Run("skb->protocol == ETH_P_IP", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->protocol == ETH_P_IP", Type: getSkbBtf(t), Constants: constants})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JEq.Imm(asm.R3, int32(h2ns(0x0800, isBigEndian(nativeEndian))), labelReturn),
+		})
+	})
+
+	t.Run("skb->mark != MARK_DROP", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->mark != MARK_DROP", Type: getSkbBtf(t), Constants: constants})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JNE.Imm(asm.R3, 0x10, labelReturn),
+		})
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->mark == MARK_ACCEPT", Type: getSkbBtf(t), Constants: constants})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+}
+
+func TestCompileDeterministic(t *testing.T) {
+	for _, expr := range []string{
+		"skb->dev->ifindex == 1",
+		`skb->dev->name != "lo"`,
+		"skb->len > 1500 ? 2 : 1",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			res1, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+			res2, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+
+			test.AssertEqualSlice(t, res1.Insns, res2.Insns)
+			for i := range res1.Insns {
+				test.AssertEqual(t, res1.Insns[i].Symbol(), res2.Insns[i].Symbol())
+				test.AssertEqual(t, res1.Insns[i].Reference(), res2.Insns[i].Reference())
+			}
+		})
+	}
 }
 
 var skbLen1024Insns = asm.Instructions{
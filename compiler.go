@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+type compilerKey struct {
+	expr string
+	typ  btf.Type
+}
+
+// Compiler compiles many simple C expressions against one btf spec with the
+// same default options. The compiled results are cached by the expression and
+// the root type, unless the default options refer to the maps or the functions
+// like Constants or TypeResolver, which may change the results behind the
+// Compiler.
+//
+// Compile and Reset are safe for concurrent use, but CompileNoCopy isn't, as
+// its returned instructions are in the buffer shared by all the callers.
+type Compiler struct {
+	defaults  CompileOptions
+	cacheable bool
+
+	mu    sync.Mutex
+	cache map[compilerKey]CompileResult
+	buf   asm.Instructions // reused by CompileNoCopy
+}
+
+// NewCompiler creates a Compiler with the btf spec and the default options.
+// The Expr of the default options is ignored, and the Type of it is used when
+// no root type is given to Compile.
+func NewCompiler(spec *btf.Spec, defaults CompileOptions) *Compiler {
+	defaults.Expr = ""
+	defaults.Spec = spec
+
+	return &Compiler{
+		defaults:  defaults,
+		cacheable: isCacheable(&defaults),
+		cache:     make(map[compilerKey]CompileResult),
+	}
+}
+
+// isCacheable reports whether the results compiled with the options depend
+// only on the expression and the root type, i.e. none of the options refers to
+// a map or a function which can be modified after creating the Compiler.
+func isCacheable(opts *CompileOptions) bool {
+	return opts.TypeResolver == nil && opts.SkStorage == nil && opts.Rodata == nil &&
+		len(opts.OpEmitters) == 0 && len(opts.FieldAliases) == 0 &&
+		len(opts.Macros) == 0 && len(opts.Constants) == 0 &&
+		len(opts.Values) == 0 && len(opts.Args) == 0
+}
+
+// Compile compiles the simple C expression against the root type. If typ is
+// nil, the Type of the default options is used.
+func (c *Compiler) Compile(expr string, typ btf.Type) (CompileResult, error) {
+	res, err := c.compile(expr, typ)
+	if err != nil {
+		return CompileResult{}, err
+	}
+
+	return cloneCompileResult(res), nil
+}
+
+// CompileNoCopy is like Compile but copies the instructions into an internal
+// buffer reused across calls instead of allocating a new slice, which reduces
+// the allocations when compiling many filters in a tight loop.
+//
+// The returned instructions are invalid after the next call of CompileNoCopy,
+// which overwrites the buffer, so they must be copied if they have to be kept.
+// It must not be called concurrently, use Compile instead.
+func (c *Compiler) CompileNoCopy(expr string, typ btf.Type) (asm.Instructions, error) {
+	res, err := c.compile(expr, typ)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.buf = append(c.buf[:0], res.Insns...)
+	buf := c.buf
+	c.mu.Unlock()
+
+	return buf, nil
+}
+
+// Reset drops the cached results, and keeps the internal buffer for reuse.
+func (c *Compiler) Reset() {
+	c.mu.Lock()
+	clear(c.cache)
+	c.mu.Unlock()
+}
+
+// compile returns the cached result, which must not be modified.
+func (c *Compiler) compile(expr string, typ btf.Type) (CompileResult, error) {
+	opts := c.defaults
+	opts.Expr = expr
+	if typ != nil {
+		opts.Type = typ
+	}
+
+	if !c.cacheable {
+		return Compile(opts)
+	}
+
+	key := compilerKey{expr, opts.Type}
+
+	c.mu.Lock()
+	res, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		return res, nil
+	}
+
+	res, err := Compile(opts)
+	if err != nil {
+		return CompileResult{}, err
+	}
+
+	c.mu.Lock()
+	c.cache[key] = res
+	c.mu.Unlock()
+
+	return res, nil
+}
+
+// cloneCompileResult clones the result to protect the cached one from being
+// modified by the caller.
+func cloneCompileResult(res CompileResult) CompileResult {
+	res.Insns = slices.Clone(res.Insns)
+	return res
+}
@@ -0,0 +1,192 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestCompiler(t *testing.T) {
+	c := NewCompiler(testBtf, CompileOptions{
+		Expr: "ignored",
+		Type: getSkbBtf(t),
+	})
+
+	t.Run("default type", func(t *testing.T) {
+		res, err := c.Compile("skb->len > 1024", nil)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("another type", func(t *testing.T) {
+		res, err := c.Compile("prog->type == BPF_PROG_TYPE_KPROBE", getBpfProgBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertTrue(t, len(res.Insns) != 0)
+	})
+
+	t.Run("enum value from spec", func(t *testing.T) {
+		sk, err := testBtf.AnyTypeByName("sock")
+		test.AssertNoErr(t, err)
+
+		expr := "sk->sk_protocol == IPPROTO_UDP"
+		_, err = SimpleCompile(expr, getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+
+		res, err := c.Compile(expr, &btf.Pointer{Target: sk})
+		test.AssertNoErr(t, err)
+		n := len(res.Insns)
+		test.AssertEqualSlice(t, res.Insns[n-3:n-2], asm.Instructions{
+			asm.JEq.Imm(asm.R3, 17, labelReturn),
+		})
+	})
+
+	t.Run("failed to compile", func(t *testing.T) {
+		_, err := c.Compile("skb->xxx == 0", nil)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		res1, err := c.Compile("skb->dev->ifindex == 1", nil)
+		test.AssertNoErr(t, err)
+
+		res1.Insns[0] = asm.Mov.Imm(asm.R0, 0)
+
+		res2, err := c.Compile("skb->dev->ifindex == 1", nil)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res2.Insns[:1], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+		})
+		test.AssertEqual(t, len(c.cache), 4)
+	})
+}
+
+func TestCompilerNoCopy(t *testing.T) {
+	c := NewCompiler(testBtf, CompileOptions{Type: getSkbBtf(t)})
+
+	kept, err := c.Compile("skb->len > 1024", nil)
+	test.AssertNoErr(t, err)
+
+	insns, err := c.CompileNoCopy("skb->len > 1024", nil)
+	test.AssertNoErr(t, err)
+	test.AssertEqualSlice(t, insns, cloneSkbLen1024InsnsWithoutExitLabel())
+
+	// Modifying the reused buffer doesn't corrupt the cached result nor the
+	// previously returned copy.
+	insns[0] = asm.Return()
+	res, err := c.Compile("skb->len > 1024", nil)
+	test.AssertNoErr(t, err)
+	test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	test.AssertEqualSlice(t, kept.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+
+	// The buffer is reused by the next call.
+	next, err := c.CompileNoCopy("skb->len > 1024", nil)
+	test.AssertNoErr(t, err)
+	test.AssertTrue(t, &next[0] == &insns[0])
+	test.AssertEqualSlice(t, insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	test.AssertEqualSlice(t, kept.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+
+	t.Run("failure", func(t *testing.T) {
+		_, err := c.CompileNoCopy("skb->xxx == 0", nil)
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		c.Reset()
+		test.AssertEqual(t, len(c.cache), 0)
+
+		insns, err := c.CompileNoCopy("skb->len > 1024", nil)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, cloneSkbLen1024InsnsWithoutExitLabel())
+		test.AssertEqual(t, len(c.cache), 1)
+	})
+}
+
+func TestCompilerUncached(t *testing.T) {
+	constants := map[string]uint64{"MARK_DROP": 1}
+	c := NewCompiler(testBtf, CompileOptions{Type: getSkbBtf(t), Constants: constants})
+
+	res, err := c.Compile("skb->mark == MARK_DROP", nil)
+	test.AssertNoErr(t, err)
+	n := len(res.Insns)
+	test.AssertEqualSlice(t, res.Insns[n-3:n-2], asm.Instructions{
+		asm.JEq.Imm(asm.R3, 1, labelReturn),
+	})
+
+	// The constant changed behind the Compiler is compiled again.
+	constants["MARK_DROP"] = 2
+	res, err = c.Compile("skb->mark == MARK_DROP", nil)
+	test.AssertNoErr(t, err)
+	test.AssertEqualSlice(t, res.Insns[n-3:n-2], asm.Instructions{
+		asm.JEq.Imm(asm.R3, 2, labelReturn),
+	})
+	test.AssertEqual(t, len(c.cache), 0)
+}
+
+func TestCompilerConcurrent(t *testing.T) {
+	c := NewCompiler(testBtf, CompileOptions{Type: getSkbBtf(t)})
+	exprs := []string{"skb->len > 1024", "skb->dev->ifindex == 9", "skb->protocol == 0x0800"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 32; j++ {
+				expr := exprs[(i+j)%len(exprs)]
+				res, err := c.Compile(expr, nil)
+				if err != nil || len(res.Insns) == 0 {
+					t.Errorf("failed to compile %s: %v", expr, err)
+					return
+				}
+
+				// The result is owned by the caller.
+				res.Insns[0] = asm.Return()
+				if j%8 == 0 {
+					c.Reset()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	res, err := c.Compile("skb->len > 1024", nil)
+	test.AssertNoErr(t, err)
+	test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+}
+
+func BenchmarkCompiler(b *testing.B) {
+	skb, err := testBtf.AnyTypeByName("sk_buff")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c := NewCompiler(testBtf, CompileOptions{Type: &btf.Pointer{Target: skb}})
+	exprs := []string{"skb->len > 1024", "skb->dev->ifindex == 9", "skb->protocol == 0x0800"}
+
+	b.Run("Compile", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.Compile(exprs[i%len(exprs)], nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("CompileNoCopy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := c.CompileNoCopy(exprs[i%len(exprs)], nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
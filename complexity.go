@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/cilium/ebpf/asm"
+)
+
+// Complexity returns a rough score of the verifier complexity of the compiled
+// instructions, which is (branches + 1) * (reads + 1). The branches are the
+// conditional and unconditional jumps, and the reads are the helper calls and
+// the direct loads from memory other than stack.
+//
+// It's a heuristic to rank the filters by the risk of failing to load on the
+// kernels with lower complexity limits.
+func (r CompileResult) Complexity() int {
+	var branches, reads int
+	for _, ins := range r.Insns {
+		op := ins.OpCode
+		switch {
+		case op.JumpOp() == asm.Call:
+			reads++
+		case op.JumpOp() != asm.InvalidJumpOp && op.JumpOp() != asm.Exit:
+			branches++
+		case op.Class().IsLoad() && op.Mode() == asm.MemMode && ins.Src != asm.R10:
+			reads++
+		}
+	}
+
+	return (branches + 1) * (reads + 1)
+}
@@ -0,0 +1,45 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestComplexity(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		test.AssertEqual(t, CompileResult{}.Complexity(), 1)
+	})
+
+	exprs := []string{
+		"skb != 0",
+		"skb->len > 1024",
+		"skb->dev->ifindex == 9",
+		"skb->sk->sk_socket->flags == 1",
+	}
+
+	prev := 0
+	for _, expr := range exprs {
+		t.Run(expr, func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+
+			complexity := res.Complexity()
+			test.AssertTrue(t, complexity > prev)
+			prev = complexity
+		})
+	}
+
+	t.Run("direct load", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 9", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		direct, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 9", Type: getSkbBtf(t), UseDirectLoad: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, res.Complexity(), 9)
+		test.AssertEqual(t, direct.Complexity(), res.Complexity())
+	})
+}
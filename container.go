@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+const containerOfFunc = "container_of"
+
+// containerOfTypeRegexp matches the struct/union keyword of the type argument
+// of container_of(), which cannot be parsed by cc.
+var containerOfTypeRegexp = regexp.MustCompile(`((?:` + containerOfFunc + `|` + listFirstFunc + `)\s*\([^,()]*(?:\([^()]*\))?[^,()]*,\s*)(?:struct|union)\s+`)
+
+// stripContainerOfType rewrites container_of(ptr, struct type, member) to
+// container_of(ptr, type, member), and list_first() likewise.
+func stripContainerOfType(expr string) string {
+	return containerOfTypeRegexp.ReplaceAllString(expr, "$1")
+}
+
+type containerInfo struct {
+	offsets []uint32     // offsets to read ptr
+	paths   []string     // paths of the offsets to read ptr
+	offset  uint32       // offset of member in the container type
+	ptr     *btf.Pointer // pointer to the container type
+}
+
+func validateContainerOf(call *cc.Expr) error {
+	if call.Left == nil || call.Left.Op != cc.Name || call.Left.Text != containerOfFunc {
+		return fmt.Errorf("unexpected function call %v; only %s() is supported", call.Left, containerOfFunc)
+	}
+
+	if len(call.List) != 3 {
+		return fmt.Errorf("%s() expects 3 arguments, got %d", containerOfFunc, len(call.List))
+	}
+
+	if call.List[1].Op != cc.Name {
+		return fmt.Errorf("unexpected type %v of %s(); must be struct/union name", call.List[1], containerOfFunc)
+	}
+
+	for member := call.List[2]; member != nil; member = member.Left {
+		if member.Op != cc.Name && member.Op != cc.Dot {
+			return fmt.Errorf("unexpected member %v of %s(); must be member names joined by .", call.List[2], containerOfFunc)
+		}
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+// memberOffset returns the byte offset of the member like a.b.c in the
+// struct/union, and the type of the member.
+func memberOffset(typ btf.Type, member *cc.Expr) (uint32, btf.Type, error) {
+	var names []string
+	for m := member; m != nil; m = m.Left {
+		names = append(names, m.Text)
+	}
+
+	var offset uint32
+	for i := len(names) - 1; i >= 0; i-- {
+		var (
+			m   *btf.Member
+			off uint32
+			err error
+		)
+
+		switch v := mybtf.UnderlyingType(typ).(type) {
+		case *btf.Struct:
+			m, err = mybtf.FindStructMember(v, names[i])
+			if err == nil {
+				off, err = mybtf.StructMemberOffset(v, names[i])
+			}
+		case *btf.Union:
+			m, err = mybtf.FindUnionMember(v, names[i])
+			if err == nil {
+				off, err = mybtf.UnionMemberOffset(v, names[i])
+			}
+		default:
+			return 0, nil, fmt.Errorf("unexpected type %s of member %s; must be struct/union", typ, names[i])
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to find member %s of %s: %w", names[i], typ, err)
+		}
+
+		offset += off
+		typ = m.Type
+	}
+
+	return offset, typ, nil
+}
+
+// containerOf resolves container_of(ptr, type, member), whose type is looked
+// up by lookup.
+func containerOf(call *cc.Expr, typ btf.Type, lookup typeLookupFunc, aliases FieldAliases) (containerInfo, error) {
+	var ci containerInfo
+
+	if err := validateContainerOf(call); err != nil {
+		return ci, err
+	}
+
+	if lookup == nil {
+		return ci, fmt.Errorf("btf spec or type resolver is required to resolve %s() type %s", containerOfFunc, call.List[1].Text)
+	}
+
+	ptr, err := expr2offsetWithLookup(call.List[0], typ, lookup, aliases)
+	if err != nil {
+		return ci, fmt.Errorf("failed to resolve %s() pointer %v: %w", containerOfFunc, call.List[0], err)
+	}
+
+	ptrType, ok := mybtf.UnderlyingType(ptr.lastField).(*btf.Pointer)
+	if !ok {
+		return ci, fmt.Errorf("unexpected type %s of %s() pointer %v; must be pointer", ptr.lastField, containerOfFunc, call.List[0])
+	}
+
+	container, err := lookup(call.List[1].Text)
+	if err != nil {
+		return ci, fmt.Errorf("failed to find %s() type %s: %w", containerOfFunc, call.List[1].Text, err)
+	}
+
+	offset, member, err := memberOffset(container, call.List[2])
+	if err != nil {
+		return ci, fmt.Errorf("failed to resolve %s() member %v: %w", containerOfFunc, call.List[2], err)
+	}
+
+	want, got := mybtf.UnderlyingType(member), mybtf.UnderlyingType(ptrType.Target)
+	if want.TypeName() != "" && got.TypeName() != "" && want.TypeName() != got.TypeName() {
+		return ci, fmt.Errorf("type %s of %s() member %v mismatches pointer to %s", want.TypeName(), containerOfFunc, call.List[2], got.TypeName())
+	}
+
+	ci.offsets = ptr.offsets
+	ci.paths = ptr.paths
+	ci.offset = offset
+	ci.ptr = &btf.Pointer{Target: container}
+	return ci, nil
+}
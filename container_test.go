@@ -0,0 +1,170 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func getDeviceBtf(t *testing.T) *btf.Pointer {
+	dev, err := testBtf.AnyTypeByName("device")
+	test.AssertNoErr(t, err)
+	return &btf.Pointer{Target: dev}
+}
+
+func TestStripContainerOfType(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string
+	}{
+		{expr: "skb->len > 0", exp: "skb->len > 0"},
+		{expr: "container_of(dev, struct net_device, dev)->ifindex", exp: "container_of(dev, net_device, dev)->ifindex"},
+		{expr: "container_of(skb->sk, union foo, bar)->x", exp: "container_of(skb->sk, foo, bar)->x"},
+		{expr: "container_of((skb->sk), struct tcp_sock, sk)->x", exp: "container_of((skb->sk), tcp_sock, sk)->x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			test.AssertEqual(t, stripContainerOfType(tt.expr), tt.exp)
+		})
+	}
+}
+
+func TestMemberOffset(t *testing.T) {
+	tcpSock, err := testBtf.AnyTypeByName("tcp_sock")
+	test.AssertNoErr(t, err)
+
+	t.Run("embedded", func(t *testing.T) {
+		expr, err := parse("inet_conn.icsk_inet.sk")
+		test.AssertNoErr(t, err)
+
+		offset, typ, err := memberOffset(tcpSock, expr)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, offset, uint32(0))
+		test.AssertEqual(t, typ.TypeName(), "sock")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		expr, err := parse("inet_conn.xxx")
+		test.AssertNoErr(t, err)
+
+		_, _, err = memberOffset(tcpSock, expr)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to find member xxx")
+	})
+
+	t.Run("not struct", func(t *testing.T) {
+		expr, err := parse("snd_cwnd.xxx")
+		test.AssertNoErr(t, err)
+
+		_, _, err = memberOffset(tcpSock, expr)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected type")
+	})
+}
+
+// specLookup looks up the types in the spec, or returns nil without spec.
+func specLookup(spec *btf.Spec) typeLookupFunc {
+	return (&CompileOptions{Spec: spec}).typeLookup()
+}
+
+func TestContainerOf(t *testing.T) {
+	t.Run("negative offset", func(t *testing.T) {
+		expr, err := parse("container_of(dev, struct net_device, dev)->ifindex == 1")
+		test.AssertNoErr(t, err)
+		test.AssertNoErr(t, validate(expr))
+
+		ast, err := expr2offsetWithLookup(expr.Left, getDeviceBtf(t), specLookup(testBtf), nil)
+		test.AssertNoErr(t, err)
+
+		// ifindex is at 224 of net_device, and dev is at 1464.
+		offset := int32(224 - 1464)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{uint32(offset)})
+		test.AssertEqual(t, ast.lastField.TypeName(), "int")
+	})
+
+	t.Run("pointer via member access", func(t *testing.T) {
+		expr, err := parse("container_of(skb->sk, struct tcp_sock, inet_conn.icsk_inet.sk)->snd_cwnd == 10")
+		test.AssertNoErr(t, err)
+
+		tcpSock, err := testBtf.AnyTypeByName("tcp_sock")
+		test.AssertNoErr(t, err)
+		member, err := parse("snd_cwnd")
+		test.AssertNoErr(t, err)
+		sndCwnd, _, err := memberOffset(tcpSock, member)
+		test.AssertNoErr(t, err)
+
+		ast, err := expr2offsetWithLookup(expr.Left, getSkbBtf(t), specLookup(testBtf), nil)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, ast.offsets, []uint32{24, sndCwnd})
+	})
+
+	t.Run("compile", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "container_of(dev, struct net_device, dev)->ifindex == 1",
+			Type: getDeviceBtf(t),
+			Spec: testBtf,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 224-1464),
+		})
+	})
+
+	invalids := []struct {
+		name string
+		expr string
+		spec *btf.Spec
+		err  string
+	}{
+		{name: "no spec", expr: "container_of(dev, net_device, dev)->ifindex", err: "btf spec or type resolver is required"},
+		{name: "no member access", expr: "container_of(dev, net_device, dev)", spec: testBtf, err: "container_of(dev, net_device, dev) must be followed by member access"},
+		{name: "type not found", expr: "container_of(dev, xxx, dev)->ifindex", spec: testBtf, err: "failed to find container_of() type xxx"},
+		{name: "member not found", expr: "container_of(dev, net_device, xxx)->ifindex", spec: testBtf, err: "failed to resolve container_of() member xxx"},
+		{name: "type mismatch", expr: "container_of(dev, net_device, dev.kobj)->ifindex", spec: testBtf, err: "type kobject of container_of() member dev.kobj mismatches pointer to device"},
+		{name: "not pointer", expr: "container_of(dev->id, net_device, dev)->ifindex", spec: testBtf, err: "unexpected type"},
+	}
+
+	for _, tt := range invalids {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			_, err = expr2offsetWithLookup(expr, getDeviceBtf(t), specLookup(tt.spec), nil)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestValidateContainerOf(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		err  string
+	}{
+		{name: "other function", expr: "foo(dev, net_device, dev)->ifindex == 1", err: "unexpected function call foo"},
+		{name: "arguments", expr: "container_of(dev, net_device)->ifindex == 1", err: "container_of() expects 3 arguments, got 2"},
+		{name: "type", expr: "container_of(dev, 1, dev)->ifindex == 1", err: "unexpected type 1"},
+		{name: "member", expr: "container_of(dev, net_device, dev->kobj)->ifindex == 1", err: "unexpected member dev->kobj"},
+		{name: "pointer", expr: "container_of(dev + 1, net_device, dev)->ifindex == 1", err: "left operand must be struct member access"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			err = validate(expr)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+const (
+	labelCount   = "__count_bice_filter"
+	labelCounted = "__counted_bice_filter"
+
+	stackOffsetCounterKey = -4 // key of the counter map
+)
+
+// count2insns increments the u64 counter at key 0 of the array or per-CPU
+// array map referenced by name if the verdict is match, i.e. the last return
+// carrying the symbol of __return is replaced with the lookup and the atomic
+// add before returning. The verdict clobbered by the lookup is re-materialized
+// as match, and the missing counter is skipped.
+func count2insns(insns asm.Instructions, name string, match int32) (asm.Instructions, error) {
+	last := insns[len(insns)-1]
+	if last.OpCode.JumpOp() != asm.Exit {
+		return nil, fmt.Errorf("unexpected last instruction %v; must be return", last)
+	}
+
+	insns = insns[:len(insns)-1]
+	return append(insns,
+		asm.JNE.Imm(asm.R0, match, labelCount).WithSymbol(last.Symbol()), // if r0 != match, goto __count; __return
+		asm.StoreImm(asm.R10, stackOffsetCounterKey, 0, asm.Word),        // *(u32 *)(r10 - 4) = 0
+		asm.LoadMapPtr(asm.R1, 0).WithReference(name),                    // r1 = map
+		asm.Mov.Reg(asm.R2, asm.R10),                                     // r2 = r10
+		asm.Add.Imm(asm.R2, stackOffsetCounterKey),                       // r2 = r10 - 4; key
+		asm.FnMapLookupElem.Call(),                                       // r0 = bpf_map_lookup_elem(r1, r2)
+		asm.JEq.Imm(asm.R0, 0, labelCounted),                             // if r0 == 0, goto __counted
+		asm.Mov.Imm(asm.R1, 1),                                           // r1 = 1
+		asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),                         // lock *(u64 *)r0 += r1
+		asm.Mov.Imm(asm.R0, match).WithSymbol(labelCounted),              // r0 = match; __counted
+		asm.Return().WithSymbol(labelCount),                              // return; __count
+	), nil
+}
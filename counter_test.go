@@ -0,0 +1,94 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestCompileCounterMap(t *testing.T) {
+	t.Run("skb->len > 1024", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), CounterMap: "hits"})
+		test.AssertNoErr(t, err)
+
+		want := cloneSkbLen1024InsnsWithoutExitLabel()
+		want = append(want[:len(want)-1],
+			asm.JNE.Imm(asm.R0, 1, labelCount).WithSymbol(labelReturn),
+			asm.StoreImm(asm.R10, -4, 0, asm.Word),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("hits"),
+			asm.Mov.Reg(asm.R2, asm.R10),
+			asm.Add.Imm(asm.R2, -4),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, labelCounted),
+			asm.Mov.Imm(asm.R1, 1),
+			asm.StoreXAdd(asm.R0, asm.R1, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1).WithSymbol(labelCounted),
+			asm.Return().WithSymbol(labelCount),
+		)
+		test.AssertEqualSlice(t, res.Insns, want)
+	})
+
+	t.Run("verdict register", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), CounterMap: "hits", VerdictReg: asm.R7})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-2:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1).WithSymbol(labelCounted),
+			asm.Mov.Reg(asm.R7, asm.R0).WithSymbol(labelCount),
+		})
+	})
+
+	t.Run("ternary", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024 ? 2 : 3", Type: getSkbBtf(t), CounterMap: "hits"})
+		test.AssertNoErr(t, err)
+
+		// Only the matches of the condition are counted, whose verdict is
+		// the then arm after the counter update.
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-14:len(insns)-9], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 2),
+			asm.JGT.Imm(asm.R3, 1024, labelReturn),
+			asm.Mov.Imm(asm.R0, 3),
+			asm.JNE.Imm(asm.R0, 2, labelCount).WithSymbol(labelReturn),
+			asm.StoreImm(asm.R10, -4, 0, asm.Word),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-2:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 2).WithSymbol(labelCounted),
+			asm.Return().WithSymbol(labelCount),
+		})
+	})
+	t.Run("invert verdict", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), CounterMap: "hits", InvertVerdict: true})
+		test.AssertNoErr(t, err)
+
+		// The matches are counted, whose verdict is 0 after the inversion.
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-11:len(insns)-9], asm.Instructions{
+			asm.JNE.Imm(asm.R0, 0, labelCount).WithSymbol(labelReturn),
+			asm.StoreImm(asm.R10, -4, 0, asm.Word),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-2:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0).WithSymbol(labelCounted),
+			asm.Return().WithSymbol(labelCount),
+		})
+	})
+
+	t.Run("inverted ternary", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024 ? 2 : 3", Type: getSkbBtf(t), CounterMap: "hits", InvertVerdict: true})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-11:len(insns)-10], asm.Instructions{
+			asm.JNE.Imm(asm.R0, 3, labelCount).WithSymbol(labelReturn),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-2:len(insns)-1], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 3).WithSymbol(labelCounted),
+		})
+	})
+}
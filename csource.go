@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// cIntType returns the kernel integer type like __u32 of the size.
+func cIntType(size int, signed bool) string {
+	if signed {
+		return fmt.Sprintf("__s%d", size*8)
+	}
+	return fmt.Sprintf("__u%d", size*8)
+}
+
+// cByteOrder returns the helper to convert the big endian value of the size to
+// host byte order, or "" for the single byte.
+func cByteOrder(size int) string {
+	switch size {
+	case 2:
+		return "bpf_ntohs"
+	case 4:
+		return "bpf_ntohl"
+	case 8:
+		return "bpf_be64_to_cpu"
+	default:
+		return ""
+	}
+}
+
+// toC restates the validated expression like skb->len > 1024 as the eBPF C
+// function equivalent to the compiled instructions, which reads every resolved
+// offset by bpf_probe_read_kernel() or loads it directly. The map membership
+// is restated as the bpf_map_lookup_elem() of the field, the .rodata variable
+// as the global variable of its name, and the ternary as the ternary of the
+// verdicts. It returns "" if the left operand isn't a member access of integer
+// or pointer, e.g. the string comparison, the right operand isn't a constant,
+// e.g. now() or CompareReg, or the filter counts the matches or jumps to
+// LabelFail.
+func toC(expr *cc.Expr, constant uint64, arms *ternaryArms, opts CompileOptions) string {
+	if expr.Right == nil || !isMemberAccess(expr.Left) {
+		return ""
+	}
+
+	switch expr.Right.Op {
+	case cc.Number, cc.Name, cc.Minus, cc.Twid:
+	default:
+		return ""
+	}
+	if opts.CompareReg != 0 || opts.SkStorage != nil || opts.CounterMap != "" || opts.ReadHelper != asm.FnUnspec || opts.LabelFail != "" {
+		return ""
+	}
+
+	mapName, isMap := mapRef(expr.Right)
+	rodataName, isRodata := rodataRef(expr.Right)
+
+	_, typ := argRoot(expr.Left, opts)
+	ast, err := expr2offsetWithLookup(expr.Left, typ, opts.typeLookup(), opts.fieldAliases())
+	if err != nil || len(ast.offsets) == 0 {
+		return ""
+	}
+
+	size, err := checkLastField(ast.member, ast.lastField)
+	if err != nil {
+		return ""
+	}
+
+	bitfield := IsMemberBitfield(ast.member)
+	valType := cIntType(size, isSignedType(ast.lastField))
+	if bitfield {
+		// The bitfield is extracted from the 8 bytes read at its byte
+		// offset.
+		valType = "__u64"
+	}
+
+	root := rootName(expr.Left)
+	base := root
+	if len(ast.offsets) > 1 {
+		base = "ptr"
+	}
+
+	// The failed read mismatches, whose verdict is inverted or selected too.
+	fail := int32(0)
+	if opts.InvertVerdict {
+		fail = 1
+	}
+	if arms != nil {
+		fail = arms.els
+		if opts.InvertVerdict {
+			fail = arms.then
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "static __always_inline int bice_filter(void *%s)\n{\n", root)
+	if len(ast.offsets) > 1 {
+		fmt.Fprintf(&sb, "\tvoid *ptr = %s;\n", root)
+	}
+	fmt.Fprintf(&sb, "\t%s val;\n\n", valType)
+
+	for j, offset := range ast.offsets {
+		dst, dstPtr := "ptr", "void **"
+		if j == len(ast.offsets)-1 {
+			dst, dstPtr = "val", valType+" *"
+		}
+		src := base
+		if offset != 0 {
+			src = fmt.Sprintf("%s + %d", base, offset)
+		}
+
+		// The direct loads follow the loads of the compiled instructions.
+		direct := opts.UseDirectLoad || opts.CgroupSkb || (opts.DirectContext && j == 0)
+		switch {
+		case direct:
+			fmt.Fprintf(&sb, "\t%s = *(%s)(%s); /* %s */\n", dst, dstPtr, src, ast.paths[j])
+		case j < len(ast.userReads) && ast.userReads[j]:
+			fmt.Fprintf(&sb, "\tbpf_probe_read_user(&%s, sizeof(%s), %s); /* %s */\n", dst, dst, src, ast.paths[j])
+		default:
+			fmt.Fprintf(&sb, "\tbpf_probe_read_kernel(&%s, sizeof(%s), %s); /* %s */\n", dst, dst, src, ast.paths[j])
+		}
+		if dst == "ptr" {
+			fmt.Fprintf(&sb, "\tif (!ptr)\n\t\treturn %d;\n", fail)
+		}
+	}
+
+	left, right := "val", expr.Right.String()
+	switch {
+	case isMap:
+		// The key is the field in memory byte order.
+		key := "val"
+		if opts.LPMKey {
+			fmt.Fprintf(&sb, "\tstruct { __u32 prefixlen; __u8 data[%d]; } key = { .prefixlen = %d };\n", size, size*8)
+			sb.WriteString("\t__builtin_memcpy(key.data, &val, sizeof(val));\n")
+			key = "key"
+		}
+		left, right = fmt.Sprintf("bpf_map_lookup_elem(&%s, &%s)", mapName, key), "NULL"
+
+	case isRodata:
+		right = rodataName
+		fallthrough
+
+	default:
+		if bitfield {
+			// The constant is truncated to the bitfield width.
+			delta := ast.member.Offset & 0x7
+			mask := (uint64(1) << uint64(ast.member.BitfieldSize)) - 1
+			if delta != 0 {
+				fmt.Fprintf(&sb, "\tval = (val >> %d) & 0x%x;\n", delta, mask)
+			} else {
+				fmt.Fprintf(&sb, "\tval &= 0x%x;\n", mask)
+			}
+			if !isRodata {
+				right = fmt.Sprintf("%d", constant)
+			}
+		} else if bigEndian := (ast.bigEndian || opts.ForceBigEndian) && !opts.ForceLittleEndian; bigEndian && cByteOrder(size) != "" {
+			left = cByteOrder(size) + "(val)"
+		}
+	}
+
+	op := opSymbol(expr.Op)
+	if isMap {
+		op = "!="
+	}
+	cond := left + " " + op + " " + right
+	if opts.InvertVerdict {
+		cond = "!(" + cond + ")"
+	}
+	if arms != nil {
+		cond = fmt.Sprintf("%s ? %d : %d", cond, arms.then, arms.els)
+	}
+	fmt.Fprintf(&sb, "\treturn %s;\n}\n", cond)
+
+	return sb.String()
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestToC(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		opts CompileOptions
+		exp  string
+	}{
+		{"skb->len > 1024", CompileOptions{}, `static __always_inline int bice_filter(void *skb)
+{
+	__u32 val;
+
+	bpf_probe_read_kernel(&val, sizeof(val), skb + 112); /* skb->len */
+	return val > 1024;
+}
+`},
+		{"skb->dev->ifindex == 9", CompileOptions{InvertVerdict: true}, `static __always_inline int bice_filter(void *skb)
+{
+	void *ptr = skb;
+	__s32 val;
+
+	bpf_probe_read_kernel(&ptr, sizeof(ptr), ptr + 16); /* skb->dev */
+	if (!ptr)
+		return 1;
+	bpf_probe_read_kernel(&val, sizeof(val), ptr + 224); /* skb->dev->ifindex */
+	return !(val == 9);
+}
+`},
+		{"skb->protocol == 0x800", CompileOptions{}, `static __always_inline int bice_filter(void *skb)
+{
+	__u16 val;
+
+	bpf_probe_read_kernel(&val, sizeof(val), skb + 180); /* skb->protocol */
+	return bpf_ntohs(val) == 0x800;
+}
+`},
+		{"skb->pkt_type == 3", CompileOptions{}, `static __always_inline int bice_filter(void *skb)
+{
+	__u64 val;
+
+	bpf_probe_read_kernel(&val, sizeof(val), skb); /* skb->pkt_type */
+	val &= 0x7;
+	return val == 3;
+}
+`},
+		{"skb->mark in @allowlist", CompileOptions{}, `static __always_inline int bice_filter(void *skb)
+{
+	__u32 val;
+
+	bpf_probe_read_kernel(&val, sizeof(val), skb + 168); /* skb->mark */
+	return bpf_map_lookup_elem(&allowlist, &val) != NULL;
+}
+`},
+		{"skb->mark in @subnets", CompileOptions{LPMKey: true}, `static __always_inline int bice_filter(void *skb)
+{
+	__u32 val;
+
+	bpf_probe_read_kernel(&val, sizeof(val), skb + 168); /* skb->mark */
+	struct { __u32 prefixlen; __u8 data[4]; } key = { .prefixlen = 32 };
+	__builtin_memcpy(key.data, &val, sizeof(val));
+	return bpf_map_lookup_elem(&subnets, &key) != NULL;
+}
+`},
+		{"skb->len > @rodata.threshold", CompileOptions{Rodata: getRodataBtf()}, `static __always_inline int bice_filter(void *skb)
+{
+	__u32 val;
+
+	bpf_probe_read_kernel(&val, sizeof(val), skb + 112); /* skb->len */
+	return val > threshold;
+}
+`},
+		{"skb->dev->ifindex == 9 ? 2 : 3", CompileOptions{}, `static __always_inline int bice_filter(void *skb)
+{
+	void *ptr = skb;
+	__s32 val;
+
+	bpf_probe_read_kernel(&ptr, sizeof(ptr), ptr + 16); /* skb->dev */
+	if (!ptr)
+		return 3;
+	bpf_probe_read_kernel(&val, sizeof(val), ptr + 224); /* skb->dev->ifindex */
+	return val == 9 ? 2 : 3;
+}
+`},
+		{"skb->dev->ifindex == 9", CompileOptions{UseDirectLoad: true}, `static __always_inline int bice_filter(void *skb)
+{
+	void *ptr = skb;
+	__s32 val;
+
+	ptr = *(void **)(ptr + 16); /* skb->dev */
+	if (!ptr)
+		return 0;
+	val = *(__s32 *)(ptr + 224); /* skb->dev->ifindex */
+	return val == 9;
+}
+`},
+		{"skb->len > 1024", CompileOptions{CounterMap: "counts"}, ""},
+		{"skb->dev->name == \"lo\"", CompileOptions{}, ""},
+		{"(skb->mark & 0xff) == 1", CompileOptions{}, ""},
+		{"skb->len > 0", CompileOptions{CompareReg: asm.R6}, ""},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			tt.opts.Expr = tt.expr
+			tt.opts.Type = getSkbBtf(t)
+			res, err := Compile(tt.opts)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, res.ToC(), tt.exp)
+		})
+	}
+}
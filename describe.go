@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// typeName returns the C name of the type like "int", "sk_buff *" or
+// "char[16]", skipping the qualifiers.
+func typeName(typ btf.Type) string {
+	for {
+		switch v := typ.(type) {
+		case *btf.Const:
+			typ = v.Type
+		case *btf.Volatile:
+			typ = v.Type
+		case *btf.Restrict:
+			typ = v.Type
+		case *btf.Pointer:
+			return typeName(v.Target) + " *"
+		case *btf.Array:
+			return fmt.Sprintf("%s[%d]", typeName(v.Type), v.Nelems)
+		default:
+			if name := typ.TypeName(); name != "" {
+				return name
+			}
+			return "anonymous"
+		}
+	}
+}
+
+// unfoldRefs restores the references to the maps and the .rodata variables
+// folded as the right operand names, like iph->saddr in @allowlist and
+// @rodata.threshold.
+func unfoldRefs(s string) string {
+	s = strings.ReplaceAll(s, "== "+mapRefPrefix, "in @")
+	return strings.ReplaceAll(s, rodataRefPrefix, "@rodata.")
+}
+
+// describe restates the validated expression like skb->dev->ifindex == 1 as
+// "read sk_buff.dev(+16)->ifindex(+224) as int; match if == 1", which lists the
+// resolved offset of every read. The expression is restated as is if its left
+// operand isn't a member access.
+func describe(expr *cc.Expr, opts CompileOptions) string {
+	match := "match if "
+	if opts.InvertVerdict {
+		match = "match unless "
+	}
+
+	if expr.Right == nil || !isMemberAccess(expr.Left) {
+		return match + unfoldRefs(expr.String())
+	}
+	match += unfoldRefs(opSymbol(expr.Op) + " " + expr.Right.String())
+
+	_, typ := argRoot(expr.Left, opts)
+	ast, err := expr2offsetWithLookup(expr.Left, typ, opts.typeLookup(), opts.fieldAliases())
+	if err != nil || len(ast.offsets) == 0 {
+		return match
+	}
+
+	root := typeName(typ)
+	if ptr, ok := mybtf.UnderlyingType(typ).(*btf.Pointer); ok {
+		// The typedef of pointer is named by the pointed type too.
+		root = typeName(ptr.Target)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("read " + root)
+
+	prev := rootName(expr.Left)
+	for j, offset := range ast.offsets {
+		path := strings.TrimPrefix(ast.paths[j], prev)
+		if j == 0 {
+			path = "." + strings.TrimLeft(path, "->.")
+		}
+		fmt.Fprintf(&sb, "%s(+%d)", path, offset)
+		prev = ast.paths[j]
+	}
+
+	sb.WriteString(" as " + typeName(ast.lastField) + "; " + match)
+	return sb.String()
+}
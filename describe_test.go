@@ -0,0 +1,33 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestDescribe(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		opts CompileOptions
+		exp  string
+	}{
+		{"skb->dev->ifindex == 1", CompileOptions{}, "read sk_buff.dev(+16)->ifindex(+224) as int; match if == 1"},
+		{"skb->len > 1024", CompileOptions{InvertVerdict: true}, "read sk_buff.len(+112) as unsigned int; match unless > 1024"},
+		{"skb->cb[1] == 2", CompileOptions{}, "read sk_buff.cb[1](+41) as char; match if == 2"},
+		{"skb->dev->name == \"lo\"", CompileOptions{}, "read sk_buff.dev(+16)->name(+304) as char[16]; match if == \"lo\""},
+		{"(skb->mark & 0xff) == 1", CompileOptions{}, "match if ((skb->mark & 0xff)) == 1"},
+		{"skb->mark in @allowlist", CompileOptions{}, "read sk_buff.mark(+168) as __u32; match if in @allowlist"},
+		{"skb->len > @rodata.threshold", CompileOptions{Rodata: getRodataBtf()}, "read sk_buff.len(+112) as unsigned int; match if > @rodata.threshold"},
+		{"(skb->mark & 0xff) == @rodata.threshold", CompileOptions{Rodata: getRodataBtf()}, "match if ((skb->mark & 0xff)) == @rodata.threshold"},
+	} {
+		tt.opts.Expr = tt.expr
+		tt.opts.Type = getSkbBtf(t)
+		res, err := Compile(tt.opts)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, res.Describe(), tt.exp)
+	}
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// diffMinuendReg is the callee-saved register to keep the minuend across the
+// read of the subtrahend.
+const diffMinuendReg = asm.R8
+
+// diffType is the type of the difference of two fields, which is compared as
+// signed 64-bit integer.
+var diffType = &btf.Int{Name: "long long", Size: 8, Encoding: btf.Signed}
+
+func isDiff(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Sub
+}
+
+func validateDiff(diff *cc.Expr) error {
+	for _, operand := range []*cc.Expr{diff.Left, diff.Right} {
+		if operand == nil || (operand.Op != cc.Arrow && operand.Op != cc.Dot && operand.Op != cc.Index) {
+			return fmt.Errorf("unexpected operand %v of subtraction; must be struct member access", operand)
+		}
+
+		if err := validateLeftOperand(operand); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readField generates the instructions to read the field from r1 to r3, and
+// reports whether labelExit is used.
+func readField(insns asm.Instructions, expr *cc.Expr, labelExit string, opts CompileOptions) (asm.Instructions, bool, error) {
+	ast, err := expr2offsetWithLookup(expr, opts.Type, opts.typeLookup(), opts.fieldAliases())
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to convert expr %s to access offsets: %w", expr, err)
+	}
+
+	if ast.bigEndian {
+		return nil, false, fmt.Errorf("cannot subtract big endian field %s", expr)
+	}
+
+	size, err := checkLastField(ast.member, ast.lastField)
+	if err != nil {
+		return nil, false, err
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(asm.R3, asm.R1), // r3 = r1
+	)
+
+	start := len(insns)
+	insns, labelUsed := offset2insns(insns, ast.offsets, asm.R3, labelExit, false)
+	if opts.Annotate {
+		annotateReads(insns[start:], ast.paths)
+	}
+
+	if IsMemberBitfield(ast.member) {
+		insns, _ = bitfield2insns(insns, 0, ast.member, asm.R3)
+	} else {
+		insns, _ = tgt2insns(insns, tgtInfo{typ: ast.lastField, sizof: size}, asm.R3)
+	}
+	if opts.ZeroExtend {
+		insns = zext2insns(insns, size, asm.R3)
+	}
+
+	return insns, labelUsed, nil
+}
+
+// compileDiff compiles the comparison of the difference of two fields like
+// skb->end - skb->head > 2048, which reads both fields and compares the
+// difference as signed 64-bit integer.
+func compileDiff(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, error) {
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare difference with register %s", opts.CompareReg)
+	}
+
+	if ri.expr != nil || ri.enum != "" {
+		constant, ok := opts.Constants[ri.enum]
+		if !ok {
+			return nil, fmt.Errorf("unexpected right operand %v of difference; must be number", expr.Right)
+		}
+		ri.constant = constant
+	}
+
+	labelFail := opts.failLabel()
+
+	var insns asm.Instructions
+	if opts.SkStorage != nil {
+		insns = skStorage2insns(insns, opts.SkStorage, labelFail)
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(ctxSaveReg, asm.R1), // r6 = r1
+	)
+
+	insns, minuendUsed, err := readField(insns, expr.Left.Left, labelFail, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(diffMinuendReg, asm.R3), // r8 = r3
+		asm.Mov.Reg(asm.R1, ctxSaveReg),     // r1 = r6
+	)
+
+	insns, subtrahendUsed, err := readField(insns, expr.Left.Right, labelFail, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	insns = append(insns,
+		asm.Sub.Reg(diffMinuendReg, asm.R3), // r8 -= r3
+		asm.Mov.Reg(asm.R3, diffMinuendReg), // r3 = r8
+	)
+
+	tgt := tgtInfo{constant: ri.constant, typ: diffType, sizof: 8}
+	insns, err = emitOp(insns, expr.Op, tgt, opts.OpEmitters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert operator to instructions: %w", err)
+	}
+
+	labelUsed := opts.SkStorage != nil || minuendUsed || subtrahendUsed
+	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+	if labelUsed && labelFail == labelExitFail {
+		xorR0 = xorR0.WithSymbol(labelExitFail)
+	}
+	insns = append(insns,
+		xorR0,                                // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
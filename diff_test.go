@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateDiff(t *testing.T) {
+	for _, tt := range []struct {
+		expr  string
+		valid bool
+	}{
+		{expr: "skb->end - skb->tail > 1", valid: true},
+		{expr: "skb->cb[1] - skb->cb[0] > 1", valid: true},
+		{expr: "skb->end - 1 > 1"},
+		{expr: "skb->end - skb->tail - skb->len > 1"},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			err = validate(expr)
+			if tt.valid {
+				test.AssertNoErr(t, err)
+			} else {
+				test.AssertHaveErr(t, err)
+			}
+		})
+	}
+}
+
+func TestCompileDiff(t *testing.T) {
+	t.Run("skb->end - skb->tail > 2048", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->end - skb->tail > 2048", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 192),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Reg(asm.R8, asm.R3),
+			asm.Mov.Reg(asm.R1, asm.R6),
+
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 188),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+
+			asm.Sub.Reg(asm.R8, asm.R3),
+			asm.Mov.Reg(asm.R3, asm.R8),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSGT.Imm(asm.R3, 2048, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("pointers", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->data - skb->head >= 14", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-6:], asm.Instructions{
+			asm.Sub.Reg(asm.R8, asm.R3),
+			asm.Mov.Reg(asm.R3, asm.R8),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSGE.Imm(asm.R3, 14, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("big endian field", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->protocol - skb->len > 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("compare register", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->end - skb->tail > x", Type: getSkbBtf(t), CompareReg: asm.R9})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->end - skb->tail > MAX", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+}
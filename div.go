@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+func validateDiv(div *cc.Expr) error {
+	if div.Right == nil || div.Right.Op != cc.Number {
+		return fmt.Errorf("unexpected divisor %v; must be constant number", div.Right)
+	}
+
+	if _, err := parseDivisor(div.Right.Text); err != nil {
+		return err
+	}
+
+	return validateLeftOperand(div.Left)
+}
+
+func parseDivisor(text string) (uint64, error) {
+	divisor, err := parseNumber(text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse divisor %s: %w", text, err)
+	}
+	if divisor == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+
+	return divisor, nil
+}
+
+// div2insns divides reg by the constant divisor in unsigned, with a right shift
+// for power-of-two divisors. R2 is used as scratch register if the divisor
+// does not fit in imm32.
+func div2insns(insns asm.Instructions, divisor uint64, reg asm.Register) asm.Instructions {
+	switch {
+	case divisor == 1:
+		return insns
+
+	case divisor&(divisor-1) == 0:
+		return append(insns,
+			asm.RSh.Imm(reg, int32(bits.TrailingZeros64(divisor))), // reg >>= log2(divisor)
+		)
+
+	case divisor <= math.MaxInt32:
+		return append(insns,
+			asm.Div.Imm(reg, int32(divisor)), // reg /= divisor
+		)
+
+	default:
+		return append(insns,
+			asm.LoadImm(asm.R2, int64(divisor), asm.DWord), // r2 = divisor
+			asm.Div.Reg(reg, asm.R2),                       // reg /= r2
+		)
+	}
+}
+
+// mod2insns computes the remainder of reg divided by the constant modulus in
+// unsigned, with a mask for power-of-two moduli. R2 is used as scratch
+// register if the modulus or the mask does not fit in imm32.
+func mod2insns(insns asm.Instructions, modulus uint64, reg asm.Register) asm.Instructions {
+	switch {
+	case modulus == 1:
+		return append(insns,
+			asm.Mov.Imm(reg, 0), // reg = 0
+		)
+
+	case modulus&(modulus-1) == 0 && modulus-1 <= math.MaxInt32:
+		return append(insns,
+			asm.And.Imm(reg, int32(modulus-1)), // reg &= modulus - 1
+		)
+
+	case modulus&(modulus-1) == 0:
+		return append(insns,
+			asm.LoadImm(asm.R2, int64(modulus-1), asm.DWord), // r2 = modulus - 1
+			asm.And.Reg(reg, asm.R2),                         // reg &= r2
+		)
+
+	case modulus <= math.MaxInt32:
+		return append(insns,
+			asm.Mod.Imm(reg, int32(modulus)), // reg %= modulus
+		)
+
+	default:
+		return append(insns,
+			asm.LoadImm(asm.R2, int64(modulus), asm.DWord), // r2 = modulus
+			asm.Mod.Reg(reg, asm.R2),                       // reg %= r2
+		)
+	}
+}
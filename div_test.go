@@ -0,0 +1,165 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestDiv2insns(t *testing.T) {
+	tests := []struct {
+		name    string
+		divisor uint64
+		insns   asm.Instructions
+	}{
+		{name: "one", divisor: 1},
+		{name: "power of two", divisor: 64, insns: asm.Instructions{asm.RSh.Imm(asm.R3, 6)}},
+		{name: "general", divisor: 100, insns: asm.Instructions{asm.Div.Imm(asm.R3, 100)}},
+		{name: "large", divisor: 0x100000001, insns: asm.Instructions{
+			asm.LoadImm(asm.R2, 0x100000001, asm.DWord),
+			asm.Div.Reg(asm.R3, asm.R2),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insns := div2insns(nil, tt.divisor, asm.R3)
+			test.AssertEqualSlice(t, insns, tt.insns)
+		})
+	}
+}
+
+func TestValidateDiv(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "skb->len / 0 == 1", err: "division by zero"},
+		{expr: "skb->len / x == 1", err: "unexpected divisor x"},
+		{expr: "skb->len / 0x == 1", err: "failed to parse divisor"},
+		{expr: "(skb->len + 1) / 2 == 1", err: "unexpected left operand"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, expr.Left.Op, cc.Div)
+
+			err = validate(expr)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestCompileDiv(t *testing.T) {
+	t.Run("skb->len / 64 == 10", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len / 64 == 10", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-7:], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 6),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 10, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->len / 100 > 10", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len / 100 > 10", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-5:len(res.Insns)-2], asm.Instructions{
+			asm.Div.Imm(asm.R3, 100),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 10, labelReturn),
+		})
+	})
+
+	t.Run("big endian skb->protocol / 256 == 8", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->protocol / 256 == 8", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-7:len(res.Insns)-2], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.HostTo(asm.BE, asm.R3, asm.Half),
+			asm.RSh.Imm(asm.R3, 8),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 8, labelReturn),
+		})
+	})
+
+	t.Run("division by zero", func(t *testing.T) {
+		expr, err := parse("skb->len / 0 == 1")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "division by zero")
+	})
+}
+
+func TestMod2insns(t *testing.T) {
+	tests := []struct {
+		name    string
+		modulus uint64
+		insns   asm.Instructions
+	}{
+		{name: "one", modulus: 1, insns: asm.Instructions{asm.Mov.Imm(asm.R3, 0)}},
+		{name: "power of two", modulus: 8, insns: asm.Instructions{asm.And.Imm(asm.R3, 7)}},
+		{name: "large power of two", modulus: 1 << 32, insns: asm.Instructions{
+			asm.LoadImm(asm.R2, 0xffffffff, asm.DWord),
+			asm.And.Reg(asm.R3, asm.R2),
+		}},
+		{name: "general", modulus: 100, insns: asm.Instructions{asm.Mod.Imm(asm.R3, 100)}},
+		{name: "large", modulus: 0x100000001, insns: asm.Instructions{
+			asm.LoadImm(asm.R2, 0x100000001, asm.DWord),
+			asm.Mod.Reg(asm.R3, asm.R2),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insns := mod2insns(nil, tt.modulus, asm.R3)
+			test.AssertEqualSlice(t, insns, tt.insns)
+		})
+	}
+}
+
+func TestCompileMod(t *testing.T) {
+	t.Run("skb->data % 8 == 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->data % 8 == 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-6:], asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 7),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->len % 3 != 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len % 3 != 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-5:len(res.Insns)-2], asm.Instructions{
+			asm.Mod.Imm(asm.R3, 3),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JNE.Imm(asm.R3, 0, labelReturn),
+		})
+	})
+
+	t.Run("modulo by zero", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->data % 0 == 0", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
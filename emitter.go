@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// OpTarget is the right side of the comparison passed to the OpEmitter.
+type OpTarget struct {
+	// Constant is the right operand in the byte order of the left operand.
+	Constant uint64
+
+	// Type is the type of the left operand, which is nil for the results of
+	// popcount and division.
+	Type btf.Type
+
+	// Size is the byte size of the left operand, which is 0 for bitfield.
+	Size int
+
+	// Signed is true if the left operand is a signed integer.
+	Signed bool
+
+	// Reg is the register to compare with instead of Constant if not 0, see
+	// CompileOptions.CompareReg.
+	Reg asm.Register
+
+	// LabelReturn is the label to jump to with r0 = 1 when matched.
+	LabelReturn string
+}
+
+// OpEmitter emits the instructions comparing the left operand in r3 with the
+// target. When matched, they must set r0 = 1 and jump to tgt.LabelReturn;
+// otherwise they fall through to return 0.
+type OpEmitter func(op string, tgt OpTarget) (asm.Instructions, error)
+
+// opSymbol returns the C symbol of the comparison operator, and '=' is the
+// same as '=='.
+func opSymbol(op cc.ExprOp) string {
+	switch op {
+	case cc.Eq, cc.EqEq:
+		return "=="
+	case cc.NotEq:
+		return "!="
+	case cc.Lt:
+		return "<"
+	case cc.LtEq:
+		return "<="
+	case cc.Gt:
+		return ">"
+	case cc.GtEq:
+		return ">="
+	default:
+		return op.String()
+	}
+}
+
+func validateOpEmitters(emitters map[string]OpEmitter) error {
+	for op, emit := range emitters {
+		if op == "=" {
+			return fmt.Errorf("unexpected operator = of emitter; use == instead")
+		}
+		if _, err := parseOperator(op); err != nil {
+			return fmt.Errorf("invalid operator of emitter: %w", err)
+		}
+		if emit == nil {
+			return fmt.Errorf("emitter of operator %s is nil", op)
+		}
+	}
+
+	return nil
+}
+
+// emitOp emits the comparison by the custom emitter of the operator if any,
+// or by op2insns.
+func emitOp(insns asm.Instructions, op cc.ExprOp, tgt tgtInfo, emitters map[string]OpEmitter) (asm.Instructions, error) {
+	sym := opSymbol(op)
+	emit, ok := emitters[sym]
+	if !ok {
+		return op2insns(insns, op, tgt)
+	}
+
+	custom, err := emit(sym, OpTarget{
+		Constant:    tgt.constant,
+		Type:        tgt.typ,
+		Size:        tgt.sizof,
+		Signed:      isSignedType(tgt.typ),
+		Reg:         tgt.srcReg,
+		LabelReturn: labelReturn,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("emitter of operator %s failed: %w", sym, err)
+	}
+
+	return append(insns, custom...), nil
+}
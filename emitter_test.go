@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestOpSymbol(t *testing.T) {
+	test.AssertEqual(t, opSymbol(cc.Eq), "==")
+	test.AssertEqual(t, opSymbol(cc.EqEq), "==")
+	test.AssertEqual(t, opSymbol(cc.NotEq), "!=")
+	test.AssertEqual(t, opSymbol(cc.Lt), "<")
+	test.AssertEqual(t, opSymbol(cc.LtEq), "<=")
+	test.AssertEqual(t, opSymbol(cc.Gt), ">")
+	test.AssertEqual(t, opSymbol(cc.GtEq), ">=")
+	test.AssertEqual(t, opSymbol(cc.Add), cc.Add.String())
+}
+
+func TestValidateOpEmitters(t *testing.T) {
+	emit := func(string, OpTarget) (asm.Instructions, error) { return nil, nil }
+
+	test.AssertNoErr(t, validateOpEmitters(nil))
+	test.AssertNoErr(t, validateOpEmitters(map[string]OpEmitter{"==": emit, ">=": emit}))
+
+	for _, tt := range []struct {
+		emitters map[string]OpEmitter
+		err      string
+	}{
+		{map[string]OpEmitter{"=": emit}, "unexpected operator = of emitter"},
+		{map[string]OpEmitter{"+": emit}, "invalid operator of emitter"},
+		{map[string]OpEmitter{"<": nil}, "emitter of operator < is nil"},
+	} {
+		err := validateOpEmitters(tt.emitters)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), tt.err)
+	}
+}
+
+func TestOpEmitters(t *testing.T) {
+	// The custom Eq emitter tests the bits instead of equality.
+	var got OpTarget
+	emitters := map[string]OpEmitter{
+		"==": func(op string, tgt OpTarget) (asm.Instructions, error) {
+			got = tgt
+			return asm.Instructions{
+				asm.Mov.Imm(asm.R0, 1),
+				asm.JSet.Imm(asm.R3, int32(tgt.Constant), tgt.LabelReturn),
+			}, nil
+		},
+	}
+
+	t.Run("custom Eq", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len = 1024", Type: getSkbBtf(t), OpEmitters: emitters})
+		test.AssertNoErr(t, err)
+
+		want := cloneSkbLen1024InsnsWithoutExitLabel()
+		want[len(want)-3] = asm.JSet.Imm(asm.R3, 1024, labelReturn)
+		test.AssertEqualSlice(t, res.Insns, want)
+
+		test.AssertEqual(t, got.Constant, uint64(1024))
+		test.AssertEqual(t, got.Size, 4)
+		test.AssertFalse(t, got.Signed)
+		test.AssertEqual(t, got.LabelReturn, labelReturn)
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 9", Type: getSkbBtf(t), OpEmitters: emitters})
+		test.AssertNoErr(t, err)
+		test.AssertTrue(t, got.Signed)
+	})
+
+	t.Run("default for other operators", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), OpEmitters: emitters})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("failed emitter", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr: "skb->len == 1024",
+			Type: getSkbBtf(t),
+			OpEmitters: map[string]OpEmitter{
+				"==": func(string, OpTarget) (asm.Instructions, error) { return nil, errors.New("oops") },
+			},
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("invalid emitters", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:       "skb->len == 1024",
+			Type:       getSkbBtf(t),
+			OpEmitters: map[string]OpEmitter{"=": emitters["=="]},
+		})
+		test.AssertHaveErr(t, err)
+	})
+}
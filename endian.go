@@ -3,9 +3,66 @@
 
 package bice
 
-import "encoding/binary"
-
-var (
-	ne = binary.NativeEndian
-	be = binary.BigEndian
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
 )
+
+var ne = binary.NativeEndian
+
+// nativeEndian is the host byte order as either binary.LittleEndian or
+// binary.BigEndian, because cilium/ebpf does not accept binary.NativeEndian to
+// encode instructions.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	if ne.Uint16([]byte{0x12, 0x34}) == 0x1234 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+func isBigEndian(order binary.ByteOrder) bool {
+	return order == binary.BigEndian
+}
+
+// byteOrder returns the byte order of the target, which is the host byte
+// order by default.
+func (opts *CompileOptions) byteOrder() binary.ByteOrder {
+	if opts.ByteOrder != nil {
+		return opts.ByteOrder
+	}
+	return nativeEndian
+}
+
+func validateByteOrder(order binary.ByteOrder) error {
+	if order != binary.LittleEndian && order != binary.BigEndian {
+		return fmt.Errorf("byte order %s is not supported, only little endian or big endian", order)
+	}
+	return nil
+}
+
+// h2ns converts v from the target byte order, big endian if targetBE, to
+// network byte order.
+func h2ns(v uint16, targetBE bool) uint16 {
+	if targetBE {
+		return v
+	}
+	return bits.ReverseBytes16(v)
+}
+
+// h2nl converts v from the target byte order to network byte order.
+func h2nl(v uint32, targetBE bool) uint32 {
+	if targetBE {
+		return v
+	}
+	return bits.ReverseBytes32(v)
+}
+
+// h2nll converts v from the target byte order to network byte order.
+func h2nll(v uint64, targetBE bool) uint64 {
+	if targetBE {
+		return v
+	}
+	return bits.ReverseBytes64(v)
+}
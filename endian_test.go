@@ -4,53 +4,68 @@
 package bice
 
 import (
+	"encoding/binary"
 	"testing"
 
 	"github.com/leonhwangprojects/bice/internal/test"
 )
 
-var isBigEndian = ne.Uint16([]byte{0x12, 0x34}) == 0x1234
-
 func TestEndian(t *testing.T) {
 	tests := []struct {
 		name string
 		n    uint64
-		be   uint64
-		fn   func(t *testing.T, n, exp uint64)
+		le   uint64
+		fn   func(t *testing.T, n, exp uint64, targetBE bool)
 	}{
 		{
 			name: "h2ns",
 			n:    0x1234,
-			be:   0x3412,
-			fn: func(t *testing.T, n, exp uint64) {
-				test.AssertEqual(t, h2ns(uint16(n)), uint16(exp))
+			le:   0x3412,
+			fn: func(t *testing.T, n, exp uint64, targetBE bool) {
+				test.AssertEqual(t, h2ns(uint16(n), targetBE), uint16(exp))
 			},
 		},
 		{
 			name: "h2nl",
 			n:    0x12345678,
-			be:   0x78563412,
-			fn: func(t *testing.T, n, exp uint64) {
-				test.AssertEqual(t, h2nl(uint32(n)), uint32(exp))
+			le:   0x78563412,
+			fn: func(t *testing.T, n, exp uint64, targetBE bool) {
+				test.AssertEqual(t, h2nl(uint32(n), targetBE), uint32(exp))
 			},
 		},
 		{
 			name: "h2nll",
 			n:    0x1234567890abcdef,
-			be:   0xefcdab9078563412,
-			fn: func(t *testing.T, n, exp uint64) {
-				test.AssertEqual(t, h2nll(n), exp)
+			le:   0xefcdab9078563412,
+			fn: func(t *testing.T, n, exp uint64, targetBE bool) {
+				test.AssertEqual(t, h2nll(n, targetBE), exp)
 			},
 		},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if isBigEndian {
-				tt.fn(t, tt.n, tt.n)
-			} else {
-				tt.fn(t, tt.n, tt.be)
-			}
+		t.Run(tt.name+"/little-endian target", func(t *testing.T) {
+			tt.fn(t, tt.n, tt.le, false)
+		})
+
+		t.Run(tt.name+"/big-endian target", func(t *testing.T) {
+			tt.fn(t, tt.n, tt.n, true)
 		})
 	}
+
+	t.Run("native", func(t *testing.T) {
+		test.AssertEqual(t, isBigEndian(nativeEndian), ne.Uint16([]byte{0x12, 0x34}) == 0x1234)
+	})
+
+	t.Run("byte order", func(t *testing.T) {
+		opts := CompileOptions{}
+		test.AssertEqual(t, opts.byteOrder(), nativeEndian)
+
+		opts.ByteOrder = binary.BigEndian
+		test.AssertEqual(t, opts.byteOrder(), binary.ByteOrder(binary.BigEndian))
+
+		_, err := Compile(CompileOptions{Expr: "skb->mark == 1", Type: getSkbBtf(t), ByteOrder: binary.NativeEndian})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "byte order")
+	})
 }
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import "fmt"
+
+// validateLabelFail checks the label to continue at on failure, which must
+// not collide with the internal labels.
+func validateLabelFail(label string) error {
+	if label == labelReturn || label == labelExitFail {
+		return fmt.Errorf("fail label %s collides with the internal labels", label)
+	}
+
+	return nil
+}
+
+// failLabel returns the label to jump to when failing to read the memory.
+func (opts *CompileOptions) failLabel() string {
+	if opts.LabelFail != "" {
+		return opts.LabelFail
+	}
+
+	return labelExitFail
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateLabelFail(t *testing.T) {
+	test.AssertNoErr(t, validateLabelFail("next_filter"))
+
+	for _, label := range []string{labelReturn, labelExitFail} {
+		err := validateLabelFail(label)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "fail label "+label+" collides")
+	}
+}
+
+// jumpTargets collects the references of the jumps.
+func jumpTargets(insns asm.Instructions) map[string]int {
+	targets := make(map[string]int)
+	for _, ins := range insns {
+		if ref := ins.Reference(); ref != "" && ins.OpCode.JumpOp() != asm.Call {
+			targets[ref]++
+		}
+	}
+	return targets
+}
+
+func TestLabelFail(t *testing.T) {
+	const labelNext = "next_filter"
+
+	t.Run("skb->dev->ifindex == 9", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      "skb->dev->ifindex == 9",
+			Type:      getSkbBtf(t),
+			LabelFail: labelNext,
+		})
+		test.AssertNoErr(t, err)
+
+		targets := jumpTargets(res.Insns)
+		test.AssertEqual(t, targets[labelNext], 1)
+		test.AssertEqual(t, targets[labelExitFail], 0)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-2:], asm.Instructions{
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("direct load", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:          "skb->dev->ifindex == 9",
+			Type:          getSkbBtf(t),
+			UseDirectLoad: true,
+			LabelFail:     labelNext,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, jumpTargets(res.Insns)[labelNext], 1)
+	})
+
+	t.Run("sk storage", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      "val->len == 9",
+			Type:      getSkbBtf(t),
+			SkStorage: &SkStorageOptions{Map: "sk_stg"},
+			LabelFail: labelNext,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, jumpTargets(res.Insns)[labelNext], 1)
+		test.AssertEqual(t, jumpTargets(res.Insns)[labelExitFail], 0)
+	})
+
+	t.Run("string", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      `skb->dev->name == "lo"`,
+			Type:      getSkbBtf(t),
+			LabelFail: labelNext,
+		})
+		test.AssertNoErr(t, err)
+
+		// The mismatch still returns 0.
+		targets := jumpTargets(res.Insns)
+		test.AssertEqual(t, targets[labelNext], 2)
+		test.AssertEqual(t, targets[labelExitFail], 2)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "skb->dev->ifindex == 9",
+			Type: getSkbBtf(t),
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, jumpTargets(res.Insns)[labelExitFail], 1)
+	})
+
+	t.Run("invalid label", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:      "skb->dev->ifindex == 9",
+			Type:      getSkbBtf(t),
+			LabelFail: labelReturn,
+		})
+		test.AssertHaveErr(t, err)
+	})
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	fixedFunc = "fixed"
+
+	// maxFixedBits is the max number of the fractional bits of fixed().
+	maxFixedBits = 32
+)
+
+// fixedRegexp matches the comparison of the Q-format field with the decimal
+// literal like fixed(skb->field, 8) > 1.5, whose literal cannot be parsed by
+// cc.
+var fixedRegexp = regexp.MustCompile(`\b` + fixedFunc + `\(\s*([^(),]+?)\s*,\s*([0-9]+)\s*\)\s*(==|!=|<=|>=|<|>)\s*(-?)\s*([0-9]+(?:\.[0-9]+)?)\b`)
+
+// foldFixed folds the comparison of the Q-format field with the decimal
+// literal like fixed(skb->field, 8) > 1.5 to the comparison of the raw field
+// with the literal scaled by 2^bits like skb->field > 384. The literal must be
+// exactly representable with the fractional bits.
+func foldFixed(expr string) (string, error) {
+	matches := fixedRegexp.FindAllStringSubmatchIndex(expr, -1)
+	if len(matches) == 0 {
+		return expr, nil
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		nbits, err := strconv.ParseUint(expr[m[4]:m[5]], 10, 8)
+		if err != nil || nbits == 0 || nbits > maxFixedBits {
+			return "", fmt.Errorf("invalid fractional bits %s of %s(); must be in [1, %d]", expr[m[4]:m[5]], fixedFunc, maxFixedBits)
+		}
+
+		literal := expr[m[10]:m[11]]
+		val, ok := new(big.Rat).SetString(literal)
+		if !ok {
+			return "", fmt.Errorf("failed to parse decimal %s of %s()", literal, fixedFunc)
+		}
+
+		val.Mul(val, new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), uint(nbits))))
+		if !val.IsInt() {
+			return "", fmt.Errorf("decimal %s is not representable with %d fractional bits of %s()", literal, nbits, fixedFunc)
+		}
+		if !val.Num().IsUint64() {
+			return "", fmt.Errorf("scaled decimal %s of %s() overflows uint64", literal, fixedFunc)
+		}
+
+		sb.WriteString(expr[last:m[0]])
+		sb.WriteString(expr[m[2]:m[3]])
+		sb.WriteString(" " + expr[m[6]:m[7]] + " " + expr[m[8]:m[9]])
+		sb.WriteString(val.Num().String())
+		last = m[1]
+	}
+	sb.WriteString(expr[last:])
+
+	return sb.String(), nil
+}
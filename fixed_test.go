@@ -0,0 +1,58 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestFoldFixed(t *testing.T) {
+	tests := []struct {
+		expr string
+		exp  string
+		err  string
+	}{
+		{expr: "skb->priority > 1", exp: "skb->priority > 1"},
+		{expr: "fixed(skb->priority, 8) > 1.5", exp: "skb->priority > 384"},
+		{expr: "fixed( skb->priority , 4 )<=2", exp: "skb->priority <= 32"},
+		{expr: "fixed(skb->skb_iif, 1) == -0.5", exp: "skb->skb_iif == -1"},
+		{expr: "fixed(skb->priority, 0) > 1", err: "invalid fractional bits 0"},
+		{expr: "fixed(skb->priority, 33) > 1", err: "invalid fractional bits 33"},
+		{expr: "fixed(skb->priority, 2) > 1.3", err: "decimal 1.3 is not representable with 2 fractional bits"},
+		{expr: "fixed(skb->priority, 32) > 18446744073709551615", err: "scaled decimal 18446744073709551615 of fixed() overflows uint64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := foldFixed(tt.expr)
+			if tt.err != "" {
+				test.AssertHaveErr(t, err)
+				test.AssertStrPrefix(t, err.Error(), tt.err)
+				return
+			}
+
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, got, tt.exp)
+		})
+	}
+}
+
+func TestCompileFixed(t *testing.T) {
+	res, err := Compile(CompileOptions{Expr: "fixed(skb->priority, 8) > 1.5", Type: getSkbBtf(t)})
+	test.AssertNoErr(t, err)
+
+	insns := res.Insns
+	test.AssertEqualSlice(t, insns[len(insns)-6:], asm.Instructions{
+		asm.LSh.Imm(asm.R3, 32),
+		asm.RSh.Imm(asm.R3, 32),
+		asm.Mov.Imm(asm.R0, 1),
+		asm.JGT.Imm(asm.R3, 384, labelReturn),
+		asm.Xor.Reg(asm.R0, asm.R0),
+		asm.Return().WithSymbol(labelReturn),
+	})
+}
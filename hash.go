@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+const (
+	hashFunc = "hash"
+
+	// maxHashSize is the max size of the byte range to hash, which is read
+	// to stack.
+	maxHashSize = 64
+
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// hashRegexp matches the byte range of hash() like hash(skb->data[0:14]),
+// whose slice cannot be parsed by cc.
+var hashRegexp = regexp.MustCompile(`\b` + hashFunc + `\(\s*([^()\[\]]+?)\[\s*(\w+)\s*:\s*(\w+)\s*\]\s*\)`)
+
+// foldHash rewrites the byte range of hash() to the call with the start and
+// end like hash(skb->data, 0, 14).
+func foldHash(expr string) string {
+	return hashRegexp.ReplaceAllString(expr, hashFunc+"($1, $2, $3)")
+}
+
+func isHash(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == hashFunc
+}
+
+func validateHash(call *cc.Expr) error {
+	if len(call.List) != 3 {
+		return fmt.Errorf("%s() expects a byte range like %s(skb->data[0:14])", hashFunc, hashFunc)
+	}
+
+	for _, arg := range call.List[1:] {
+		if arg.Op != cc.Number {
+			return fmt.Errorf("unexpected bound %v of %s(); must be constant number", arg, hashFunc)
+		}
+	}
+
+	if _, _, err := parseHashRange(call); err != nil {
+		return err
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+// parseHashRange parses the byte range [start, end) of hash(), which must not
+// be empty and is at most maxHashSize bytes.
+func parseHashRange(call *cc.Expr) (int, int, error) {
+	start, err := parseNumber(call.List[1].Text)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse start %s of %s(): %w", call.List[1].Text, hashFunc, err)
+	}
+
+	end, err := parseNumber(call.List[2].Text)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse end %s of %s(): %w", call.List[2].Text, hashFunc, err)
+	}
+
+	if start >= end || end-start > maxHashSize || end > math.MaxInt32 {
+		return 0, 0, fmt.Errorf("invalid range [%d:%d] of %s(); must be non-empty and at most %d bytes", start, end, hashFunc, maxHashSize)
+	}
+
+	return int(start), int(end), nil
+}
+
+// fnv2insns computes the 32-bit FNV-1a hash of the size bytes on stack at
+// r10+off to reg, whose value is zero-extended. R2 is used as scratch
+// register.
+func fnv2insns(insns asm.Instructions, off int16, size int, reg asm.Register) asm.Instructions {
+	basis := uint32(fnvOffset32)
+	insns = append(insns,
+		asm.Mov.Imm32(reg, int32(basis)), // reg = offset basis
+	)
+
+	for i := 0; i < size; i++ {
+		insns = append(insns,
+			asm.LoadMem(asm.R2, asm.R10, off+int16(i), asm.Byte), // r2 = *(u8 *)(r10 + off + i)
+			asm.Xor.Reg32(reg, asm.R2),                           // reg ^= r2
+			asm.Mul.Imm32(reg, fnvPrime32),                       // reg *= prime
+		)
+	}
+
+	return insns
+}
+
+// compileHash compiles the comparison of the 32-bit FNV-1a hash of the byte
+// range of the array or the memory pointed by the pointer, like
+// hash(skb->data[0:14]) == 0x1234, which is compared as unsigned 32-bit.
+func compileHash(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, error) {
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare %s() with register %s", hashFunc, opts.CompareReg)
+	}
+
+	if ri.expr != nil || ri.enum != "" {
+		constant, ok := opts.Constants[ri.enum]
+		if !ok {
+			return nil, fmt.Errorf("unexpected right operand %v of %s(); must be number", expr.Right, hashFunc)
+		}
+		ri.constant = constant
+	}
+	if ri.constant > math.MaxUint32 {
+		return nil, fmt.Errorf("constant %#x of %s() exceeds 32 bits", ri.constant, hashFunc)
+	}
+
+	call := expr.Left
+	start, end, err := parseHashRange(call)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, err := expr2offsetWithLookup(call.List[0], opts.Type, opts.typeLookup(), opts.fieldAliases())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert expr to access offsets: %w", err)
+	}
+
+	isArr := false
+	switch typ := mybtf.UnderlyingType(ast.lastField).(type) {
+	case *btf.Array:
+		size, err := btf.Sizeof(typ)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get size of %s: %w", ast.lastField, err)
+		}
+		if len(ast.offsets) == 0 {
+			return nil, fmt.Errorf("unexpected array %s of %s(); must be embedded in struct", ast.lastField, hashFunc)
+		}
+		if end > size {
+			return nil, fmt.Errorf("range [%d:%d] of %s() is out of %s", start, end, hashFunc, ast.lastField)
+		}
+		isArr = true
+
+	case *btf.Pointer:
+
+	default:
+		return nil, fmt.Errorf("unexpected type %s of %s(); must be array or pointer", ast.lastField, hashFunc)
+	}
+
+	labelFail := opts.failLabel()
+
+	var insns asm.Instructions
+	if opts.SkStorage != nil {
+		insns = skStorage2insns(insns, opts.SkStorage, labelFail)
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(asm.R3, asm.R1), // r3 = r1
+	)
+
+	// r3 is the address of the start in the array, or the value of the
+	// pointer.
+	offsets := ast.offsets
+	if isArr {
+		offsets = slices.Clone(offsets)
+		offsets[len(offsets)-1] += uint32(start)
+	}
+	insns, _ = offset2insns(insns, offsets, asm.R3, labelFail, isArr)
+	if opts.Annotate {
+		annotateReads(insns, ast.paths)
+	}
+	if !isArr && start != 0 {
+		insns = append(insns, asm.Add.Imm(asm.R3, int32(start))) // r3 += start
+	}
+
+	size := end - start
+	off := -8 - int16((size+7)/8*8)
+	insns = append(insns,
+		asm.Mov.Reg(asm.R1, asm.R10),      // r1 = r10
+		asm.Add.Imm(asm.R1, int32(off)),   // r1 = r10 + off
+		asm.Mov.Imm(asm.R2, int32(size)),  // r2 = size
+		asm.FnProbeReadKernel.Call(),      // bpf_probe_read_kernel(r1, size, r3)
+		asm.JNE.Imm(asm.R0, 0, labelFail), // if r0 != 0, goto fail
+	)
+
+	insns = fnv2insns(insns, off, size, asm.R3)
+
+	// if w3 <op> constant, goto __return
+	jmpOpCode, err := op2jump(expr.Op, false)
+	if err != nil {
+		return nil, err
+	}
+
+	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+	if labelFail == labelExitFail {
+		xorR0 = xorR0.WithSymbol(labelExitFail)
+	}
+	insns = append(insns,
+		asm.Mov.Imm(asm.R0, 1), // r0 = 1
+		jmpOpCode.Imm32(asm.R3, int32(uint32(ri.constant)), labelReturn),
+		xorR0,                                // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"hash/fnv"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestFoldHash(t *testing.T) {
+	test.AssertEqual(t, foldHash("hash(skb->data[0:14]) == 0x1234"), "hash(skb->data, 0, 14) == 0x1234")
+	test.AssertEqual(t, foldHash("hash( skb->cb[ 4 : 0x8 ] ) == 1"), "hash(skb->cb, 4, 0x8) == 1")
+	test.AssertEqual(t, foldHash("skb->cb[4] == 1"), "skb->cb[4] == 1")
+}
+
+// runFnv emulates the instructions emitted by fnv2insns over the stack.
+func runFnv(t *testing.T, insns asm.Instructions, stack []byte, regs map[asm.Register]uint64) {
+	for _, ins := range insns {
+		if ins.OpCode.Class().IsLoad() {
+			regs[ins.Dst] = uint64(stack[len(stack)+int(ins.Offset)])
+			continue
+		}
+
+		runALU64(t, asm.Instructions{ins}, regs)
+		if ins.OpCode.Class() == asm.ALUClass {
+			regs[ins.Dst] = uint64(uint32(regs[ins.Dst]))
+		}
+	}
+}
+
+func TestFnv2insns(t *testing.T) {
+	data := []byte{0x00, 0x1b, 0x21, 0x3c, 0x9d, 0xf8, 0x00, 0x0c, 0x29, 0x4f, 0x6a, 0x1e, 0x08, 0x00}
+
+	stack := make([]byte, 8+16)
+	copy(stack, data)
+
+	regs := map[asm.Register]uint64{asm.R3: 0xffffffffffffffff}
+	runFnv(t, fnv2insns(nil, -24, len(data), asm.R3), stack, regs)
+
+	h := fnv.New32a()
+	_, _ = h.Write(data)
+	test.AssertEqual(t, regs[asm.R3], uint64(h.Sum32()))
+}
+
+func TestCompileHash(t *testing.T) {
+	t.Run("hash(skb->data[0:14]) == 0x1234", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "hash(skb->data[0:14]) == 0x1234", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:13], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 208),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -24),
+			asm.Mov.Imm(asm.R2, 14),
+			asm.FnProbeReadKernel.Call(),
+			asm.JNE.Imm(asm.R0, 0, labelExitFail),
+			asm.Mov.Imm32(asm.R3, -2128831035),
+		})
+		test.AssertEqualSlice(t, insns[12:len(insns)-4], fnv2insns(nil, -24, 14, asm.R3))
+		test.AssertEqualSlice(t, insns[len(insns)-4:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm32(asm.R3, 0x1234, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("hash(skb->cb[4:8]) != 0x811c9dc5", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "hash(skb->cb[4:8]) != 0x811c9dc5", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:7], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 44),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -16),
+			asm.Mov.Imm(asm.R2, 4),
+			asm.FnProbeReadKernel.Call(),
+			asm.JNE.Imm(asm.R0, 0, labelExitFail),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-3:len(insns)-2], asm.Instructions{
+			asm.JNE.Imm32(asm.R3, -2128831035, labelReturn),
+		})
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "hash(skb->cb[40:49]) == 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(hash(skb->cb[40:49]) == 1): range [40:49] of hash() is out of")
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		for _, expr := range []string{"hash(skb->data[14:0]) == 1", "hash(skb->data[0:65]) == 1", "hash(skb->data) == 1"} {
+			_, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+		}
+	})
+
+	t.Run("unexpected type", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "hash(skb->len[0:4]) == 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(hash(skb->len[0:4]) == 1): unexpected type")
+	})
+
+	t.Run("too large constant", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "hash(skb->data[0:14]) == 0x100000000", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+}
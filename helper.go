@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+// validateReadHelper checks the helper ID to read memory, which must be a
+// positive helper ID.
+func validateReadHelper(fn asm.BuiltinFunc) error {
+	if fn <= asm.FnUnspec {
+		return fmt.Errorf("invalid read helper ID %d; must be positive", int32(fn))
+	}
+
+	return nil
+}
+
+// replaceReadHelper replaces the bpf_probe_read_kernel() calls with the given
+// helper, whose arguments must be the same as bpf_probe_read_kernel().
+func replaceReadHelper(insns asm.Instructions, fn asm.BuiltinFunc) asm.Instructions {
+	for i := range insns {
+		if insns[i].IsBuiltinCall() && insns[i].Constant == int64(asm.FnProbeReadKernel) {
+			insns[i].Constant = int64(fn)
+		}
+	}
+
+	return insns
+}
@@ -0,0 +1,63 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateReadHelper(t *testing.T) {
+	test.AssertNoErr(t, validateReadHelper(asm.FnProbeRead))
+	test.AssertNoErr(t, validateReadHelper(asm.BuiltinFunc(10000)))
+
+	err := validateReadHelper(asm.BuiltinFunc(-1))
+	test.AssertHaveErr(t, err)
+	test.AssertStrPrefix(t, err.Error(), "invalid read helper ID -1")
+}
+
+func TestReadHelper(t *testing.T) {
+	t.Run("custom helper", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:       "skb->dev->ifindex == 9",
+			Type:       getSkbBtf(t),
+			ReadHelper: asm.BuiltinFunc(10000),
+		})
+		test.AssertNoErr(t, err)
+
+		calls := 0
+		for _, insn := range res.Insns {
+			if insn.IsBuiltinCall() {
+				calls++
+				test.AssertEqual(t, insn.Constant, int64(10000))
+			}
+		}
+		test.AssertEqual(t, calls, 2)
+	})
+
+	t.Run("keep other helpers", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:       "val->len == 9",
+			Type:       getSkbBtf(t),
+			SkStorage:  &SkStorageOptions{Map: "sk_stg"},
+			ReadHelper: asm.FnProbeRead,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[4:5], asm.Instructions{asm.FnSkStorageGet.Call()})
+		test.AssertEqualSlice(t, res.Insns[12:13], asm.Instructions{asm.FnProbeRead.Call()})
+	})
+
+	t.Run("invalid helper", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:       "skb->len == 9",
+			Type:       getSkbBtf(t),
+			ReadHelper: asm.BuiltinFunc(-2),
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid read helper ID")
+	})
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// stackOffsetIndex is the stack slot of the byte offset of the dynamic index.
+const stackOffsetIndex = -24
+
+// isDynamicIndex reports whether the array access is indexed by a member
+// access of the same root like skb->cb[skb->queue_mapping].
+func isDynamicIndex(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Index && isMemberAccess(expr.Right)
+}
+
+// indexMask returns the smallest all-ones mask covering the byte offsets less
+// than size.
+func indexMask(size uint32) int32 {
+	mask := uint32(1)
+	for mask < size {
+		mask <<= 1
+	}
+	return int32(mask - 1)
+}
+
+func validateDynamicIndex(index *cc.Expr) error {
+	if err := validateLeftOperand(index.Right); err != nil {
+		return fmt.Errorf("unexpected index %v: %w", index.Right, err)
+	}
+
+	return validateLeftOperand(index.Left)
+}
+
+// compileDynamicIndex compiles the comparison of the element of the array
+// embedded in the struct, whose index is read from another field, like
+// skb->cb[skb->queue_mapping] == 1. The index out of range mismatches.
+func compileDynamicIndex(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, error) {
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare dynamic index with register %s", opts.CompareReg)
+	}
+
+	if ri.expr != nil || ri.enum != "" {
+		constant, ok := opts.Constants[ri.enum]
+		if !ok {
+			return nil, fmt.Errorf("unexpected right operand %v of dynamic index; must be number", expr.Right)
+		}
+		ri.constant = constant
+	}
+
+	index := expr.Left
+	arrAst, err := expr2offsetWithLookup(index.Left, opts.Type, opts.typeLookup(), opts.fieldAliases())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert expr %s to access offsets: %w", index.Left, err)
+	}
+
+	arr, ok := mybtf.UnderlyingType(arrAst.lastField).(*btf.Array)
+	if !ok || len(arrAst.offsets) == 0 {
+		return nil, fmt.Errorf("unexpected type %s of %s; must be array embedded in struct", arrAst.lastField, index.Left)
+	}
+
+	elemSize, err := checkLastField(nil, arr.Type)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected element of %s: %w", index.Left, err)
+	}
+
+	labelFail := opts.failLabel()
+
+	var insns asm.Instructions
+	if opts.SkStorage != nil {
+		insns = skStorage2insns(insns, opts.SkStorage, labelFail)
+	}
+
+	insns = append(insns,
+		asm.StoreMem(asm.R10, stackOffsetRoot, asm.R1, asm.DWord), // *(r10 - 16) = r1
+	)
+
+	idx, err := access(index.Right, AccessOptions{
+		Insns:     insns,
+		Type:      opts.Type,
+		Src:       asm.R1,
+		Dst:       asm.R3,
+		LabelExit: labelFail,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to access index %s: %w", index.Right, err)
+	}
+
+	insns, err = be2host(idx.Insns, idx.LastField, mybtf.IsBigEndian(idx.LastField), asm.R3)
+	if err != nil {
+		return nil, err
+	}
+
+	// The index is bounds-checked before reading the element. As the index is
+	// zero-extended, a negative signed index is a huge unsigned one, so the
+	// unsigned check bounds both ends.
+	insns = append(insns,
+		asm.JGE.Imm(asm.R3, int32(arr.Nelems), labelFail), // if r3 >= nelems, goto __exit
+	)
+	if elemSize != 1 {
+		insns = append(insns, asm.Mul.Imm(asm.R3, int32(elemSize))) // r3 *= size
+	}
+	insns = append(insns,
+		asm.StoreMem(asm.R10, stackOffsetIndex, asm.R3, asm.DWord), // *(r10 - 24) = r3
+		asm.LoadMem(asm.R1, asm.R10, stackOffsetRoot, asm.DWord),   // r1 = *(r10 - 16)
+		asm.Mov.Reg(asm.R3, asm.R1),                                // r3 = r1
+	)
+
+	// r3 is the address of the array.
+	insns, _ = offset2insns(insns, arrAst.offsets, asm.R3, labelFail, true)
+	insns = append(insns,
+		asm.LoadMem(asm.R2, asm.R10, stackOffsetIndex, asm.DWord), // r2 = *(r10 - 24)
+	)
+	if opts.ZeroExtend {
+		// The byte offset reloaded from stack is bounded explicitly by the
+		// mask covering the array, as it's less than nelems * size.
+		insns = append(insns,
+			asm.And.Imm(asm.R2, indexMask(arr.Nelems*uint32(elemSize))), // r2 &= mask
+		)
+	}
+	insns = append(insns,
+		asm.Add.Reg(asm.R3, asm.R2), // r3 += r2
+	)
+	insns, _ = offset2insns(insns, []uint32{0}, asm.R3, labelFail, false)
+
+	tgt := tgtInfo{constant: ri.constant, typ: arr.Type, sizof: elemSize, bigEndian: mybtf.IsBigEndian(arr.Type), targetBE: isBigEndian(opts.byteOrder())}
+	tgt.signExtended = isSignedType(arr.Type) && !tgt.bigEndian
+	insns, tgt.constant = tgt2insns(insns, tgt, asm.R3)
+
+	insns, err = emitOp(insns, expr.Op, tgt, opts.OpEmitters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert operator to instructions: %w", err)
+	}
+
+	// The bounds check jumps to the fail label always.
+	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+	if labelFail == labelExitFail {
+		xorR0 = xorR0.WithSymbol(labelExitFail)
+	}
+	insns = append(insns,
+		xorR0,                                // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestCompileDynamicIndex(t *testing.T) {
+	t.Run("skb->cb[skb->queue_mapping] == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->cb[skb->queue_mapping] == 1", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.StoreMem(asm.R10, -16, asm.R1, asm.DWord),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 124),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 0xFFFF),
+			asm.JGE.Imm(asm.R3, 48, labelExitFail),
+			asm.StoreMem(asm.R10, -24, asm.R3, asm.DWord),
+			asm.LoadMem(asm.R1, asm.R10, -16, asm.DWord),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 40),
+			asm.LoadMem(asm.R2, asm.R10, -24, asm.DWord),
+			asm.Add.Reg(asm.R3, asm.R2),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.And.Imm(asm.R3, 0xFF),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 1, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("s->vals[s->idx] > 5", func(t *testing.T) {
+		u32 := &btf.Int{Name: "unsigned int", Size: 4}
+		typ := &btf.Pointer{Target: &btf.Struct{
+			Name: "s",
+			Size: 20,
+			Members: []btf.Member{
+				{Name: "idx", Type: u32},
+				{Name: "vals", Type: &btf.Array{Type: u32, Nelems: 4}, Offset: 32},
+			},
+		}}
+
+		res, err := Compile(CompileOptions{Expr: "s->vals[s->idx] > 5", Type: typ})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[9:12], asm.Instructions{
+			asm.JGE.Imm(asm.R3, 4, labelExitFail),
+			asm.Mul.Imm(asm.R3, 4),
+			asm.StoreMem(asm.R10, -24, asm.R3, asm.DWord),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-6:len(insns)-2], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 5, labelReturn),
+		})
+	})
+
+	t.Run("bounds check of signed index", func(t *testing.T) {
+		s32 := &btf.Int{Name: "int", Size: 4, Encoding: btf.Signed}
+		typ := &btf.Pointer{Target: &btf.Struct{
+			Name: "s",
+			Size: 20,
+			Members: []btf.Member{
+				{Name: "idx", Type: s32},
+				{Name: "vals", Type: &btf.Array{Type: s32, Nelems: 4}, Offset: 32},
+			},
+		}}
+
+		res, err := Compile(CompileOptions{Expr: "s->vals[s->idx] == 1", Type: typ})
+		test.AssertNoErr(t, err)
+
+		// The zero-extended index is checked before the element is read.
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[7:10], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.JGE.Imm(asm.R3, 4, labelExitFail),
+		})
+
+		var reads []int
+		for i, ins := range insns {
+			if ins.IsBuiltinCall() && ins.Constant == int64(asm.FnProbeReadKernel) {
+				reads = append(reads, i)
+			}
+		}
+		test.AssertEqual(t, len(reads), 2)
+		test.AssertTrue(t, reads[0] < 9 && 9 < reads[1])
+	})
+
+	t.Run("LabelFail", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->cb[skb->queue_mapping] == 1", Type: getSkbBtf(t), LabelFail: "next_filter"})
+		test.AssertNoErr(t, err)
+
+		targets := jumpTargets(res.Insns)
+		test.AssertEqual(t, targets["next_filter"], 1)
+		test.AssertEqual(t, targets[labelExitFail], 0)
+		test.AssertEqualSlice(t, res.Insns[9:10], asm.Instructions{
+			asm.JGE.Imm(asm.R3, 48, "next_filter"),
+		})
+		test.AssertEqual(t, res.Insns[len(res.Insns)-2].Symbol(), "")
+	})
+
+	t.Run("sk storage", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      "skb->cb[skb->queue_mapping] == 1",
+			Type:      getSkbBtf(t),
+			SkStorage: &SkStorageOptions{Map: "sk_stg"},
+		})
+		test.AssertNoErr(t, err)
+
+		stg := skStorage2insns(nil, &SkStorageOptions{Map: "sk_stg"}, labelExitFail)
+		test.AssertEqualSlice(t, res.Insns[:len(stg)], stg)
+		test.AssertEqualSlice(t, res.Insns[len(stg):len(stg)+1], asm.Instructions{
+			asm.StoreMem(asm.R10, -16, asm.R1, asm.DWord),
+		})
+	})
+
+	t.Run("not array", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->dev[skb->queue_mapping] == 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("invalid index", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->cb[skb->len + 1] == 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+// insnLines renders the instructions one per line, with the label line like
+// "__return_bice_filter:" before the instruction defining it. The jumps refer
+// to the labels by name, so the lines don't depend on the offsets.
+func insnLines(insns asm.Instructions) []string {
+	lines := make([]string, 0, len(insns))
+	for _, ins := range insns {
+		if sym := ins.Symbol(); sym != "" {
+			lines = append(lines, sym+":")
+		}
+		lines = append(lines, fmt.Sprintf("\t%v", ins))
+	}
+
+	return lines
+}
+
+// DiffInsns returns the line diff of two instruction sequences, e.g. the
+// filters compiled from the same expression by two versions, with the lines
+// only in a prefixed by "- ", the lines only in b by "+ ", and the common
+// lines by "  ". It returns "" if they are the same.
+func DiffInsns(a, b asm.Instructions) string {
+	x, y := insnLines(a), insnLines(b)
+
+	// lcs[i][j] is the length of the longest common lines of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var (
+		sb      strings.Builder
+		changed bool
+	)
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			sb.WriteString("  " + x[i] + "\n")
+			i, j = i+1, j+1
+
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + x[i] + "\n")
+			i, changed = i+1, true
+
+		default:
+			sb.WriteString("+ " + y[j] + "\n")
+			j, changed = j+1, true
+		}
+	}
+
+	if !changed {
+		return ""
+	}
+	return sb.String()
+}
@@ -0,0 +1,54 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestDiffInsns(t *testing.T) {
+	t.Run("different filters", func(t *testing.T) {
+		a, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		b, err := Compile(CompileOptions{Expr: "skb->len >= 1024", Type: getSkbBtf(t), WordLoad: true})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqual(t, DiffInsns(a.Insns, b.Insns), ""+
+			"  \tMovReg dst: r3 src: r1\n"+
+			"  \tAddImm dst: r3 imm: 112\n"+
+			"  \tMovImm dst: r2 imm: 8\n"+
+			"  \tMovReg dst: r1 src: rfp\n"+
+			"  \tAddImm dst: r1 imm: -8\n"+
+			"  \tCall FnProbeReadKernel\n"+
+			"- \tLdXMemDW dst: r3 src: rfp off: -8 imm: 0\n"+
+			"- \tLShImm dst: r3 imm: 32\n"+
+			"- \tRShImm dst: r3 imm: 32\n"+
+			"+ \tLdXMemW dst: r3 src: rfp off: -8 imm: 0\n"+
+			"  \tMovImm dst: r0 imm: 1\n"+
+			"- \tJGTImm dst: r3 off: -1 imm: 1024 <__return_bice_filter>\n"+
+			"+ \tJGEImm dst: r3 off: -1 imm: 1024 <__return_bice_filter>\n"+
+			"  \tXorReg dst: r0 src: r0\n"+
+			"  __return_bice_filter:\n"+
+			"  \tExit\n")
+	})
+
+	t.Run("same filters", func(t *testing.T) {
+		a, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqual(t, DiffInsns(a.Insns, a.Insns), "")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		b, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		diff := DiffInsns(nil, b.Insns)
+		test.AssertStrPrefix(t, diff, "+ \tMovReg dst: r3 src: r1\n")
+		test.AssertFalse(t, strings.Contains(diff, "\n  "))
+	})
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import "rsc.io/c2go/cc"
+
+// listFirstFunc is the pseudo-function of the first entry of the list like
+// list_first_entry() in kernel.
+const listFirstFunc = "list_first"
+
+// rewriteListFirst rewrites list_first(head, type, member) to
+// container_of(head->next, type, member) in place, which follows the first link
+// of the embedded struct list_head like task->children.
+func rewriteListFirst(expr *cc.Expr) {
+	if expr == nil {
+		return
+	}
+
+	if expr.Op == cc.Call && expr.Left != nil && expr.Left.Op == cc.Name && expr.Left.Text == listFirstFunc && len(expr.List) != 0 {
+		expr.Left.Text = containerOfFunc
+		expr.List[0] = &cc.Expr{Op: cc.Arrow, Left: expr.List[0], Text: "next"}
+	}
+
+	rewriteListFirst(expr.Left)
+	rewriteListFirst(expr.Right)
+	for _, e := range expr.List {
+		rewriteListFirst(e)
+	}
+}
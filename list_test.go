@@ -0,0 +1,57 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestRewriteListFirst(t *testing.T) {
+	expr, err := parse("list_first(task->children, struct task_struct, sibling)->pid == 1")
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, expr.Left.Left.String(), "container_of(task->children->next, task_struct, sibling)")
+	test.AssertNoErr(t, validate(expr))
+
+	expr, err = parse("task->pid == 1")
+	test.AssertNoErr(t, err)
+	test.AssertEqual(t, expr.Left.Op, cc.Arrow)
+}
+
+func TestCompileListFirst(t *testing.T) {
+	task := getTaskBtf(t).Target.(*btf.Struct)
+	children, err := mybtf.StructMemberOffset(task, "children")
+	test.AssertNoErr(t, err)
+	sibling, err := mybtf.StructMemberOffset(task, "sibling")
+	test.AssertNoErr(t, err)
+	pid, err := mybtf.StructMemberOffset(task, "pid")
+	test.AssertNoErr(t, err)
+
+	res, err := Compile(CompileOptions{
+		Expr: "list_first(task->children, struct task_struct, sibling)->pid == 1",
+		Type: getTaskBtf(t),
+		Spec: testBtf,
+	})
+	test.AssertNoErr(t, err)
+
+	// The first link task->children.next is read, and the pid is read at the
+	// offset from the sibling member of the container.
+	test.AssertEqualSlice(t, res.Insns[:9], asm.Instructions{
+		asm.Mov.Reg(asm.R3, asm.R1),
+		asm.Add.Imm(asm.R3, int32(children)),
+		asm.Mov.Imm(asm.R2, 8),
+		asm.Mov.Reg(asm.R1, asm.R10),
+		asm.Add.Imm(asm.R1, -8),
+		asm.FnProbeReadKernel.Call(),
+		asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+		asm.JEq.Imm(asm.R3, 0, labelExitFail),
+		asm.Add.Imm(asm.R3, int32(pid-sibling)),
+	})
+}
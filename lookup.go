@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// mapRefPrefix prefixes the map name of "in @map" as the right operand name.
+const mapRefPrefix = "__bice_map_"
+
+const (
+	stackOffsetKey = -8 // the key of hash map, which is the read buffer
+
+	// stackOffsetLPMKey is struct bpf_lpm_trie_key { __u32 prefixlen; __u8
+	// data[]; }, whose data is at -16 to be aligned for the 8-byte key.
+	stackOffsetLPMKey = -20
+)
+
+// mapInRegexp matches the map membership like iph->saddr in @allowlist, which
+// cannot be parsed by cc.
+var mapInRegexp = regexp.MustCompile(`^\s*(.+?)\s+in\s+@([A-Za-z_]\w*)\s*$`)
+
+// foldMapIn rewrites the map membership to the comparison with the map
+// reference like iph->saddr == __bice_map_allowlist.
+func foldMapIn(expr string) string {
+	return mapInRegexp.ReplaceAllString(expr, "$1 == "+mapRefPrefix+"$2")
+}
+
+// mapRef returns the map name if the right operand is a map reference.
+func mapRef(right *cc.Expr) (string, bool) {
+	if right == nil || right.Op != cc.Name || !strings.HasPrefix(right.Text, mapRefPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(right.Text, mapRefPrefix), true
+}
+
+// compileMapLookup compiles the map membership like iph->saddr in @allowlist,
+// which looks up the map with the field as the key via bpf_map_lookup_elem(),
+// and matches if the element is found. The key is the field in memory byte
+// order, or struct bpf_lpm_trie_key with the full prefix length if
+// CompileOptions.LPMKey is set. The verdict of the lookup cannot be compared
+// with CompileOptions.CompareReg.
+func compileMapLookup(expr *cc.Expr, name string, opts CompileOptions) (asm.Instructions, error) {
+	if expr.Op != cc.Eq && expr.Op != cc.EqEq {
+		return nil, fmt.Errorf("unexpected operator %s of map lookup; must be in", expr.Op)
+	}
+
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare map lookup with register %s", opts.CompareReg)
+	}
+
+	ast, err := expr2offsetWithLookup(expr.Left, opts.Type, opts.typeLookup(), opts.fieldAliases())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert expr to access offsets: %w", err)
+	}
+
+	if opts.CgroupSkb {
+		if err := checkCgroupSkb(expr.Left, ast, opts.Type); err != nil {
+			return nil, err
+		}
+	}
+
+	if IsMemberBitfield(ast.member) {
+		return nil, fmt.Errorf("cannot use bitfield '%s' as map key", ast.member.Name)
+	}
+
+	size, err := checkLastField(ast.member, ast.lastField)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lookup miss jumps to __exit always.
+	var insns asm.Instructions
+	labelFail := opts.failLabel()
+	if opts.SkStorage != nil {
+		insns = skStorage2insns(insns, opts.SkStorage, labelFail)
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(asm.R3, asm.R1), // r3 = r1
+	)
+
+	start := len(insns)
+	if (opts.UseDirectLoad || opts.CgroupSkb) && ast.hasUserRead() {
+		return nil, fmt.Errorf("cannot load the field of __user pointer directly")
+	}
+	if opts.UseDirectLoad || opts.CgroupSkb {
+		insns, _, err = directLoadInsns(insns, ast, size, labelFail)
+	} else if opts.DirectContext {
+		insns, _, err = contextLoadInsns(insns, ast, size, labelFail)
+	} else {
+		insns, _ = offset2insns(insns, ast.offsets, asm.R3, labelFail, false)
+		userRead2insns(insns[start:], ast.userReads)
+		insns = narrowLastLoad(insns, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Annotate {
+		annotateReads(insns[start:], ast.paths)
+	}
+
+	// Store the loaded field back to the stack in the same width to keep its
+	// memory byte order.
+	keyOff := int16(stackOffsetKey)
+	if opts.LPMKey {
+		keyOff = stackOffsetLPMKey
+		insns = append(insns,
+			asm.StoreImm(asm.R10, keyOff, int64(size*8), asm.Word), // key.prefixlen = size * 8
+			asm.StoreMem(asm.R10, keyOff+4, asm.R3, sizeOf(size)),  // key.data = r3; aligned
+		)
+	} else {
+		insns = append(insns,
+			asm.StoreMem(asm.R10, keyOff, asm.R3, sizeOf(size)), // key = r3
+		)
+	}
+
+	insns = append(insns,
+		asm.LoadMapPtr(asm.R1, 0).WithReference(name),         // r1 = map
+		asm.Mov.Reg(asm.R2, asm.R10),                          // r2 = r10
+		asm.Add.Imm(asm.R2, int32(keyOff)),                    // r2 = r10 + keyOff; key
+		asm.FnMapLookupElem.Call(),                            // r0 = bpf_map_lookup_elem(r1, r2)
+		asm.JEq.Imm(asm.R0, 0, labelExitFail),                 // if r0 == 0, goto __exit
+		asm.Mov.Imm(asm.R0, 1),                                // r0 = 1
+		asm.Ja.Label(labelReturn),                             // goto __return
+		asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail), // r0 = 0; __exit
+		asm.Return().WithSymbol(labelReturn),                  // return; __return
+	)
+
+	return insns, nil
+}
+
+// sizeOf returns the asm size of the integer of size bytes.
+func sizeOf(size int) asm.Size {
+	switch size {
+	case 1:
+		return asm.Byte
+	case 2:
+		return asm.Half
+	case 4:
+		return asm.Word
+	default:
+		return asm.DWord
+	}
+}
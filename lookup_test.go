@@ -0,0 +1,154 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestFoldMapIn(t *testing.T) {
+	test.AssertEqual(t, foldMapIn("iph->saddr in @allowlist"), "iph->saddr == __bice_map_allowlist")
+	test.AssertEqual(t, foldMapIn(" skb->mark  in  @m_1 "), "skb->mark == __bice_map_m_1")
+	test.AssertEqual(t, foldMapIn("iph->saddr in 10.0.0.0/8"), "iph->saddr in 10.0.0.0/8")
+	test.AssertEqual(t, foldMapIn("skb->len == 1"), "skb->len == 1")
+}
+
+func TestMapRef(t *testing.T) {
+	expr, err := parse("iph->saddr in @allowlist")
+	test.AssertNoErr(t, err)
+
+	name, ok := mapRef(expr.Right)
+	test.AssertTrue(t, ok)
+	test.AssertEqual(t, name, "allowlist")
+
+	expr, err = parse("skb->protocol == ETH_P_IP")
+	test.AssertNoErr(t, err)
+
+	_, ok = mapRef(expr.Right)
+	test.AssertFalse(t, ok)
+}
+
+var iphSaddrReadInsns = asm.Instructions{
+	asm.Mov.Reg(asm.R3, asm.R1),
+	asm.Add.Imm(asm.R3, 12),
+	asm.Mov.Imm(asm.R2, 8),
+	asm.Mov.Reg(asm.R1, asm.R10),
+	asm.Add.Imm(asm.R1, -8),
+	asm.FnProbeReadKernel.Call(),
+	asm.LoadMem(asm.R3, asm.R10, -8, asm.Word),
+}
+
+func TestCompileMapLookup(t *testing.T) {
+	t.Run("hash", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "iph->saddr in @allowlist", Type: getIphdrBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:len(iphSaddrReadInsns)], iphSaddrReadInsns)
+		test.AssertEqualSlice(t, res.Insns[len(iphSaddrReadInsns):], asm.Instructions{
+			asm.StoreMem(asm.R10, -8, asm.R3, asm.Word),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("allowlist"),
+			asm.Mov.Reg(asm.R2, asm.R10),
+			asm.Add.Imm(asm.R2, -8),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, labelExitFail),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Ja.Label(labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("lpm", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "iph->saddr in @subnets", Type: getIphdrBtf(t), LPMKey: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(iphSaddrReadInsns):len(iphSaddrReadInsns)+5], asm.Instructions{
+			asm.StoreImm(asm.R10, -20, 32, asm.Word),
+			asm.StoreMem(asm.R10, -16, asm.R3, asm.Word),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("subnets"),
+			asm.Mov.Reg(asm.R2, asm.R10),
+			asm.Add.Imm(asm.R2, -20),
+		})
+	})
+
+	t.Run("lpm 8-byte key", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->tstamp in @m", Type: getSkbBtf(t), LPMKey: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-11:len(res.Insns)-6], asm.Instructions{
+			asm.StoreImm(asm.R10, -20, 64, asm.Word),
+			asm.StoreMem(asm.R10, -16, asm.R3, asm.DWord),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("m"),
+			asm.Mov.Reg(asm.R2, asm.R10),
+			asm.Add.Imm(asm.R2, -20),
+		})
+	})
+
+	t.Run("user pointer", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "s->uptr->val in @m", Type: getUserBtf()})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, readHelpers(res.Insns), []asm.BuiltinFunc{
+			asm.FnProbeReadKernel,
+			asm.FnProbeReadUser,
+			asm.FnMapLookupElem,
+		})
+	})
+
+	t.Run("direct context", func(t *testing.T) {
+		regs, err := testBtf.AnyTypeByName("pt_regs")
+		test.AssertNoErr(t, err)
+
+		res, err := Compile(CompileOptions{Expr: "ctx->di in @m", Type: &btf.Pointer{Target: regs}, DirectContext: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:3], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.LoadMem(asm.R3, asm.R3, 112, asm.DWord),
+			asm.StoreMem(asm.R10, -8, asm.R3, asm.DWord),
+		})
+	})
+
+	t.Run("cgroup skb", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->mark in @m", Type: getSkbCtxBtf(t), CgroupSkb: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, readHelpers(res.Insns), []asm.BuiltinFunc{
+			asm.FnMapLookupElem,
+		})
+
+		_, err = Compile(CompileOptions{Expr: "skb->data in @m", Type: getSkbCtxBtf(t), CgroupSkb: true})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("compare register", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "iph->saddr in @m", Type: getIphdrBtf(t), CompareReg: asm.R8})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invert verdict", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "iph->saddr in @denylist", Type: getIphdrBtf(t), InvertVerdict: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-4:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Ja.Label(labelReturn),
+			asm.Mov.Imm(asm.R0, 1).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "iph->saddr != __bice_map_allowlist", Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("bitfield", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "iph->ihl in @m", Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid member", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "iph->xxx in @m", Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+}
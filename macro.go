@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Macros maps the names to the sub-expressions defined once and reused, like
+// "is_ipv4" to "skb->protocol == 0x0008". A name in the expression is expanded
+// textually like the C #define before parsing, and the expansion is expanded
+// recursively.
+type Macros map[string]string
+
+// macroNameRegexp matches the valid name of the macro, i.e. a C identifier.
+var macroNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func validateMacros(macros Macros) error {
+	for name := range macros {
+		if !macroNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid macro name %q; must be identifier", name)
+		}
+	}
+
+	return nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// literalEnd returns the end of the string or char literal starting at i,
+// which skips the escaped quotes.
+func literalEnd(expr string, i int) int {
+	j := i + 1
+	for j < len(expr) && expr[j] != expr[i] {
+		if expr[j] == '\\' {
+			j++
+		}
+		j++
+	}
+	return min(j+1, len(expr))
+}
+
+// expandMacros expands the macros in the expression, whose expansions are
+// expanded recursively. The names in the string and char literals and the
+// member names after . or -> are kept as is. The comments of the expansions
+// are stripped. The cycle like a -> b -> a is rejected.
+func expandMacros(expr string, macros Macros) (string, error) {
+	if len(macros) == 0 {
+		return expr, nil
+	}
+
+	return expandMacrosWithStack(expr, macros, nil)
+}
+
+func expandMacrosWithStack(expr string, macros Macros, stack []string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case c == '"' || c == '\'':
+			j := literalEnd(expr, i)
+			sb.WriteString(expr[i:j])
+			i = j
+
+		case isIdentByte(c):
+			j := i
+			for j < len(expr) && isIdentByte(expr[j]) {
+				j++
+			}
+
+			name := expr[i:j]
+			body, ok := macros[name]
+			prev := strings.TrimRight(expr[:i], " \t")
+			if !ok || strings.HasSuffix(prev, ".") || strings.HasSuffix(prev, "->") || ('0' <= c && c <= '9') {
+				sb.WriteString(name)
+				i = j
+				continue
+			}
+
+			for k, s := range stack {
+				if s == name {
+					return "", fmt.Errorf("macro cycle %s -> %s", strings.Join(stack[k:], " -> "), name)
+				}
+			}
+
+			body, err := stripComments(body)
+			if err != nil {
+				return "", fmt.Errorf("failed to expand macro %s: %w", name, err)
+			}
+
+			expanded, err := expandMacrosWithStack(body, macros, append(stack, name))
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(expanded)
+			i = j
+
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+
+	return sb.String(), nil
+}
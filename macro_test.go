@@ -0,0 +1,101 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestExpandMacros(t *testing.T) {
+	macros := Macros{
+		"is_ipv4":  "proto == ETH_P_IP",
+		"proto":    "skb->protocol",
+		"ETH_P_IP": "0x0008",
+		"a":        "b",
+		"b":        "c + a",
+		"self":     "self",
+	}
+
+	tests := []struct {
+		expr string
+		exp  string
+		err  string
+	}{
+		{expr: "skb->len > 64", exp: "skb->len > 64"},
+		{expr: "is_ipv4", exp: "skb->protocol == 0x0008"},
+		{expr: "proto != 0", exp: "skb->protocol != 0"},
+		{expr: "skb->proto == 1", exp: "skb->proto == 1"},
+		{expr: "skb -> proto == 1", exp: "skb -> proto == 1"},
+		{expr: "dev.proto == 1", exp: "dev.proto == 1"},
+		{expr: `dev->name == "proto"`, exp: `dev->name == "proto"`},
+		{expr: "a == 1", err: "macro cycle a -> b -> a"},
+		{expr: "self == 1", err: "macro cycle self -> self"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := expandMacros(tt.expr, macros)
+			if tt.err != "" {
+				test.AssertHaveErr(t, err)
+				test.AssertEqual(t, err.Error(), tt.err)
+				return
+			}
+
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, got, tt.exp)
+		})
+	}
+}
+
+func TestCompileMacros(t *testing.T) {
+	macros := Macros{"is_ipv4": "skb->protocol == 0x0008"}
+
+	t.Run("is_ipv4", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "is_ipv4", Type: getSkbBtf(t), Macros: macros})
+		test.AssertNoErr(t, err)
+
+		exp, err := Compile(CompileOptions{Expr: "skb->protocol == 0x0008", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, exp.Insns)
+	})
+
+	t.Run("is_ipv4 && skb->len > 64", func(t *testing.T) {
+		res, err := NewFilterBuilder(getSkbBtf(t)).
+			WithMacros(macros).
+			And("is_ipv4").
+			And("skb->len > 64").
+			Build()
+		test.AssertNoErr(t, err)
+
+		exp, err := NewFilterBuilder(getSkbBtf(t)).
+			And("skb->protocol == 0x0008").
+			And("skb->len > 64").
+			Build()
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, exp.Insns)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-5:len(insns)-2], asm.Instructions{
+			asm.JGT.Imm(asm.R3, 64, labelReturn+"_1"),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.And.Reg(asm.R7, asm.R0).WithSymbol(labelReturn + "_1"),
+		})
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len > 1", Type: getSkbBtf(t), Macros: Macros{"1x": "2"}})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid macro name")
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "a", Type: getSkbBtf(t), Macros: Macros{"a": "a"}})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to expand macros")
+	})
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// isMask reports whether the left operand is a masked field like
+// (skb->mark & 0xff).
+func isMask(left *cc.Expr) bool {
+	return left != nil && left.Op == cc.Paren && left.Left != nil && left.Left.Op == cc.And
+}
+
+func validateMask(paren *cc.Expr) error {
+	and := paren.Left
+	if and.Right == nil || and.Right.Op != cc.Number {
+		return fmt.Errorf("unexpected mask %v; must be constant number", and.Right)
+	}
+
+	if _, err := parseMask(and.Right.Text); err != nil {
+		return err
+	}
+
+	return validateLeftOperand(and.Left)
+}
+
+func parseMask(text string) (uint64, error) {
+	mask, err := parseNumber(text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse mask %s: %w", text, err)
+	}
+
+	return mask, nil
+}
+
+// mask2insns ands reg with the mask, which is truncated and converted to the
+// byte order of the field like the constant. R2 is used as scratch register if
+// the mask does not fit in imm32.
+func mask2insns(insns asm.Instructions, mask uint64, tgt tgtInfo, reg asm.Register) asm.Instructions {
+	tgt.constant = mask
+	_, mask = tgt2insns(nil, tgt, reg)
+
+	// The sign extension of imm32 makes no difference to the zero-extended
+	// fields no wider than 4 bytes.
+	narrow := tgt.sizof != 0 && tgt.sizof <= 4
+	if mask <= math.MaxInt32 || (narrow && mask <= math.MaxUint32) {
+		return append(insns,
+			asm.And.Imm(reg, int32(mask)), // reg &= mask
+		)
+	}
+
+	return append(insns,
+		asm.LoadImm(asm.R2, int64(mask), asm.DWord), // r2 = mask
+		asm.And.Reg(reg, asm.R2),                    // reg &= r2
+	)
+}
+
+// maskOperandReg is the callee-saved register to keep the masked field across
+// the read of the mask field.
+const maskOperandReg = asm.R8
+
+// isFieldMask reports whether the field is masked by another field like
+// (skb->flags & skb->mask).
+func isFieldMask(left *cc.Expr) bool {
+	return isMask(left) && isMemberAccess(left.Left.Right)
+}
+
+func validateFieldMask(paren *cc.Expr) error {
+	and := paren.Left
+	for _, operand := range []*cc.Expr{and.Left, and.Right} {
+		if operand == nil || (operand.Op != cc.Arrow && operand.Op != cc.Dot && operand.Op != cc.Index) {
+			return fmt.Errorf("unexpected operand %v of bitwise and; must be struct member access", operand)
+		}
+
+		if err := validateLeftOperand(operand); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileFieldMask compiles the comparison of the field masked by another
+// field like (skb->flags & skb->mask) != 0, which reads both fields and
+// compares the bitwise and of them as unsigned.
+func compileFieldMask(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, error) {
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare field mask with register %s", opts.CompareReg)
+	}
+
+	if ri.expr != nil || ri.enum != "" {
+		constant, ok := opts.Constants[ri.enum]
+		if !ok {
+			return nil, fmt.Errorf("unexpected right operand %v of field mask; must be number", expr.Right)
+		}
+		ri.constant = constant
+	}
+
+	labelFail := opts.failLabel()
+
+	var insns asm.Instructions
+	if opts.SkStorage != nil {
+		insns = skStorage2insns(insns, opts.SkStorage, labelFail)
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(ctxSaveReg, asm.R1), // r6 = r1
+	)
+
+	and := expr.Left.Left
+	insns, fieldUsed, err := readField(insns, and.Left, labelFail, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(maskOperandReg, asm.R3), // r8 = r3
+		asm.Mov.Reg(asm.R1, ctxSaveReg),     // r1 = r6
+	)
+
+	insns, maskUsed, err := readField(insns, and.Right, labelFail, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	insns = append(insns,
+		asm.And.Reg(asm.R3, maskOperandReg), // r3 &= r8
+	)
+
+	insns, err = emitOp(insns, expr.Op, tgtInfo{constant: ri.constant}, opts.OpEmitters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert operator to instructions: %w", err)
+	}
+
+	labelUsed := opts.SkStorage != nil || fieldUsed || maskUsed
+	xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+	if labelUsed && labelFail == labelExitFail {
+		xorR0 = xorR0.WithSymbol(labelExitFail)
+	}
+	insns = append(insns,
+		xorR0,                                // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
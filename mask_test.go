@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateMask(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		err  string
+	}{
+		{"(skb->mark & MASK) == 1", "unexpected mask"},
+		{"(skb->mark & 0xfffffffffffffffff) == 1", "failed to parse mask"},
+		{"(skb->mark() & 0xff) == 1", "unexpected function call"},
+	} {
+		expr, err := parse(tt.expr)
+		test.AssertNoErr(t, err)
+
+		err = validate(expr)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), tt.err)
+	}
+
+	expr, err := parse("(skb->mark & 0xff) == 1")
+	test.AssertNoErr(t, err)
+	test.AssertNoErr(t, validate(expr))
+}
+
+func TestMask2insns(t *testing.T) {
+	tests := []struct {
+		name string
+		mask uint64
+		tgt  tgtInfo
+		exp  asm.Instructions
+	}{
+		{
+			name: "imm",
+			mask: 0xff,
+			tgt:  tgtInfo{sizof: 4},
+			exp:  asm.Instructions{asm.And.Imm(asm.R3, 0xff)},
+		},
+		{
+			name: "u32 high bit",
+			mask: 0xff000000,
+			tgt:  tgtInfo{sizof: 4},
+			exp:  asm.Instructions{asm.And.Imm(asm.R3, -0x1000000)},
+		},
+		{
+			name: "be32",
+			mask: 0xff000000,
+			tgt:  tgtInfo{sizof: 4, bigEndian: true},
+			exp:  asm.Instructions{asm.And.Imm(asm.R3, 0xff)},
+		},
+		{
+			name: "truncated",
+			mask: 0x1ff,
+			tgt:  tgtInfo{sizof: 1},
+			exp:  asm.Instructions{asm.And.Imm(asm.R3, 0xff)},
+		},
+		{
+			name: "u64",
+			mask: 0xff000000,
+			tgt:  tgtInfo{sizof: 8},
+			exp: asm.Instructions{
+				asm.LoadImm(asm.R2, 0xff000000, asm.DWord),
+				asm.And.Reg(asm.R3, asm.R2),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test.AssertEqualSlice(t, mask2insns(nil, tt.mask, tt.tgt, asm.R3), tt.exp)
+		})
+	}
+}
+
+func TestCompileMask(t *testing.T) {
+	res, err := Compile(CompileOptions{
+		Expr: "(skb->mark & 0xff) == 1",
+		Type: getSkbBtf(t),
+	})
+	test.AssertNoErr(t, err)
+
+	insns := res.Insns
+	test.AssertEqualSlice(t, insns[len(insns)-7:len(insns)-2], asm.Instructions{
+		asm.LSh.Imm(asm.R3, 32),
+		asm.RSh.Imm(asm.R3, 32),
+		asm.And.Imm(asm.R3, 0xff),
+		asm.Mov.Imm(asm.R0, 1),
+		asm.JEq.Imm(asm.R3, 1, labelReturn),
+	})
+}
+
+func TestCompileFieldMask(t *testing.T) {
+	t.Run("(skb->mark & skb->priority) != 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "(skb->mark & skb->priority) != 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:3], asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 168),
+		})
+		test.AssertEqualSlice(t, insns[10:14], asm.Instructions{
+			asm.Mov.Reg(asm.R8, asm.R3),
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 144),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-5:], asm.Instructions{
+			asm.And.Reg(asm.R3, asm.R8),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JNE.Imm(asm.R3, 0, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("invalid operand", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "(1 & skb->priority) != 0", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+
+	t.Run("big endian", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "(skb->mark & skb->protocol) != 0", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+}
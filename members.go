@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// membersDepth is the max depth of the nested struct/union values to walk.
+const membersDepth = 3
+
+// MemberInfo describes a member that can be used as the left operand.
+type MemberInfo struct {
+	// Name is the member access expression relative to the root, like
+	// "dev" or "headers.skb_iif" for the members of nested struct values.
+	Name string
+
+	// Offset is the byte offset of the member from the root.
+	Offset uint32
+
+	// Type is the type of the member.
+	Type btf.Type
+
+	// Size is the byte size of the member type, which is the size of the
+	// storage type for bitfield.
+	Size int
+
+	// Bitfield is true if the member is a bitfield.
+	Bitfield bool
+
+	// Pointer is true if the member is a pointer, which can be dereferenced
+	// by ->.
+	Pointer bool
+}
+
+// Members lists the members of the struct/union root, or of the struct/union
+// the root points to. The members of the anonymous struct/union are flattened,
+// and the members of the nested struct/union values are listed with dotted
+// names up to 3 levels. It returns nil if root is not a struct/union.
+func Members(root btf.Type) []MemberInfo {
+	typ := mybtf.UnderlyingType(root)
+	if ptr, ok := typ.(*btf.Pointer); ok {
+		typ = mybtf.UnderlyingType(ptr.Target)
+	}
+
+	return appendMembers(nil, typ, "", 0, 0)
+}
+
+func appendMembers(infos []MemberInfo, typ btf.Type, prefix string, offset uint32, depth int) []MemberInfo {
+	var members []btf.Member
+	switch v := typ.(type) {
+	case *btf.Struct:
+		members = v.Members
+	case *btf.Union:
+		members = v.Members
+	default:
+		return infos
+	}
+
+	for _, m := range members {
+		off := offset + m.Offset.Bytes()
+		mt := mybtf.UnderlyingType(m.Type)
+
+		switch mt.(type) {
+		case *btf.Struct, *btf.Union:
+			if m.Name == "" {
+				infos = appendMembers(infos, mt, prefix, off, depth)
+			} else if depth+1 < membersDepth {
+				infos = appendMembers(infos, mt, prefix+m.Name+".", off, depth+1)
+			}
+			continue
+		}
+
+		size, _ := btf.Sizeof(mt)
+		_, isPtr := mt.(*btf.Pointer)
+		infos = append(infos, MemberInfo{
+			Name:     prefix + m.Name,
+			Offset:   off,
+			Type:     m.Type,
+			Size:     size,
+			Bitfield: IsMemberBitfield(&m),
+			Pointer:  isPtr,
+		})
+	}
+
+	return infos
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestMembers(t *testing.T) {
+	t.Run("sk_buff", func(t *testing.T) {
+		members := Members(getSkbBtf(t))
+
+		infos := make(map[string]MemberInfo, len(members))
+		for _, m := range members {
+			infos[m.Name] = m
+		}
+
+		skbLen, ok := infos["len"]
+		test.AssertTrue(t, ok)
+		test.AssertEqual(t, skbLen.Offset, uint32(112))
+		test.AssertEqual(t, skbLen.Size, 4)
+		test.AssertFalse(t, skbLen.Bitfield)
+		test.AssertFalse(t, skbLen.Pointer)
+
+		protocol, ok := infos["protocol"]
+		test.AssertTrue(t, ok)
+		test.AssertEqual(t, protocol.Offset, uint32(180))
+		test.AssertEqual(t, protocol.Size, 2)
+
+		dev, ok := infos["dev"]
+		test.AssertTrue(t, ok)
+		test.AssertEqual(t, dev.Offset, uint32(16))
+		test.AssertTrue(t, dev.Pointer)
+
+		cloned, ok := infos["cloned"]
+		test.AssertTrue(t, ok)
+		test.AssertTrue(t, cloned.Bitfield)
+
+		// nested struct value
+		next, ok := infos["list.next"]
+		test.AssertTrue(t, ok)
+		test.AssertTrue(t, next.Pointer)
+	})
+
+	t.Run("struct value", func(t *testing.T) {
+		ptr := getSkbBtf(t)
+		test.AssertEqual(t, len(Members(ptr.Target)), len(Members(ptr)))
+	})
+
+	t.Run("depth", func(t *testing.T) {
+		u32 := &btf.Int{Name: "unsigned int", Size: 4}
+		inner := &btf.Struct{Name: "inner", Size: 4, Members: []btf.Member{
+			{Name: "x", Type: u32},
+		}}
+		mid := &btf.Struct{Name: "mid", Size: 4, Members: []btf.Member{
+			{Name: "in", Type: inner},
+		}}
+		deep := &btf.Struct{Name: "deep", Size: 4, Members: []btf.Member{
+			{Name: "mid", Type: mid},
+		}}
+		outer := &btf.Struct{Name: "outer", Size: 8, Members: []btf.Member{
+			{Name: "d", Type: deep},
+			{Name: "m", Type: mid, Offset: 32},
+		}}
+
+		members := Members(outer)
+		test.AssertEqual(t, len(members), 1)
+		test.AssertEqual(t, members[0].Name, "m.in.x")
+		test.AssertEqual(t, members[0].Offset, uint32(4))
+	})
+
+	t.Run("not struct", func(t *testing.T) {
+		test.AssertTrue(t, Members(getU64Btf(t)) == nil)
+	})
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// nowFunc is the pseudo-value of the current time by bpf_ktime_get_ns().
+const nowFunc = "now"
+
+func isNow(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == nowFunc
+}
+
+func validateNow(call *cc.Expr) error {
+	if len(call.List) != 0 {
+		return fmt.Errorf("%s() expects no arguments, got %d", nowFunc, len(call.List))
+	}
+
+	return nil
+}
+
+// now2insns gets the current time to r2 by bpf_ktime_get_ns(), which clobbers
+// r1-r5, so reg is saved on the stack across the call.
+func now2insns(insns asm.Instructions, reg asm.Register) asm.Instructions {
+	return append(insns,
+		asm.StoreMem(asm.R10, stackOffsetLeft, reg, asm.DWord), // *(r10 - 24) = reg
+		asm.FnKtimeGetNs.Call(),                                // r0 = bpf_ktime_get_ns()
+		asm.Mov.Reg(asm.R2, asm.R0),                            // r2 = r0
+		asm.LoadMem(reg, asm.R10, stackOffsetLeft, asm.DWord),  // reg = *(r10 - 24)
+	)
+}
@@ -0,0 +1,48 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestCompileNow(t *testing.T) {
+	t.Run("skb->tstamp < now()", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->tstamp < now()", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 32),
+		})
+		test.AssertEqualSlice(t, insns[6:], asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.StoreMem(asm.R10, stackOffsetLeft, asm.R3, asm.DWord),
+			asm.FnKtimeGetNs.Call(),
+			asm.Mov.Reg(asm.R2, asm.R0),
+			asm.LoadMem(asm.R3, asm.R10, stackOffsetLeft, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSLT.Reg(asm.R3, asm.R2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->tstamp < now(1)", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+
+	t.Run("sizeof", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "sizeof(skb->tstamp) < now()", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+}
@@ -4,14 +4,248 @@
 package bice
 
 import (
+	"fmt"
+	"math/bits"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"rsc.io/c2go/cc"
 )
 
+// percentOfRegexp matches the percent-of-max literal like 80% of 1500.
+var percentOfRegexp = regexp.MustCompile(`\b(0[xob][0-9a-fA-F]+|[0-9]+)\s*%\s*of\s+(0[xob][0-9a-fA-F]+|[0-9]+)\b`)
+
+// unitRegexp matches the decimal number with the unit suffix like 1KiB or 5s.
+var unitRegexp = regexp.MustCompile(`\b([0-9]+)(KiB|MiB|GiB|KB|MB|GB|ns|us|ms|s)\b`)
+
+// units are the scales of the unit suffixes. The byte counts are binary for
+// KiB, MiB and GiB, and decimal for KB, MB and GB. The durations are in
+// nanoseconds, which is the unit of the kernel time fields like
+// task->start_time.
+var units = map[string]uint64{
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"ns":  1,
+	"us":  1000,
+	"ms":  1000 * 1000,
+	"s":   1000 * 1000 * 1000,
+}
+
 func parse(expr string) (*cc.Expr, error) {
-	return cc.ParseExpr(expr)
+	expr, err := stripComments(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStripped(expr)
+}
+
+// parseStripped is like parse but the comments of the expression have been
+// stripped already, e.g. before the macros and the values are substituted.
+func parseStripped(expr string) (*cc.Expr, error) {
+	expr = foldHash(expr)
+
+	expr, err := foldFixed(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err = foldTranslate(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err = foldUnits(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err = foldPercentOf(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err = foldCIDR(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	expr = foldIPv4(expr)
+	expr = foldRodata(expr)
+	expr = foldMapIn(expr)
+	expr = foldKernelIntCast(expr)
+
+	ast, err := cc.ParseExpr(stripContainerOfType(expr))
+	if err != nil {
+		return nil, err
+	}
+
+	rewriteListFirst(ast)
+	return ast, nil
+}
+
+// stripComments replaces the C comments like /* jumbo */ and // jumbo with a
+// space, so that the folding of the expression text isn't confused by them.
+// The comment markers in string literals are kept.
+func stripComments(expr string) (string, error) {
+	if !strings.Contains(expr, "/*") && !strings.Contains(expr, "//") {
+		return expr, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case c == '"' || c == '\'':
+			// Copy the string or char literal with its escapes.
+			j := i + 1
+			for j < len(expr) && expr[j] != c {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return "", fmt.Errorf("unterminated literal %s", expr[i:])
+			}
+			sb.WriteString(expr[i : j+1])
+			i = j
+
+		case strings.HasPrefix(expr[i:], "//"):
+			j := strings.IndexByte(expr[i:], '\n')
+			if j < 0 {
+				return sb.String(), nil
+			}
+			sb.WriteByte(' ')
+			i += j - 1
+
+		case strings.HasPrefix(expr[i:], "/*"):
+			j := strings.Index(expr[i+2:], "*/")
+			if j < 0 {
+				return "", fmt.Errorf("unterminated comment %s", expr[i:])
+			}
+			sb.WriteByte(' ')
+			i += j + 3
+
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// foldOutsideLiterals applies the fold to the text between the string and char
+// literals, so that the literals like "5s" are kept as is.
+func foldOutsideLiterals(expr string, fold func(string) (string, error)) (string, error) {
+	if !strings.ContainsAny(expr, `"'`) {
+		return fold(expr)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(expr); {
+		j := strings.IndexAny(expr[i:], `"'`)
+		if j < 0 {
+			j = len(expr)
+		} else {
+			j += i
+		}
+
+		folded, err := fold(expr[i:j])
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(folded)
+		if j == len(expr) {
+			break
+		}
+
+		i = literalEnd(expr, j)
+		sb.WriteString(expr[j:i])
+	}
+
+	return sb.String(), nil
+}
+
+// foldPercentOf folds the percent-of-max literals like 80% of 1500 to N*M/100,
+// which cannot be parsed by cc. The string literals like "50% of 100" are
+// kept.
+func foldPercentOf(expr string) (string, error) {
+	return foldOutsideLiterals(expr, foldPercentOfSegment)
+}
+
+func foldPercentOfSegment(expr string) (string, error) {
+	matches := percentOfRegexp.FindAllStringSubmatchIndex(expr, -1)
+	if len(matches) == 0 {
+		return expr, nil
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		percent, err := parseNumber(expr[m[2]:m[3]])
+		if err != nil {
+			return "", fmt.Errorf("failed to parse percent %s: %w", expr[m[2]:m[3]], err)
+		}
+
+		total, err := parseNumber(expr[m[4]:m[5]])
+		if err != nil {
+			return "", fmt.Errorf("failed to parse number %s: %w", expr[m[4]:m[5]], err)
+		}
+
+		hi, lo := bits.Mul64(percent, total)
+		if hi >= 100 {
+			return "", fmt.Errorf("%s overflows uint64", expr[m[0]:m[1]])
+		}
+		val, _ := bits.Div64(hi, lo, 100)
+
+		sb.WriteString(expr[last:m[0]])
+		sb.WriteString(strconv.FormatUint(val, 10))
+		last = m[1]
+	}
+	sb.WriteString(expr[last:])
+
+	return sb.String(), nil
+}
+
+// foldUnits folds the numbers with the unit suffixes like 1KiB or 5s to the
+// byte counts or the nanoseconds, which cannot be parsed by cc. The string
+// literals like "5s" are kept.
+func foldUnits(expr string) (string, error) {
+	return foldOutsideLiterals(expr, foldUnitsSegment)
+}
+
+func foldUnitsSegment(expr string) (string, error) {
+	matches := unitRegexp.FindAllStringSubmatchIndex(expr, -1)
+	if len(matches) == 0 {
+		return expr, nil
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		n, err := strconv.ParseUint(expr[m[2]:m[3]], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse number %s: %w", expr[m[2]:m[3]], err)
+		}
+
+		hi, val := bits.Mul64(n, units[expr[m[4]:m[5]]])
+		if hi != 0 {
+			return "", fmt.Errorf("%s overflows uint64", expr[m[0]:m[1]])
+		}
+
+		sb.WriteString(expr[last:m[0]])
+		sb.WriteString(strconv.FormatUint(val, 10))
+		last = m[1]
+	}
+	sb.WriteString(expr[last:])
+
+	return sb.String(), nil
 }
 
 func parseNumber(text string) (uint64, error) {
@@ -26,3 +260,17 @@ func parseNumber(text string) (uint64, error) {
 	}
 	return strconv.ParseUint(text, 10, 64)
 }
+
+// foldNot folds the zero-test like !skb->sk to skb->sk == 0, which matches if
+// the value of the member is zero.
+func foldNot(expr *cc.Expr) *cc.Expr {
+	if expr.Op != cc.Not {
+		return expr
+	}
+
+	return &cc.Expr{
+		Op:    cc.EqEq,
+		Left:  expr.Left,
+		Right: &cc.Expr{Op: cc.Number, Text: "0"},
+	}
+}
@@ -6,6 +6,9 @@ package bice
 import (
 	"testing"
 
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+
 	"github.com/leonhwangprojects/bice/internal/test"
 )
 
@@ -45,3 +48,255 @@ func TestParseNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestStripComments(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		exp  string
+	}{
+		{name: "none", expr: "skb->len > 1024", exp: "skb->len > 1024"},
+		{name: "block", expr: "skb->len > 1024 /* jumbo-ish */", exp: "skb->len > 1024  "},
+		{name: "line", expr: "skb->len > 1024 // jumbo-ish", exp: "skb->len > 1024 "},
+		{name: "line before newline", expr: "skb->len // length\n> 1024", exp: "skb->len  \n> 1024"},
+		{name: "inline block", expr: "skb->/* the */len > 1", exp: "skb-> len > 1"},
+		{name: "in string", expr: `dev->name == "/* a */ // b"`, exp: `dev->name == "/* a */ // b"`},
+		{name: "escaped quote", expr: `dev->name == "\"//" // c`, exp: `dev->name == "\"//" `},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripComments(tt.expr)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, got, tt.exp)
+		})
+	}
+
+	t.Run("unterminated comment", func(t *testing.T) {
+		_, err := stripComments("skb->len > 1024 /* jumbo")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unterminated comment")
+	})
+
+	t.Run("unterminated string", func(t *testing.T) {
+		_, err := stripComments(`dev->name == "lo // x`)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unterminated literal")
+	})
+
+	t.Run("compile with comments", func(t *testing.T) {
+		for _, expr := range []string{
+			"skb->len > 1024 /* jumbo-ish */",
+			"skb->len > 1024 // jumbo-ish",
+		} {
+			res, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+			test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+		}
+	})
+
+	t.Run("value in comment", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:   "skb->len > $max /* was $old */",
+			Type:   getSkbBtf(t),
+			Values: map[string]uint64{"max": 1024},
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("macro in comment", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:   "skb->len > 1024 // not loop",
+			Type:   getSkbBtf(t),
+			Macros: Macros{"loop": "loop"},
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("comment in macro", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:   "jumbo",
+			Type:   getSkbBtf(t),
+			Macros: Macros{"jumbo": "skb->len > 1024 /* not $max */"},
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("CIDR with comment", func(t *testing.T) {
+		expr, err := parse("iph->saddr in 10.0.0.0/8 // private")
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, expr.String(), "((iph->saddr & 0xff000000)) == 0xa000000")
+	})
+}
+
+func TestFoldPercentOf(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		exp  string
+	}{
+		{name: "none", expr: "skb->len > 1500", exp: "skb->len > 1500"},
+		{name: "percent of", expr: "skb->len > 80% of 1500", exp: "skb->len > 1200"},
+		{name: "spaces", expr: "skb->len > 80 %  of 1500", exp: "skb->len > 1200"},
+		{name: "round down", expr: "skb->len < 33% of 100 ", exp: "skb->len < 33 "},
+		{name: "truncate", expr: "skb->len < 50% of 3", exp: "skb->len < 1"},
+		{name: "hex", expr: "skb->len <= 50% of 0x100", exp: "skb->len <= 128"},
+		{name: "paren", expr: "skb->len >= (150% of 1000)", exp: "skb->len >= (1500)"},
+		{name: "modulo", expr: "skb->len % 4 == 0", exp: "skb->len % 4 == 0"},
+		{name: "string literal", expr: `dev->name == "50% of 100"`, exp: `dev->name == "50% of 100"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := foldPercentOf(tt.expr)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, got, tt.exp)
+		})
+	}
+
+	t.Run("overflow", func(t *testing.T) {
+		_, err := foldPercentOf("skb->len > 200% of 0xffffffffffffffff")
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid number", func(t *testing.T) {
+		_, err := foldPercentOf("skb->len > 80% of 0b12")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to parse number 0b12")
+	})
+
+	t.Run(`skb->dev->name == "50% of 100"`, func(t *testing.T) {
+		assertCompiledLiteral(t, `skb->dev->name == "50% of 100"`, "50% of 100")
+	})
+}
+
+// assertCompiledLiteral asserts the string literal is compared as is by the
+// compiled expression of skb->dev->name.
+func assertCompiledLiteral(t *testing.T, expr, literal string) {
+	t.Helper()
+
+	res, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+	test.AssertNoErr(t, err)
+
+	data := append([]byte(literal), 0)
+	off := -8 - int16((len(data)+7)/8*8)
+	cmp := memcmp2insns(nil, data, nativeEndian, asm.R10, off, labelExitFail)
+	n := len(res.Insns)
+	test.AssertEqualSlice(t, res.Insns[n-4-len(cmp):n-4], cmp)
+}
+
+func TestFoldUnits(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		exp  string
+	}{
+		{name: "none", expr: "skb->len > 1500", exp: "skb->len > 1500"},
+		{name: "KiB", expr: "skb->len > 1KiB", exp: "skb->len > 1024"},
+		{name: "KB", expr: "skb->len > 1KB", exp: "skb->len > 1000"},
+		{name: "MiB", expr: "skb->len > 2MiB", exp: "skb->len > 2097152"},
+		{name: "MB", expr: "skb->len > 2MB", exp: "skb->len > 2000000"},
+		{name: "GiB", expr: "skb->len < 4GiB", exp: "skb->len < 4294967296"},
+		{name: "GB", expr: "skb->len < 4GB", exp: "skb->len < 4000000000"},
+		{name: "percent of", expr: "skb->len > 50% of 1KiB", exp: "skb->len > 50% of 1024"},
+		{name: "s", expr: "skb->tstamp > 5s", exp: "skb->tstamp > 5000000000"},
+		{name: "ms", expr: "skb->tstamp > 100ms", exp: "skb->tstamp > 100000000"},
+		{name: "us", expr: "skb->tstamp > 10us", exp: "skb->tstamp > 10000"},
+		{name: "ns", expr: "skb->tstamp > 10ns", exp: "skb->tstamp > 10"},
+		{name: "hex", expr: "skb->len > 0x1B", exp: "skb->len > 0x1B"},
+		{name: "name", expr: "skb->len > KB", exp: "skb->len > KB"},
+		{name: "member", expr: "s->ms > 1", exp: "s->ms > 1"},
+		{name: "string literal", expr: `dev->name == "5s"`, exp: `dev->name == "5s"`},
+		{name: "escaped quote", expr: `dev->name == "\"5s"`, exp: `dev->name == "\"5s"`},
+		{name: "after literal", expr: `f("1s", '1') > 1s`, exp: `f("1s", '1') > 1000000000`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := foldUnits(tt.expr)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, got, tt.exp)
+		})
+	}
+
+	t.Run("overflow", func(t *testing.T) {
+		_, err := foldUnits("skb->len > 18446744073709551615GB")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "18446744073709551615GB overflows uint64")
+	})
+
+	for _, tt := range []struct {
+		expr string
+		cmp  asm.Instructions
+	}{
+		{"skb->len > 1KiB", asm.Instructions{asm.JGT.Imm(asm.R3, 1024, labelReturn)}},
+		{"skb->len > 1KB", asm.Instructions{asm.JGT.Imm(asm.R3, 1000, labelReturn)}},
+		{"skb->len < 2GiB", asm.Instructions{
+			asm.LoadImm(asm.R2, 2<<30, asm.DWord),
+			asm.JLT.Reg(asm.R3, asm.R2, labelReturn),
+		}},
+		{"skb->tstamp > 5s", asm.Instructions{
+			asm.LoadImm(asm.R2, 5_000_000_000, asm.DWord),
+			asm.JSGT.Reg(asm.R3, asm.R2, labelReturn),
+		}},
+		{"skb->tstamp > 100ms", asm.Instructions{asm.JSGT.Imm(asm.R3, 100_000_000, labelReturn)}},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: tt.expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+
+			n := len(res.Insns)
+			test.AssertEqualSlice(t, res.Insns[n-2-len(tt.cmp):n-2], tt.cmp)
+		})
+	}
+
+	t.Run(`skb->dev->name == "5s"`, func(t *testing.T) {
+		assertCompiledLiteral(t, `skb->dev->name == "5s"`, "5s")
+	})
+
+	t.Run("skb->len < 4GiB", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len < 4GiB", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(skb->len < 4GiB): constant 0x100000000 is wider than the 32-bit field")
+	})
+}
+
+func TestFoldNot(t *testing.T) {
+	t.Run("!skb->sk", func(t *testing.T) {
+		expr, err := parse("!skb->sk")
+		test.AssertNoErr(t, err)
+
+		expr = foldNot(expr)
+		test.AssertEqual(t, expr.Op, cc.EqEq)
+		test.AssertEqual(t, expr.Left.String(), "skb->sk")
+		test.AssertEqual(t, expr.Right.Text, "0")
+	})
+
+	t.Run("not a zero-test", func(t *testing.T) {
+		expr, err := parse("skb->len > 0")
+		test.AssertNoErr(t, err)
+		test.AssertTrue(t, foldNot(expr) == expr)
+	})
+
+	t.Run("!skb->sk matches null", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "!skb->sk", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-4:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("!(skb->len > 0)", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "!(skb->len > 0)", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
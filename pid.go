@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// pidFunc is the pseudo-value of the current process id, i.e. the tgid in the
+// upper 32 bits of bpf_get_current_pid_tgid() like getpid() in user space.
+const pidFunc = "pid"
+
+func isPid(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == pidFunc
+}
+
+func validatePid(call *cc.Expr) error {
+	if len(call.List) != 0 {
+		return fmt.Errorf("%s() expects no arguments, got %d", pidFunc, len(call.List))
+	}
+
+	return nil
+}
+
+// compilePid compiles the comparison of the current process id like
+// pid() == 1234, which reads no field of the root. It's cheap to scope the
+// following conditions of the FilterBuilder like pid() == 1234 && skb->len > 1024.
+func compilePid(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, error) {
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare %s() with register %s", pidFunc, opts.CompareReg)
+	}
+
+	if ri.expr != nil || ri.enum != "" {
+		constant, ok := opts.Constants[ri.enum]
+		if !ok {
+			return nil, fmt.Errorf("unexpected right operand %v of %s(); must be number", expr.Right, pidFunc)
+		}
+		ri.constant = constant
+	}
+	if ri.constant > math.MaxInt32 {
+		return nil, fmt.Errorf("pid %d of %s() is out of range", ri.constant, pidFunc)
+	}
+
+	insns := asm.Instructions{
+		asm.FnGetCurrentPidTgid.Call(), // r0 = bpf_get_current_pid_tgid()
+		asm.Mov.Reg(asm.R3, asm.R0),    // r3 = r0
+		asm.RSh.Imm(asm.R3, 32),        // r3 >>= 32
+	}
+
+	insns, err := emitOp(insns, expr.Op, tgtInfo{constant: ri.constant}, opts.OpEmitters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert operator to instructions: %w", err)
+	}
+
+	insns = append(insns,
+		asm.Xor.Reg(asm.R0, asm.R0),          // r0 = 0
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return insns, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidatePid(t *testing.T) {
+	expr, err := parse("pid(1) == 1")
+	test.AssertNoErr(t, err)
+
+	err = validate(expr)
+	test.AssertHaveErr(t, err)
+	test.AssertStrPrefix(t, err.Error(), "pid() expects no arguments")
+
+	expr, err = parse("pid() == 1")
+	test.AssertNoErr(t, err)
+	test.AssertNoErr(t, validate(expr))
+}
+
+func TestCompilePid(t *testing.T) {
+	t.Run("pid() == 1234", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "pid() == 1234", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.FnGetCurrentPidTgid.Call(),
+			asm.Mov.Reg(asm.R3, asm.R0),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 1234, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("pid() == 1234 && skb->len > 1024", func(t *testing.T) {
+		res, err := NewFilterBuilder(getSkbBtf(t)).
+			And("pid() == 1234").
+			And("skb->len > 1024").
+			Build()
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[:9], asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+			asm.FnGetCurrentPidTgid.Call(),
+			asm.Mov.Reg(asm.R3, asm.R0),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 1234, labelReturn+"_0"),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Mov.Reg(asm.R7, asm.R0).WithSymbol(labelReturn + "_0"),
+			asm.JEq.Imm(asm.R7, 0, labelCond+"_2").WithSymbol(labelCond + "_1"),
+		})
+		test.AssertEqualSlice(t, insns[len(insns)-5:], asm.Instructions{
+			asm.JGT.Imm(asm.R3, 1024, labelReturn+"_1"),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.And.Reg(asm.R7, asm.R0).WithSymbol(labelReturn + "_1"),
+			asm.Mov.Reg(asm.R0, asm.R7).WithSymbol(labelCond + "_2"),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, tt := range []struct {
+			expr string
+			err  string
+		}{
+			{"pid() == 0x100000000", "failed to compile expression"},
+			{"pid() < now()", "failed to compile expression"},
+		} {
+			_, err := Compile(CompileOptions{Expr: tt.expr, Type: getSkbBtf(t)})
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		}
+	})
+}
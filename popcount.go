@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+const popcountFunc = "popcount"
+
+func isPopcount(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == popcountFunc
+}
+
+func validatePopcount(call *cc.Expr) error {
+	if len(call.List) != 1 {
+		return fmt.Errorf("%s() expects 1 argument, got %d", popcountFunc, len(call.List))
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+// popcount2insns counts the set bits of reg in place with the SWAR algorithm,
+// as there's no popcount instruction in eBPF. R2 and R4 are used as scratch
+// registers.
+func popcount2insns(insns asm.Instructions, reg asm.Register) asm.Instructions {
+	return append(insns,
+		// reg -= (reg >> 1) & 0x5555555555555555
+		asm.LoadImm(asm.R4, 0x5555555555555555, asm.DWord),
+		asm.Mov.Reg(asm.R2, reg),
+		asm.RSh.Imm(asm.R2, 1),
+		asm.And.Reg(asm.R2, asm.R4),
+		asm.Sub.Reg(reg, asm.R2),
+
+		// reg = (reg & 0x3333333333333333) + ((reg >> 2) & 0x3333333333333333)
+		asm.LoadImm(asm.R4, 0x3333333333333333, asm.DWord),
+		asm.Mov.Reg(asm.R2, reg),
+		asm.And.Reg(asm.R2, asm.R4),
+		asm.RSh.Imm(reg, 2),
+		asm.And.Reg(reg, asm.R4),
+		asm.Add.Reg(reg, asm.R2),
+
+		// reg = (reg + (reg >> 4)) & 0x0f0f0f0f0f0f0f0f
+		asm.Mov.Reg(asm.R2, reg),
+		asm.RSh.Imm(asm.R2, 4),
+		asm.Add.Reg(reg, asm.R2),
+		asm.LoadImm(asm.R4, 0x0f0f0f0f0f0f0f0f, asm.DWord),
+		asm.And.Reg(reg, asm.R4),
+
+		// reg = (reg * 0x0101010101010101) >> 56
+		asm.LoadImm(asm.R4, 0x0101010101010101, asm.DWord),
+		asm.Mul.Reg(reg, asm.R4),
+		asm.RSh.Imm(reg, 56),
+	)
+}
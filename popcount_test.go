@@ -0,0 +1,95 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"math/bits"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+// runALU64 emulates the 64-bit ALU instructions emitted by popcount2insns.
+func runALU64(t *testing.T, insns asm.Instructions, regs map[asm.Register]uint64) {
+	for _, ins := range insns {
+		src := uint64(ins.Constant)
+		if ins.OpCode.Source() == asm.RegSource {
+			src = regs[ins.Src]
+		}
+
+		if ins.IsLoadFromMap() || ins.OpCode.IsDWordLoad() {
+			regs[ins.Dst] = uint64(ins.Constant)
+			continue
+		}
+
+		switch ins.OpCode.ALUOp() {
+		case asm.Mov:
+			regs[ins.Dst] = src
+		case asm.Add:
+			regs[ins.Dst] += src
+		case asm.Sub:
+			regs[ins.Dst] -= src
+		case asm.Mul:
+			regs[ins.Dst] *= src
+		case asm.And:
+			regs[ins.Dst] &= src
+		case asm.LSh:
+			regs[ins.Dst] <<= src
+		case asm.RSh:
+			regs[ins.Dst] >>= src
+		case asm.ArSh:
+			regs[ins.Dst] = uint64(int64(regs[ins.Dst]) >> src)
+		case asm.Xor:
+			regs[ins.Dst] ^= src
+		case asm.Or:
+			regs[ins.Dst] |= src
+		default:
+			t.Fatalf("unexpected instruction %v", ins)
+		}
+	}
+}
+
+func TestPopcount2insns(t *testing.T) {
+	for _, v := range []uint64{0, 1, 0xFF, 0x8000000000000001, 0x5555555555555555, ^uint64(0)} {
+		regs := map[asm.Register]uint64{asm.R3: v}
+		runALU64(t, popcount2insns(nil, asm.R3), regs)
+		test.AssertEqual(t, regs[asm.R3], uint64(bits.OnesCount64(v)))
+	}
+}
+
+func TestCompilePopcount(t *testing.T) {
+	t.Run("popcount(skb->mark) > 2", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "popcount(skb->mark) > 2", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[7:9], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+		})
+		test.AssertEqualSlice(t, insns[9:len(insns)-4], popcount2insns(nil, asm.R3))
+		test.AssertEqualSlice(t, insns[len(insns)-4:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("big endian constant is not swapped", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "popcount(skb->protocol) == 3", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JEq.Imm(asm.R3, 3, labelReturn),
+		})
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "popcount(skb->mark, skb->len) > 2", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
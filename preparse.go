@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// PreParsed is the member access expression parsed and validated without btf,
+// which is bound to a btf type later by Bind.
+type PreParsed struct {
+	expr string
+	ast  *cc.Expr
+}
+
+// PreParse parses and validates the syntax of the member access expression
+// like skb->dev->ifindex once, whose offsets are resolved against the btf type
+// by Bind.
+func PreParse(expr string) (*PreParsed, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	ast, err := parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile expression %s: %w", expr, err)
+	}
+
+	err = validateLeftOperand(ast)
+	if err != nil {
+		return nil, fmt.Errorf("expression is not struct/union member access: %w", err)
+	}
+
+	return &PreParsed{expr: expr, ast: ast}, nil
+}
+
+// Expr returns the pre-parsed expression.
+func (p *PreParsed) Expr() string {
+	return p.expr
+}
+
+// Bind resolves the pre-parsed expression against the root type, and generates
+// the instructions like Access, which read the member from R1 to R3 and jump
+// to __exit_bice_filter on failure. It can be called for different types.
+func (p *PreParsed) Bind(rootType btf.Type) (AccessResult, error) {
+	if rootType == nil {
+		return AccessResult{}, fmt.Errorf("invalid type")
+	}
+
+	return access(p.ast, AccessOptions{
+		Expr:      p.expr,
+		Type:      rootType,
+		Src:       asm.R1,
+		Dst:       asm.R3,
+		LabelExit: labelExitFail,
+	})
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestPreParse(t *testing.T) {
+	t.Run("empty expression", func(t *testing.T) {
+		_, err := PreParse("")
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "empty expression")
+	})
+
+	t.Run("failed to parse", func(t *testing.T) {
+		_, err := PreParse("a)(test)")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("invalid left operand", func(t *testing.T) {
+		_, err := PreParse("a+b")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "expression is not struct/union member access")
+	})
+
+	t.Run("bind two types", func(t *testing.T) {
+		p, err := PreParse("skb->len")
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, p.Expr(), "skb->len")
+
+		res, err := p.Bind(getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 112),
+		})
+
+		// A compatible sk_buff of another kernel, whose len is at offset 8.
+		u32 := &btf.Int{Name: "u32", Size: 4}
+		skb := &btf.Pointer{Target: &btf.Struct{
+			Name: "sk_buff",
+			Size: 16,
+			Members: []btf.Member{
+				{Name: "next", Type: &btf.Pointer{Target: &btf.Void{}}},
+				{Name: "len", Type: u32, Offset: 64},
+			},
+		}}
+		res, err = p.Bind(skb)
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 8),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.RFP, -8, asm.DWord),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+		})
+		test.AssertEqual(t, res.LastField, btf.Type(u32))
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		p, err := PreParse("skb->len")
+		test.AssertNoErr(t, err)
+
+		_, err = p.Bind(nil)
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "invalid type")
+	})
+
+	t.Run("missing member", func(t *testing.T) {
+		p, err := PreParse("skb->xxx")
+		test.AssertNoErr(t, err)
+
+		_, err = p.Bind(getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to convert expression to offsets")
+	})
+}
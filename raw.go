@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+
+	"rsc.io/c2go/cc"
+)
+
+// rawAccess resolves the raw offset access like *(unsigned int *)(skb - 8),
+// which reads the integer at the signed offset relative to the root pointer,
+// e.g. the headroom before a pointer.
+func rawAccess(expr *cc.Expr) (astInfo, error) {
+	var ast astInfo
+
+	if expr == nil || expr.Op != cc.Indir || expr.Left == nil || expr.Left.Op != cc.Cast {
+		return ast, fmt.Errorf("unexpected raw access: %v; must be like *(unsigned int *)(ptr + offset)", expr)
+	}
+
+	cast := expr.Left
+	if cast.Type == nil || cast.Type.Kind != cc.Ptr {
+		return ast, fmt.Errorf("unexpected cast type %s of raw access; must be pointer to integer", cast.Type)
+	}
+
+	typ, err := castInt(cast.Type.Base)
+	if err != nil {
+		return ast, fmt.Errorf("failed to get type of raw access: %w", err)
+	}
+
+	ptr := cast.Left
+	for ptr != nil && ptr.Op == cc.Paren {
+		ptr = ptr.Left
+	}
+	if ptr == nil {
+		return ast, fmt.Errorf("pointer of raw access is missing")
+	}
+
+	var offset int64
+	switch ptr.Op {
+	case cc.Name:
+		// *(unsigned int *)skb
+
+	case cc.Add, cc.Sub:
+		if ptr.Left == nil || ptr.Left.Op != cc.Name || ptr.Right == nil || ptr.Right.Op != cc.Number {
+			return ast, fmt.Errorf("unexpected raw access: %v; must be like *(unsigned int *)(ptr + offset)", expr)
+		}
+
+		n, err := parseNumber(ptr.Right.Text)
+		if err != nil {
+			return ast, fmt.Errorf("failed to parse offset %s: %w", ptr.Right.Text, err)
+		}
+		if n > math.MaxInt32 {
+			return ast, fmt.Errorf("offset %s is out of range of int32", ptr.Right.Text)
+		}
+
+		offset = int64(n)
+		if ptr.Op == cc.Sub {
+			offset = -offset
+		}
+
+	default:
+		return ast, fmt.Errorf("unexpected raw access: %v; must be like *(unsigned int *)(ptr + offset)", expr)
+	}
+
+	// The negative offset is kept in two's complement, which is restored by
+	// int32() when generating the instructions.
+	ast.offsets = []uint32{uint32(int32(offset))}
+	ast.paths = []string{expr.String()}
+	ast.lastField = typ
+	return ast, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestRawAccess(t *testing.T) {
+	tests := []struct {
+		expr    string
+		offsets []uint32
+		size    uint32
+	}{
+		{expr: "*(unsigned short *)skb", offsets: []uint32{0}, size: 2},
+		{expr: "*(unsigned int *)(skb + 0x10)", offsets: []uint32{0x10}, size: 4},
+		{expr: "*(unsigned char *)(skb - 8)", offsets: []uint32{0xFFFFFFF8}, size: 1},
+		{expr: "*(long *)((skb - 16))", offsets: []uint32{0xFFFFFFF0}, size: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			ast, err := rawAccess(expr)
+			test.AssertNoErr(t, err)
+			test.AssertEqualSlice(t, ast.offsets, tt.offsets)
+			test.AssertEqual(t, ast.lastField.(*btf.Int).Size, tt.size)
+		})
+	}
+
+	invalids := []struct {
+		expr string
+		err  string
+	}{
+		{expr: "skb->len", err: "unexpected raw access"},
+		{expr: "*(unsigned int)skb", err: "unexpected cast type"},
+		{expr: "*(struct sk_buff *)skb", err: "failed to get type of raw access"},
+		{expr: "*(unsigned int *)(skb * 8)", err: "unexpected raw access"},
+		{expr: "*(unsigned int *)(skb + len)", err: "unexpected raw access"},
+		{expr: "*(unsigned int *)(skb + 0x100000000)", err: "offset 0x100000000 is out of range of int32"},
+	}
+
+	for _, tt := range invalids {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			_, err = rawAccess(expr)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+// validateCompareReg checks the register holding the value to compare with,
+// which must be a callee-saved register because r0-r5 are clobbered by the
+// helper calls and r10 is the read-only frame pointer.
+func validateCompareReg(reg asm.Register) error {
+	if reg < asm.R6 || reg > asm.R9 {
+		return fmt.Errorf("invalid compare register %s; must be one of r6-r9", reg)
+	}
+
+	return nil
+}
+
+// clobbersReg reports whether the instructions write reg, e.g. r6 keeping the
+// root pointer of the tuple, the difference, the field mask and the set, r7
+// accumulating the verdicts of the set, and r8 keeping the minuend of the
+// difference or the operand of the field mask.
+func clobbersReg(insns asm.Instructions, reg asm.Register) bool {
+	for _, ins := range insns {
+		class := ins.OpCode.Class()
+		if (class.IsALU() || class.IsLoad()) && ins.Dst == reg {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestValidateCompareReg(t *testing.T) {
+	for _, reg := range []asm.Register{asm.R6, asm.R7, asm.R8, asm.R9} {
+		test.AssertNoErr(t, validateCompareReg(reg))
+	}
+
+	for _, reg := range []asm.Register{asm.R1, asm.R5, asm.R10} {
+		err := validateCompareReg(reg)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid compare register")
+	}
+}
+
+func TestCompareReg(t *testing.T) {
+	t.Run("skb->len > threshold", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:       "skb->len > threshold",
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R6,
+		})
+		test.AssertNoErr(t, err)
+
+		exp := cloneSkbLen1024InsnsWithoutExitLabel()
+		exp[len(exp)-3] = asm.JGT.Reg(asm.R3, asm.R6, labelReturn)
+		test.AssertEqualSlice(t, res.Insns, exp)
+	})
+
+	t.Run("no folding of placeholder", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:       "skb->len >= 0",
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R7,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JGE.Reg(asm.R3, asm.R7, labelReturn),
+		})
+	})
+
+	t.Run("big endian", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:       "skb->protocol == proto",
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R8,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-5:len(res.Insns)-2], asm.Instructions{
+			asm.HostTo(asm.BE, asm.R3, asm.Half),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Reg(asm.R3, asm.R8, labelReturn),
+		})
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:       "skb->dev->ifindex < idx",
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R9,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-3:len(res.Insns)-2], asm.Instructions{
+			asm.JSLT.Reg(asm.R3, asm.R9, labelReturn),
+		})
+	})
+
+	t.Run("invalid register", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:       "skb->len > threshold",
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R2,
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid compare register r2")
+	})
+
+	t.Run("string", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:       `skb->dev->name == "lo"`,
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R6,
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("sizeof", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:       "sizeof(skb->len) == size",
+			Type:       getSkbBtf(t),
+			CompareReg: asm.R6,
+		})
+		test.AssertHaveErr(t, err)
+	})
+}
+
+func TestClobbersReg(t *testing.T) {
+	insns := asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		asm.LoadMem(asm.R8, asm.R10, -8, asm.DWord),
+		asm.StoreMem(asm.R7, 0, asm.R3, asm.DWord),
+		asm.JEq.Reg(asm.R3, asm.R9, labelReturn),
+	}
+
+	test.AssertTrue(t, clobbersReg(insns, asm.R6))
+	test.AssertTrue(t, clobbersReg(insns, asm.R8))
+	test.AssertFalse(t, clobbersReg(insns, asm.R7))
+	test.AssertFalse(t, clobbersReg(insns, asm.R9))
+}
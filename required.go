@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// RequiredBTF returns the minimal btf spec of the types referenced by the
+// member access expression like skb->dev->ifindex from the root type, for the
+// CO-RE relocation of the filter without the full vmlinux btf. The structs
+// and unions keep only the accessed members at their original offsets, and
+// the ones not accessed are kept empty with their names and sizes.
+func RequiredBTF(expr string, root btf.Type) (*btf.Spec, error) {
+	if expr == "" || root == nil {
+		return nil, fmt.Errorf("invalid options")
+	}
+
+	ast, err := parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expression %s: %w", expr, err)
+	}
+
+	err = validateLeftOperand(ast)
+	if err != nil {
+		return nil, fmt.Errorf("expression is not struct/union member access: %w", err)
+	}
+
+	info, err := expr2offset(ast, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert expression to offsets: %w", err)
+	}
+
+	p := btfPruner{
+		members:  make(map[btf.Type][]btf.Member),
+		copies:   make(map[btf.Type]btf.Type),
+		pointers: make(map[btf.Type]*btf.Pointer),
+	}
+	for _, acc := range info.accessed {
+		p.addMember(acc.parent, acc.member)
+	}
+
+	b, err := btf.NewBuilder([]btf.Type{p.prune(root)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create btf builder: %w", err)
+	}
+
+	buf, err := b.Marshal(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal btf: %w", err)
+	}
+
+	spec, err := btf.LoadSpecFromReader(bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load btf spec: %w", err)
+	}
+
+	return spec, nil
+}
+
+// btfPruner copies the types with only the accessed members of the structs
+// and unions. The pointers to the same type are copied once, e.g. the root
+// pointer and the member pointer to sk_buff of skb->next.
+type btfPruner struct {
+	members  map[btf.Type][]btf.Member
+	copies   map[btf.Type]btf.Type
+	pointers map[btf.Type]*btf.Pointer // by the original target
+}
+
+func (p *btfPruner) addMember(parent btf.Type, member btf.Member) {
+	for _, m := range p.members[parent] {
+		if m.Name == member.Name {
+			return
+		}
+	}
+	p.members[parent] = append(p.members[parent], member)
+}
+
+func (p *btfPruner) prune(typ btf.Type) btf.Type {
+	if cpy, ok := p.copies[typ]; ok {
+		return cpy
+	}
+
+	// The copy is registered before pruning its referenced types, as the
+	// types can be cyclic like sk_buff.next.
+	switch v := typ.(type) {
+	case *btf.Struct:
+		cpy := &btf.Struct{Name: v.Name, Size: v.Size}
+		p.copies[typ] = cpy
+		cpy.Members = p.pruneMembers(typ)
+		return cpy
+
+	case *btf.Union:
+		cpy := &btf.Union{Name: v.Name, Size: v.Size}
+		p.copies[typ] = cpy
+		cpy.Members = p.pruneMembers(typ)
+		return cpy
+
+	case *btf.Pointer:
+		if cpy, ok := p.pointers[v.Target]; ok {
+			p.copies[typ] = cpy
+			return cpy
+		}
+
+		cpy := &btf.Pointer{}
+		p.copies[typ] = cpy
+		p.pointers[v.Target] = cpy
+		cpy.Target = p.prune(v.Target)
+		return cpy
+
+	case *btf.Array:
+		cpy := &btf.Array{Nelems: v.Nelems}
+		p.copies[typ] = cpy
+		cpy.Index, cpy.Type = p.prune(v.Index), p.prune(v.Type)
+		return cpy
+
+	case *btf.Typedef:
+		cpy := &btf.Typedef{Name: v.Name}
+		p.copies[typ] = cpy
+		cpy.Type = p.prune(v.Type)
+		return cpy
+
+	case *btf.Const:
+		cpy := &btf.Const{}
+		p.copies[typ] = cpy
+		cpy.Type = p.prune(v.Type)
+		return cpy
+
+	case *btf.Volatile:
+		cpy := &btf.Volatile{}
+		p.copies[typ] = cpy
+		cpy.Type = p.prune(v.Type)
+		return cpy
+
+	case *btf.Restrict:
+		cpy := &btf.Restrict{}
+		p.copies[typ] = cpy
+		cpy.Type = p.prune(v.Type)
+		return cpy
+
+	case *btf.TypeTag:
+		cpy := &btf.TypeTag{Value: v.Value}
+		p.copies[typ] = cpy
+		cpy.Type = p.prune(v.Type)
+		return cpy
+
+	case *btf.Func, *btf.FuncProto:
+		// The function pointers are kept as void pointers.
+		return &btf.Void{}
+
+	default:
+		// The leaf types like int and enum.
+		return typ
+	}
+}
+
+func (p *btfPruner) pruneMembers(parent btf.Type) []btf.Member {
+	var members []btf.Member
+	for _, m := range p.members[parent] {
+		m.Type = p.prune(m.Type)
+		members = append(members, m)
+	}
+	return members
+}
@@ -0,0 +1,73 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func specTypes(spec *btf.Spec) []string {
+	var types []string
+	for it := spec.Iterate(); it.Next(); {
+		typ := it.Type
+		switch v := typ.(type) {
+		case *btf.Struct:
+			s := fmt.Sprintf("struct %s(%d)", v.Name, v.Size)
+			for _, m := range v.Members {
+				s += fmt.Sprintf(" %s@%d", m.Name, m.Offset.Bytes())
+			}
+			types = append(types, s)
+		case *btf.Pointer:
+			types = append(types, "pointer "+v.Target.TypeName())
+		default:
+			types = append(types, fmt.Sprintf("%T %s", typ, typ.TypeName()))
+		}
+	}
+	slices.Sort(types)
+	return types
+}
+
+func TestRequiredBTF(t *testing.T) {
+	t.Run("skb->dev->ifindex", func(t *testing.T) {
+		spec, err := RequiredBTF("skb->dev->ifindex", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, specTypes(spec), []string{
+			"*btf.Int int",
+			"*btf.Void ",
+			"pointer net_device",
+			"pointer sk_buff",
+			"struct net_device(2512) ifindex@224",
+			"struct sk_buff(232) dev@16",
+		})
+	})
+
+	t.Run("skb->next->len", func(t *testing.T) {
+		spec, err := RequiredBTF("skb->next->len", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, specTypes(spec), []string{
+			"*btf.Int unsigned int",
+			"*btf.Void ",
+			"pointer sk_buff",
+			"struct sk_buff(232) next@0 len@112",
+		})
+	})
+
+	t.Run("invalid expression", func(t *testing.T) {
+		_, err := RequiredBTF("skb->len + 1", getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "expression is not struct/union member access")
+	})
+
+	t.Run("missing member", func(t *testing.T) {
+		_, err := RequiredBTF("skb->nonexist", getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to convert expression to offsets")
+	})
+}
@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// TypeResolver resolves the type by name when it's not in the spec, like a
+// module type or a user-defined type, and reports false if not found. The name
+// of an enum value like IPPROTO_TCP resolves to the enum type declaring it.
+type TypeResolver func(name string) (btf.Type, bool)
+
+// typeLookupFunc looks up the type by name.
+type typeLookupFunc func(name string) (btf.Type, error)
+
+// typeLookup returns lookupType, or nil if there's neither spec nor resolver.
+func (opts *CompileOptions) typeLookup() typeLookupFunc {
+	if opts.Spec == nil && opts.TypeResolver == nil {
+		return nil
+	}
+
+	return opts.lookupType
+}
+
+// lookupType looks up the type by name in the spec, and then by the resolver.
+func (opts *CompileOptions) lookupType(name string) (btf.Type, error) {
+	if opts.Spec != nil {
+		if typ, err := opts.Spec.AnyTypeByName(name); err == nil {
+			return typ, nil
+		}
+	}
+
+	if opts.TypeResolver != nil {
+		if typ, ok := opts.TypeResolver(name); ok && typ != nil {
+			return typ, nil
+		}
+	}
+
+	return nil, fmt.Errorf("type %s not found", name)
+}
+
+// namedCastRegexp matches the cast to a named type like (pid_t)task->pid,
+// which cannot be parsed by cc.
+var namedCastRegexp = regexp.MustCompile(`\(\s*([A-Za-z_]\w*)\s*\)\s*([A-Za-z_(])`)
+
+// cTypeKeywords are the C type names, which are parsed by cc.
+var cTypeKeywords = map[string]bool{
+	"char":     true,
+	"short":    true,
+	"int":      true,
+	"long":     true,
+	"signed":   true,
+	"unsigned": true,
+}
+
+// intTypeName returns the C integer type of the same size and signedness as
+// the integer or enum type.
+func intTypeName(typ btf.Type) (string, error) {
+	size, err := btf.Sizeof(typ)
+	if err != nil {
+		return "", err
+	}
+
+	name := map[int]string{1: "char", 2: "short", 4: "int", 8: "long long"}[size]
+	if name == "" {
+		return "", fmt.Errorf("unexpected size %d of %s", size, typ)
+	}
+	if !isSignedType(typ) {
+		name = "unsigned " + name
+	}
+
+	return name, nil
+}
+
+// foldNamedCast rewrites the casts to the named integer types like (pid_t) to
+// the C integer types like (int), whose types are looked up in the spec or by
+// the resolver. The unknown names are kept as is.
+func foldNamedCast(expr string, opts *CompileOptions) (string, error) {
+	if opts.Spec == nil && opts.TypeResolver == nil {
+		return expr, nil
+	}
+
+	var err error
+	expr = namedCastRegexp.ReplaceAllStringFunc(expr, func(s string) string {
+		m := namedCastRegexp.FindStringSubmatch(s)
+		if cTypeKeywords[m[1]] {
+			return s
+		}
+
+		typ, lookupErr := opts.lookupType(m[1])
+		if lookupErr != nil {
+			return s
+		}
+
+		switch mybtf.UnderlyingType(typ).(type) {
+		case *btf.Int, *btf.Enum:
+		default:
+			err = fmt.Errorf("unexpected cast type %s; must be integer type", m[1])
+			return s
+		}
+
+		name, sizeErr := intTypeName(typ)
+		if sizeErr != nil {
+			err = fmt.Errorf("failed to cast to %s: %w", m[1], sizeErr)
+			return s
+		}
+
+		return "(" + name + ")" + m[2]
+	})
+
+	return expr, err
+}
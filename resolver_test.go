@@ -0,0 +1,107 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestFoldNamedCast(t *testing.T) {
+	resolver := func(name string) (btf.Type, bool) {
+		switch name {
+		case "my_s16":
+			return &btf.Typedef{Name: "my_s16", Type: &btf.Int{Name: "short", Size: 2, Encoding: btf.Signed}}, true
+		case "my_u32":
+			return &btf.Int{Name: "my_u32", Size: 4}, true
+		case "my_struct":
+			return &btf.Struct{Name: "my_struct", Size: 4}, true
+		default:
+			return nil, false
+		}
+	}
+	opts := &CompileOptions{TypeResolver: resolver}
+
+	for _, tt := range []struct {
+		expr, exp string
+	}{
+		{"(my_s16)skb->mark == 1", "(short)skb->mark == 1"},
+		{"( my_u32 ) skb->mark == 1", "(unsigned int)skb->mark == 1"},
+		{"(unknown)skb->mark == 1", "(unknown)skb->mark == 1"},
+		{"(int)skb->mark == 1", "(int)skb->mark == 1"},
+		{"(skb->mark & 0xff) == 1", "(skb->mark & 0xff) == 1"},
+	} {
+		expr, err := foldNamedCast(tt.expr, opts)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, expr, tt.exp)
+	}
+
+	_, err := foldNamedCast("(my_struct)skb->mark == 1", opts)
+	test.AssertHaveErr(t, err)
+	test.AssertStrPrefix(t, err.Error(), "unexpected cast type my_struct")
+}
+
+func TestCompileTypeResolver(t *testing.T) {
+	resolver := func(name string) (btf.Type, bool) {
+		if name != "my_s16" {
+			return nil, false
+		}
+		return &btf.Typedef{Name: "my_s16", Type: &btf.Int{Name: "short", Size: 2, Encoding: btf.Signed}}, true
+	}
+
+	t.Run("(my_s16)skb->mark > 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "(my_s16)skb->mark > 1", Type: getSkbBtf(t), TypeResolver: resolver})
+		test.AssertNoErr(t, err)
+
+		// The synthesized type is read as signed 2 bytes.
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-6:], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 48),
+			asm.ArSh.Imm(asm.R3, 48),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSGT.Imm(asm.R3, 1, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("container_of type", func(t *testing.T) {
+		resolver := func(name string) (btf.Type, bool) {
+			typ, err := testBtf.AnyTypeByName(name)
+			return typ, err == nil
+		}
+
+		res, err := Compile(CompileOptions{
+			Expr:         "container_of(dev, struct net_device, dev)->ifindex == 1",
+			Type:         getDeviceBtf(t),
+			TypeResolver: resolver,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 224-1464),
+		})
+	})
+
+	t.Run("enum value", func(t *testing.T) {
+		proto := &btf.Enum{Name: "my_proto", Size: 4, Values: []btf.EnumValue{{Name: "MY_PROTO_UDP", Value: 17}}}
+		resolver := func(name string) (btf.Type, bool) {
+			return proto, name == "MY_PROTO_UDP"
+		}
+
+		res, err := Compile(CompileOptions{Expr: "skb->mark == MY_PROTO_UDP", Type: getSkbBtf(t), TypeResolver: resolver})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, res.Constant, uint64(17))
+	})
+
+	t.Run("without resolver", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "(my_s16)skb->mark > 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to parse expression")
+	})
+}
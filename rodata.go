@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// rodataRefPrefix prefixes the variable name of @rodata.name as the right
+// operand name.
+const rodataRefPrefix = "__bice_rodata_"
+
+// rodataRegexp matches the reference of the .rodata variable like
+// @rodata.threshold, which cannot be parsed by cc.
+var rodataRegexp = regexp.MustCompile(`@rodata\.([A-Za-z_]\w*)\b`)
+
+// foldRodata rewrites the reference of the .rodata variable to the right
+// operand name like __bice_rodata_threshold. The string literals like
+// "@rodata.threshold" are kept.
+func foldRodata(expr string) string {
+	expr, _ = foldOutsideLiterals(expr, func(expr string) (string, error) {
+		return rodataRegexp.ReplaceAllString(expr, rodataRefPrefix+"$1"), nil
+	})
+	return expr
+}
+
+// rodataRef returns the variable name if the right operand is a reference of
+// the .rodata variable.
+func rodataRef(right *cc.Expr) (string, bool) {
+	if right == nil || right.Op != cc.Name || !strings.HasPrefix(right.Text, rodataRefPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(right.Text, rodataRefPrefix), true
+}
+
+// rodataVar looks up the integer variable in the datasec, and returns its
+// offset, size and whether it's signed.
+func rodataVar(sec *btf.Datasec, name string) (uint32, int, bool, error) {
+	if sec == nil {
+		return 0, 0, false, fmt.Errorf("cannot resolve @rodata.%s without CompileOptions.Rodata", name)
+	}
+
+	for _, vsi := range sec.Vars {
+		v, ok := vsi.Type.(*btf.Var)
+		if !ok || v.Name != name {
+			continue
+		}
+
+		if _, ok := mybtf.UnderlyingType(v.Type).(*btf.Int); !ok {
+			return 0, 0, false, fmt.Errorf("unexpected type %s of @rodata.%s; must be integer", v.Type, name)
+		}
+
+		switch vsi.Size {
+		case 1, 2, 4, 8:
+			return vsi.Offset, int(vsi.Size), isSignedType(v.Type), nil
+		default:
+			return 0, 0, false, fmt.Errorf("unexpected size %d of @rodata.%s", vsi.Size, name)
+		}
+	}
+
+	return 0, 0, false, fmt.Errorf("variable %s not found in %s", name, sec.Name)
+}
+
+// rodata2insns loads the variable at the offset of the datasec to r2 by the
+// map value relocation of the datasec, which is referenced by its name like
+// .rodata for loading. The signed variable is sign-extended to compare with
+// the sign-extended field.
+func rodata2insns(insns asm.Instructions, sec string, offset uint32, size int, signed bool) asm.Instructions {
+	insns = append(insns,
+		asm.LoadMapValue(asm.R2, 0, offset).WithReference(sec), // r2 = &.rodata + offset
+		asm.LoadMem(asm.R2, asm.R2, 0, sizeOf(size)),           // r2 = *(r2 + 0)
+	)
+	if signed && size < 8 {
+		shift := int32(64 - size*8)
+		insns = append(insns,
+			asm.LSh.Imm(asm.R2, shift),  // r2 <<= shift
+			asm.ArSh.Imm(asm.R2, shift), // r2 s>>= shift
+		)
+	}
+
+	return insns
+}
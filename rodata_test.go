@@ -0,0 +1,89 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+// getRodataBtf returns the datasec of const volatile __u32 threshold and
+// const volatile __s16 delta.
+func getRodataBtf() *btf.Datasec {
+	u32 := &btf.Int{Name: "__u32", Size: 4}
+	s16 := &btf.Int{Name: "__s16", Size: 2, Encoding: btf.Signed}
+	return &btf.Datasec{
+		Name: ".rodata",
+		Size: 8,
+		Vars: []btf.VarSecinfo{
+			{Type: &btf.Var{Name: "threshold", Type: &btf.Const{Type: &btf.Volatile{Type: u32}}}, Offset: 0, Size: 4},
+			{Type: &btf.Var{Name: "delta", Type: s16}, Offset: 4, Size: 2},
+			{Type: &btf.Var{Name: "name", Type: &btf.Array{Type: u32, Nelems: 2}}, Offset: 8, Size: 8},
+		},
+	}
+}
+
+func TestFoldRodata(t *testing.T) {
+	test.AssertEqual(t, foldRodata("skb->len > @rodata.threshold"), "skb->len > __bice_rodata_threshold")
+	test.AssertEqual(t, foldRodata("skb->len > 1"), "skb->len > 1")
+	test.AssertEqual(t, foldRodata(`dev->name == "@rodata.x"`), `dev->name == "@rodata.x"`)
+
+	t.Run(`skb->dev->name == "@rodata.x"`, func(t *testing.T) {
+		assertCompiledLiteral(t, `skb->dev->name == "@rodata.x"`, "@rodata.x")
+	})
+}
+
+func TestCompileRodata(t *testing.T) {
+	t.Run("skb->len > @rodata.threshold", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > @rodata.threshold", Type: getSkbBtf(t), Rodata: getRodataBtf()})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.LoadMapValue(asm.R2, 0, 0).WithReference(".rodata"),
+			asm.LoadMem(asm.R2, asm.R2, 0, asm.Word),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Reg(asm.R3, asm.R2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("skb->skb_iif < @rodata.delta", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->skb_iif < @rodata.delta", Type: getSkbBtf(t), Rodata: getRodataBtf()})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:len(insns)-2], asm.Instructions{
+			asm.LoadMapValue(asm.R2, 0, 4).WithReference(".rodata"),
+			asm.LoadMem(asm.R2, asm.R2, 0, asm.Half),
+			asm.LSh.Imm(asm.R2, 48),
+			asm.ArSh.Imm(asm.R2, 48),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JSLT.Reg(asm.R3, asm.R2, labelReturn),
+		})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, tt := range []struct {
+			expr   string
+			rodata *btf.Datasec
+		}{
+			{"skb->len > @rodata.threshold", nil},
+			{"skb->len > @rodata.unknown", getRodataBtf()},
+			{"skb->len > @rodata.name", getRodataBtf()},
+			{"sizeof(skb->len) == @rodata.threshold", getRodataBtf()},
+		} {
+			_, err := Compile(CompileOptions{Expr: tt.expr, Type: getSkbBtf(t), Rodata: tt.rodata})
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+// RootResult is the result of compiling against one of the candidate roots.
+type RootResult struct {
+	// Type is the candidate root type.
+	Type btf.Type
+
+	CompileResult
+
+	// Err is the error of compiling against the root, e.g. the referenced
+	// member isn't found in it.
+	Err error
+}
+
+// CompileRoots compiles the expression of the options against each of the
+// candidate root types, like hdr->dest == 80 against both tcphdr and udphdr
+// pointers, and returns the results in the order of the roots. The Type of the
+// options is ignored.
+//
+// There's no runtime type check, so the caller has to pick the result of the
+// root matching the context at runtime. It fails only if none of the roots
+// can be compiled.
+func CompileRoots(opts CompileOptions, roots []btf.Type) ([]RootResult, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no candidate roots of expression(%s)", opts.Expr)
+	}
+
+	var (
+		results = make([]RootResult, 0, len(roots))
+		errs    []error
+	)
+	for _, typ := range roots {
+		opts.Type = typ
+		res, err := Compile(opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("root %s: %w", typeName(typ), err))
+		}
+
+		results = append(results, RootResult{Type: typ, CompileResult: res, Err: err})
+	}
+
+	if len(errs) == len(roots) {
+		return nil, errors.Join(errs...)
+	}
+
+	return results, nil
+}
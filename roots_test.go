@@ -0,0 +1,58 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func getHdrBtf(t *testing.T, name string) btf.Type {
+	hdr, err := testBtf.AnyTypeByName(name)
+	test.AssertNoErr(t, err)
+	return &btf.Pointer{Target: hdr}
+}
+
+func TestCompileRoots(t *testing.T) {
+	tcp, udp := getHdrBtf(t, "tcphdr"), getHdrBtf(t, "udphdr")
+
+	t.Run("hdr->dest == 0x5000", func(t *testing.T) {
+		results, err := CompileRoots(CompileOptions{Expr: "hdr->dest == 0x5000"}, []btf.Type{tcp, udp})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, len(results), 2)
+
+		for i, typ := range []btf.Type{tcp, udp} {
+			res := results[i]
+			test.AssertEqual(t, res.Type, typ)
+			test.AssertNoErr(t, res.Err)
+			test.AssertEqualSlice(t, res.Insns[:2], asm.Instructions{
+				asm.Mov.Reg(asm.R3, asm.R1),
+				asm.Add.Imm(asm.R3, 2),
+			})
+		}
+	})
+
+	t.Run("hdr->doff == 5", func(t *testing.T) {
+		results, err := CompileRoots(CompileOptions{Expr: "hdr->doff == 5"}, []btf.Type{tcp, udp})
+		test.AssertNoErr(t, err)
+		test.AssertNoErr(t, results[0].Err)
+		test.AssertHaveErr(t, results[1].Err)
+		test.AssertStrPrefix(t, results[1].Err.Error(), "failed to compile expression(hdr->doff == 5)")
+	})
+
+	t.Run("no root", func(t *testing.T) {
+		_, err := CompileRoots(CompileOptions{Expr: "hdr->dest == 80"}, nil)
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("no matched root", func(t *testing.T) {
+		_, err := CompileRoots(CompileOptions{Expr: "hdr->xxx == 80"}, []btf.Type{tcp, udp})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "root tcphdr *: failed to compile expression(hdr->xxx == 80)")
+	})
+}
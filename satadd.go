@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+const (
+	satAddFunc = "sat_add"
+
+	labelSatAdd = "__sat_add_bice_filter"
+)
+
+func isSatAdd(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == satAddFunc
+}
+
+func validateSatAdd(call *cc.Expr) error {
+	if len(call.List) != 2 {
+		return fmt.Errorf("%s() expects 2 arguments, got %d", satAddFunc, len(call.List))
+	}
+
+	addend := call.List[1]
+	if addend.Op != cc.Number {
+		return fmt.Errorf("unexpected addend %v of %s(); must be constant number", addend, satAddFunc)
+	}
+
+	if _, err := parseAddend(addend.Text); err != nil {
+		return err
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+func parseAddend(text string) (uint64, error) {
+	addend, err := parseNumber(text)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse addend %s: %w", text, err)
+	}
+	if addend == 0 || addend > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid addend %s; must be in [1, %d]", text, math.MaxInt32)
+	}
+
+	return addend, nil
+}
+
+// satAddMax returns the max value of the unsigned field of sat_add(), which
+// the sum saturates to.
+func satAddMax(ast astInfo, size int, addend uint64) (uint64, error) {
+	intType, ok := mybtf.UnderlyingType(ast.lastField).(*btf.Int)
+	if !ok || intType.Encoding == btf.Signed {
+		return 0, fmt.Errorf("%s() expects unsigned integer, got %s", satAddFunc, ast.lastField)
+	}
+
+	bits := size * 8
+	if IsMemberBitfield(ast.member) {
+		bits = int(ast.member.BitfieldSize)
+	}
+
+	maximum := uint64(math.MaxUint64)
+	if bits < 64 {
+		maximum = uint64(1)<<bits - 1
+	}
+	if addend > maximum {
+		return 0, fmt.Errorf("addend %d of %s() exceeds max %d of %s", addend, satAddFunc, maximum, ast.lastField)
+	}
+
+	return maximum, nil
+}
+
+// satAdd2insns adds the addend to the unsigned value in reg in place, which
+// saturates to max instead of overflowing. The value beyond max - addend is
+// clamped to it before adding, so the sum is max. R2 is used as scratch
+// register if max - addend does not fit in imm32.
+func satAdd2insns(insns asm.Instructions, addend, maximum uint64, reg asm.Register) asm.Instructions {
+	limit := maximum - addend
+	if limit <= math.MaxInt32 {
+		insns = append(insns,
+			asm.JLE.Imm(reg, int32(limit), labelSatAdd), // if reg <= limit, goto __sat_add
+			asm.Mov.Imm(reg, int32(limit)),              // reg = limit
+		)
+	} else {
+		insns = append(insns,
+			asm.LoadImm(asm.R2, int64(limit), asm.DWord), // r2 = limit
+			asm.JLE.Reg(reg, asm.R2, labelSatAdd),        // if reg <= r2, goto __sat_add
+			asm.Mov.Reg(reg, asm.R2),                     // reg = r2
+		)
+	}
+
+	return append(insns,
+		asm.Add.Imm(reg, int32(addend)).WithSymbol(labelSatAdd), // reg += addend; __sat_add
+	)
+}
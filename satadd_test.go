@@ -0,0 +1,104 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+// runSatAdd emulates the instructions emitted by satAdd2insns, whose only
+// jump is the forward JLE to the label.
+func runSatAdd(t *testing.T, insns asm.Instructions, regs map[asm.Register]uint64) {
+	for pc := 0; pc < len(insns); pc++ {
+		ins := insns[pc]
+		if ins.OpCode.JumpOp() != asm.JLE {
+			runALU64(t, insns[pc:pc+1], regs)
+			continue
+		}
+
+		src := uint64(ins.Constant)
+		if ins.OpCode.Source() == asm.RegSource {
+			src = regs[ins.Src]
+		}
+		if regs[ins.Dst] <= src {
+			for insns[pc+1].Symbol() != ins.Reference() {
+				pc++
+			}
+		}
+	}
+}
+
+func TestSatAdd2insns(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		addend  uint64
+		maximum uint64
+		values  [][2]uint64
+	}{
+		{"u16", 10, math.MaxUint16, [][2]uint64{{0, 10}, {65525, 65535}, {65526, 65535}, {65535, 65535}}},
+		{"u32", 10, math.MaxUint32, [][2]uint64{{1, 11}, {0xfffffff5, 0xffffffff}, {0xfffffffa, 0xffffffff}}},
+		{"u64", 1, math.MaxUint64, [][2]uint64{{41, 42}, {math.MaxUint64 - 1, math.MaxUint64}, {math.MaxUint64, math.MaxUint64}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			insns := satAdd2insns(nil, tt.addend, tt.maximum, asm.R3)
+			for _, v := range tt.values {
+				regs := map[asm.Register]uint64{asm.R3: v[0]}
+				runSatAdd(t, insns, regs)
+				test.AssertEqual(t, regs[asm.R3], v[1])
+			}
+		})
+	}
+}
+
+func TestCompileSatAdd(t *testing.T) {
+	t.Run("sat_add(skb->queue_mapping, 10) > 1000", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "sat_add(skb->queue_mapping, 10) > 1000", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xffff),
+			asm.JLE.Imm(asm.R3, 65525, labelSatAdd),
+			asm.Mov.Imm(asm.R3, 65525),
+			asm.Add.Imm(asm.R3, 10).WithSymbol(labelSatAdd),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 1000, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("big endian", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "sat_add(skb->protocol, 1) == 0x801", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:len(insns)-3], asm.Instructions{
+			asm.HostTo(asm.BE, asm.R3, asm.Half),
+			asm.JLE.Imm(asm.R3, 65534, labelSatAdd),
+			asm.Mov.Imm(asm.R3, 65534),
+			asm.Add.Imm(asm.R3, 1).WithSymbol(labelSatAdd),
+			asm.Mov.Imm(asm.R0, 1),
+		})
+	})
+
+	t.Run("signed field", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "sat_add(skb->skb_iif, 10) > 1000", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression(sat_add(skb->skb_iif, 10) > 1000): sat_add() expects unsigned integer")
+	})
+
+	t.Run("invalid addend", func(t *testing.T) {
+		for _, expr := range []string{"sat_add(skb->len, 0) > 1", "sat_add(skb->len, skb->mark) > 1", "sat_add(skb->len) > 1"} {
+			_, err := Compile(CompileOptions{Expr: expr, Type: getSkbBtf(t)})
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+		}
+	})
+}
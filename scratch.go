@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/cilium/ebpf/asm"
+)
+
+const (
+	// scratchReg keeps the scratch buffer for the comparison after reading,
+	// which isn't clobbered by the comparison.
+	scratchReg = asm.R4
+
+	stackOffsetScratch    = -24 // saved pointer to the scratch buffer
+	stackOffsetScratchKey = -4  // key of the scratch map
+)
+
+// scratch2insns looks up the scratch buffer at key 0 of the per-CPU array map
+// referenced by name, instead of the stack for the wide reads. It sets r1 to
+// the buffer with r3 kept, and saves the buffer at r10 - 24 to be reloaded to
+// scratchReg after reading.
+func scratch2insns(insns asm.Instructions, name, labelFail string) asm.Instructions {
+	return append(insns,
+		asm.StoreMem(asm.R10, stackOffsetRoot, asm.R3, asm.DWord),    // *(r10 - 16) = r3
+		asm.StoreImm(asm.R10, stackOffsetScratchKey, 0, asm.Word),    // *(u32 *)(r10 - 4) = 0
+		asm.LoadMapPtr(asm.R1, 0).WithReference(name),                // r1 = map
+		asm.Mov.Reg(asm.R2, asm.R10),                                 // r2 = r10
+		asm.Add.Imm(asm.R2, stackOffsetScratchKey),                   // r2 = r10 - 4; key
+		asm.FnMapLookupElem.Call(),                                   // r0 = bpf_map_lookup_elem(r1, r2)
+		asm.JEq.Imm(asm.R0, 0, labelFail),                            // if r0 == 0, goto fail
+		asm.StoreMem(asm.R10, stackOffsetScratch, asm.R0, asm.DWord), // *(r10 - 24) = r0
+		asm.Mov.Reg(asm.R1, asm.R0),                                  // r1 = r0
+		asm.LoadMem(asm.R3, asm.R10, stackOffsetRoot, asm.DWord),     // r3 = *(r10 - 16)
+	)
+}
@@ -0,0 +1,70 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestCompileScratchMap(t *testing.T) {
+	t.Run("within budget", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:        "skb->dev->name == \"lo\"",
+			Type:        getSkbBtf(t),
+			StackBudget: 8,
+			ScratchMap:  "scratch",
+		})
+		test.AssertNoErr(t, err)
+
+		for _, ins := range res.Insns {
+			test.AssertFalse(t, ins.IsLoadFromMap())
+		}
+	})
+
+	t.Run("beyond budget", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:        "skb->dev->name == \"eth0123456\"",
+			Type:        getSkbBtf(t),
+			StackBudget: 8,
+			ScratchMap:  "scratch",
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[8:22], asm.Instructions{
+			asm.Add.Imm(asm.R3, 304),
+			asm.StoreMem(asm.R10, -16, asm.R3, asm.DWord),
+			asm.StoreImm(asm.R10, -4, 0, asm.Word),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("scratch"),
+			asm.Mov.Reg(asm.R2, asm.R10),
+			asm.Add.Imm(asm.R2, -4),
+			asm.FnMapLookupElem.Call(),
+			asm.JEq.Imm(asm.R0, 0, labelExitFail),
+			asm.StoreMem(asm.R10, -24, asm.R0, asm.DWord),
+			asm.Mov.Reg(asm.R1, asm.R0),
+			asm.LoadMem(asm.R3, asm.R10, -16, asm.DWord),
+			asm.Mov.Imm(asm.R2, 11),
+			asm.FnProbeReadKernel.Call(),
+			asm.JNE.Imm(asm.R0, 0, labelExitFail),
+		})
+		test.AssertEqualSlice(t, insns[22:24], asm.Instructions{
+			asm.LoadMem(asm.R4, asm.R10, -24, asm.DWord),
+			asm.LoadMem(asm.R3, asm.R4, 0, asm.DWord),
+		})
+	})
+
+	t.Run("no scratch map", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:        "skb->dev->name == \"eth0123456\"",
+			Type:        getSkbBtf(t),
+			StackBudget: 8,
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+)
+
+// maxSetFilters is the max number of filters in a set, as the bitmask is in
+// the 64-bit r0.
+const maxSetFilters = 64
+
+// setAccReg is the callee-saved register to accumulate the verdicts of the
+// filters in a set.
+const setAccReg = asm.R7
+
+type CompileSetOptions struct {
+	// Exprs are the expressions of the independent filters.
+	Exprs []string
+
+	// Options is the template to compile every filter, whose Expr is
+	// replaced by the one in Exprs. CompareReg is not supported, as r6 and
+	// r7 are used to keep the root pointer and the verdict.
+	Options CompileOptions
+
+	// Bitmask sets bit i of the verdict if filter i matches, instead of
+	// returning 1 if any filter matches.
+	Bitmask bool
+}
+
+// setLabel returns the label of filter i in the set.
+func setLabel(label string, i int) string {
+	return fmt.Sprintf("%s_%d", label, i)
+}
+
+// isFilterLabel reports whether the label is generated by bice, like
+// __exit_bice_filter or __map_bice_filter_0.
+func isFilterLabel(label string) bool {
+	return strings.HasPrefix(label, "__") && strings.Contains(label, "_bice_filter")
+}
+
+// relabel renames the labels generated for the compiled filter i, so that the
+// labels of the filters in a set don't collide.
+func relabel(insns asm.Instructions, i int) asm.Instructions {
+	for j, ins := range insns {
+		if sym := ins.Symbol(); isFilterLabel(sym) {
+			insns[j] = ins.WithSymbol(setLabel(sym, i))
+		}
+		if ref := ins.Reference(); isFilterLabel(ref) && !ins.IsLoadFromMap() {
+			insns[j] = insns[j].WithReference(setLabel(ref, i))
+		}
+	}
+
+	return insns
+}
+
+// CompileSet compiles a set of independent filters into one program, which
+// returns 1 if any filter matches, or the bitmask of the matched filters with
+// CompileSetOptions.Bitmask.
+func CompileSet(opts CompileSetOptions) (CompileResult, error) {
+	if len(opts.Exprs) == 0 {
+		return CompileResult{}, fmt.Errorf("no filters to compile")
+	}
+	if len(opts.Exprs) > maxSetFilters {
+		return CompileResult{}, fmt.Errorf("too many filters %d; must be at most %d", len(opts.Exprs), maxSetFilters)
+	}
+	if opts.Options.CompareReg != 0 {
+		return CompileResult{}, fmt.Errorf("cannot compile filter set with compare register %s", opts.Options.CompareReg)
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(ctxSaveReg, asm.R1),   // r6 = r1
+		asm.Xor.Reg(setAccReg, setAccReg), // r7 = 0
+	}
+
+	for i, expr := range opts.Exprs {
+		filterOpts := opts.Options
+		filterOpts.Expr = expr
+		res, err := Compile(filterOpts)
+		if err != nil {
+			return CompileResult{}, fmt.Errorf("failed to compile filter %d: %w", i, err)
+		}
+
+		// The verdict of the filter is accumulated instead of returned.
+		filter := relabel(res.Insns, i)
+		ret := filter[len(filter)-1]
+		if ret.OpCode.JumpOp() != asm.Exit {
+			return CompileResult{}, fmt.Errorf("unexpected last instruction %v of filter %d", ret, i)
+		}
+		filter = filter[:len(filter)-1]
+
+		var acc asm.Instructions
+		if opts.Bitmask {
+			acc = append(acc,
+				asm.LSh.Imm(asm.R0, int32(i)), // r0 <<= i
+			)
+		}
+		acc = append(acc,
+			asm.Or.Reg(setAccReg, asm.R0), // r7 |= r0
+		)
+		acc[0] = acc[0].WithMetadata(ret.Metadata)
+
+		if i != 0 {
+			insns = append(insns, asm.Mov.Reg(asm.R1, ctxSaveReg)) // r1 = r6
+		}
+		insns = append(insns, filter...)
+		insns = append(insns, acc...)
+	}
+
+	insns = append(insns,
+		asm.Mov.Reg(asm.R0, setAccReg),       // r0 = r7
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	)
+
+	return CompileResult{Insns: insns}, nil
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestRelabel(t *testing.T) {
+	insns := relabel(asm.Instructions{
+		asm.JEq.Imm(asm.R3, 0, labelExitFail),
+		asm.Mov.Imm(asm.R0, 1),
+		asm.JGT.Imm(asm.R3, 1, labelReturn),
+		asm.JNE.Imm(asm.R3, 1, "next"),
+		asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+		asm.Return().WithSymbol(labelReturn),
+	}, 2)
+
+	test.AssertEqual(t, insns[0].Reference(), labelExitFail+"_2")
+	test.AssertEqual(t, insns[2].Reference(), labelReturn+"_2")
+	test.AssertEqual(t, insns[3].Reference(), "next")
+	test.AssertEqual(t, insns[4].Symbol(), labelExitFail+"_2")
+	test.AssertEqual(t, insns[5].Symbol(), labelReturn+"_2")
+}
+
+func TestCompileSet(t *testing.T) {
+	// The sizeof comparisons are folded to constant verdicts, so the
+	// instructions can be emulated without reading.
+	exprs := []string{
+		"sizeof(skb->len) == 4",
+		"sizeof(skb->len) == 8",
+		"sizeof(skb->mark) == 4",
+	}
+
+	t.Run("bitmask", func(t *testing.T) {
+		res, err := CompileSet(CompileSetOptions{
+			Exprs:   exprs,
+			Options: CompileOptions{Type: getSkbBtf(t)},
+			Bitmask: true,
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-2:], asm.Instructions{
+			asm.Mov.Reg(asm.R0, asm.R7),
+			asm.Return().WithSymbol(labelReturn),
+		})
+
+		regs := map[asm.Register]uint64{}
+		runALU64(t, insns[:len(insns)-1], regs)
+		test.AssertEqual(t, regs[asm.R0], 0b101)
+	})
+
+	t.Run("any", func(t *testing.T) {
+		res, err := CompileSet(CompileSetOptions{
+			Exprs:   exprs[1:2],
+			Options: CompileOptions{Type: getSkbBtf(t)},
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+			asm.Xor.Reg(asm.R7, asm.R7),
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Or.Reg(asm.R7, asm.R0).WithSymbol(labelReturn + "_0"),
+			asm.Mov.Reg(asm.R0, asm.R7),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("unique labels", func(t *testing.T) {
+		res, err := CompileSet(CompileSetOptions{
+			Exprs:   []string{"skb->len > 1024", "skb->dev->ifindex == 1"},
+			Options: CompileOptions{Type: getSkbBtf(t)},
+			Bitmask: true,
+		})
+		test.AssertNoErr(t, err)
+
+		symbols := map[string]int{}
+		for _, ins := range res.Insns {
+			if sym := ins.Symbol(); sym != "" {
+				symbols[sym]++
+			}
+		}
+		for sym, n := range symbols {
+			test.AssertEqual(t, n, 1)
+			test.AssertTrue(t, strings.HasPrefix(sym, "__"))
+		}
+		for _, ins := range res.Insns {
+			if ref := ins.Reference(); ref != "" {
+				test.AssertEqual(t, symbols[ref], 1)
+			}
+		}
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-4:len(res.Insns)-2], asm.Instructions{
+			asm.LSh.Imm(asm.R0, 1).WithSymbol(labelReturn + "_1"),
+			asm.Or.Reg(asm.R7, asm.R0),
+		})
+	})
+
+	t.Run("no filters", func(t *testing.T) {
+		_, err := CompileSet(CompileSetOptions{Options: CompileOptions{Type: getSkbBtf(t)}})
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "no filters to compile")
+	})
+
+	t.Run("too many filters", func(t *testing.T) {
+		_, err := CompileSet(CompileSetOptions{Exprs: make([]string, maxSetFilters+1)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "too many filters")
+	})
+
+	t.Run("compare register", func(t *testing.T) {
+		_, err := CompileSet(CompileSetOptions{Exprs: exprs, Options: CompileOptions{CompareReg: asm.R8}})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "cannot compile filter set")
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		_, err := CompileSet(CompileSetOptions{Exprs: []string{"skb->xxx == 1"}, Options: CompileOptions{Type: getSkbBtf(t)}})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile filter 0")
+	})
+}
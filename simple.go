@@ -4,17 +4,19 @@
 package bice
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
 )
 
 // SimpleCompile compiles simple C expressions to bpf instructions.
 //
-// It can not handle complex expressions like function calls, pointer
-// dereferences, or array accesses.
-// Currently, it only supports struct member access and comparison operators.
+// It compiles a single comparison of a field reached by struct/union member
+// access and array access, whose left operand can be transformed by the casts,
+// masks, arithmetic and builtin functions described below.
 //
 // For examples with ATT-like syntax:
 //
@@ -59,31 +61,538 @@ import (
 //     __return:
 //     retq
 //
-// Only struct/union member access and comparison operators are supported. No
-// function calls, pointer dereferences, array accesses, parentheses, bitwise
-// operators, logical operators, or arithmetic operators are supported.
+// Only the forms described below are supported. Logical operators like && and
+// ||, and calls of functions other than the builtins like bits(), byte() and
+// now() are rejected.
 //
 // The left part of the expression must be struct/union member access, and the
-// right part must be a constant number.
+// right part must be a constant number or an enum name, which is rejected if
+// it's wider than the field. Bitwise OR of constant numbers and enum names,
+// like (FAULT_FLAG_WRITE | FAULT_FLAG_ALLOW_RETRY), is folded to a single
+// constant at compile time. The complement of a constant
+// number, like skb->mark == ~0x3, is folded too and truncated to the width of
+// the field. The array can be either embedded in the struct/union, like
+// skb->cb[0], or pointed by a member, like hub->buffer[2] where buffer is
+// u8 (*)[8]. A negative constant index counts from the end of the array, like
+// skb->cb[-1] for the last element.
+//
+// The target of the pointer tagged with __user in btf is read by
+// bpf_probe_read_user() instead of bpf_probe_read_kernel() per hop, while the
+// other tags like __rcu are read from kernel memory.
+//
+// The container struct of an embedded struct can be accessed like
+// container_of(dev, struct net_device, dev)->ifindex, whose type is looked up in
+// CompileOptions.Spec or by CompileOptions.TypeResolver. The first entry of
+// the kernel list can be accessed like
+// list_first(task->children, struct task_struct, sibling)->pid, which is
+// container_of(task->children->next, struct task_struct, sibling).
+//
+// The quotient of a field divided by a constant can be compared like
+// skb->len / 64 == 10.
+//
+// The remainder of a field divided by a constant can be compared like
+// skb->len % 4 == 0, and a pointer is treated as an unsigned 64-bit value to
+// check its alignment like skb->data % 8 == 0.
+//
+// The number of set bits of a field can be compared like
+// popcount(skb->mark) > 2.
+//
+// The 32-bit FNV-1a hash of a byte range of at most 64 bytes of an array or the
+// memory pointed by a pointer can be compared like hash(skb->data[0:14]) ==
+// 0x1234.
+//
+// The bitfield is extracted in its width like skb->pkt_type == 0b101, and the
+// constant compared with it must fit in the width.
+//
+// The difference of two fields can be compared like skb->end - skb->head >
+// 2048, which is compared as signed 64-bit integer.
+//
+// The absolute value of a signed field can be compared like
+// abs(skb->skb_iif) > 10, which is compared as unsigned.
+//
+// The sum of an unsigned field and a constant can be compared like
+// sat_add(skb->len, 10) > 1500, which saturates to the max of the field
+// instead of overflowing.
+//
+// A field can be translated through an inline table before comparison like
+// map(iph->protocol, {1:A, 6:B, 17:C}) == B, whose names are looked up in
+// Constants, and the value not in the table is translated to 0.
+//
+// A field can be masked by a constant before comparison like
+// (skb->mark & 0xff) == 1, and an IPv4 address can be tested against a prefix
+// like iph->saddr in 10.0.0.0/8. An IPv4 address like 10.0.0.1 is a constant
+// in host byte order. A field can be masked by another field like
+// (skb->flags & skb->mask) != 0, which is compared as unsigned. A field can be
+// bitwise negated in its width like (~skb->mark & 0x1) == 0 to test a clear
+// flag.
+//
+// A tuple of fields can be compared with a tuple of constants like
+// (iph->saddr, iph->daddr) == (10.0.0.1, 10.0.0.2), which reads every field
+// once and matches if all of them are equal.
+//
+// A field can be looked up in a map like iph->saddr in @allowlist, which
+// matches if the element keyed by the field is found. The map is referenced by
+// the name for loading, and the lookup cannot be compared with CompareReg.
+//
+// The char array or const char pointer can be compared with a string literal
+// like dev->name == "lo" or dev->name != "lo", whose C escape sequences are
+// unescaped.
+//
+// The array embedded in the struct can be indexed by another field like
+// skb->cb[skb->queue_mapping] == 1, and the index out of range mismatches.
+//
+// The left operand can be a raw offset access like *(unsigned int *)(skb - 8),
+// which reads the integer at the signed offset relative to the root pointer.
+// The bits of the read value can be extracted like
+// bits(*(unsigned int *)(skb + 4), 3, 7) == 5 for the inclusive range of bits
+// 3-7 in the target byte order, which must be in the width of the read. A
+// byte of the field can be extracted like byte(skb->protocol, 0) == 0x08 for
+// the byte 0 of the field in memory, which must be in the size of the field.
+//
+// The left operand can be casted to an integer type like (unsigned short) to
+// read the field in the width of the cast type instead of its btf size. A cast
+// to kernel integer type like (u32) or (__s16) is supported too, which
+// overrides the signedness of the comparison with the same width. A cast to a
+// named integer type like (pid_t) is supported too, whose type is looked up in
+// CompileOptions.Spec or by CompileOptions.TypeResolver.
+//
+// The right operand can be a percent-of-max literal like 80% of 1500, which is
+// folded to 1200 at compile time. A decimal number can have a unit suffix like
+// 1KiB, which is folded to the byte count, i.e. 1024 for the binary KiB, MiB
+// and GiB, and 1000 for the decimal KB, MB and GB. A duration can have a time
+// unit suffix like task->start_time > 5s, which is folded to the nanoseconds,
+// i.e. ns, us, ms and s. The right operand can be an externally provided value
+// like skb->len > $max_len, which is substituted from Values.
+//
+// A fixed-point field in Q format can be compared with a decimal literal like
+// fixed(skb->priority, 8) > 1.5 for 8 fractional bits, whose literal is scaled
+// by 2^8 at compile time to skb->priority > 384. The literal must be exactly
+// representable with the fractional bits.
+//
+// A field can be compared with the current time like skb->tstamp < now(), which
+// is got by bpf_ktime_get_ns().
+//
+// A field can be compared with the integer variable of the read-only data of
+// the program like skb->len > @rodata.threshold, which is looked up in
+// CompileOptions.Rodata and loaded by the map value relocation.
+//
+// The current process id, i.e. the tgid of bpf_get_current_pid_tgid(), can be
+// compared like pid() == 1234 without reading any field, which is cheap to
+// scope the following conditions of the FilterBuilder.
+//
+// The size of member can be compared like sizeof(skb->len) == 4, whose verdict
+// is determined at compile time.
+//
+// The member access of an argument other than the root can be compared like
+// arg2->mtu < 1500, or compared with the member access of another root like
+// skb->len > arg2->mtu, see CompileOptions.Args.
+//
+// The right operand can be a negative number like -1, and the signed field of
+// less than 8 bytes is sign-extended to be compared with it.
 //
 // The operator must be one of the following: =, ==, !=, <, <=, >, >=. '=' is
 // used for comparison too.
+//
+// A member access can be zero-tested like !skb->sk, which matches if the value
+// is zero, e.g. a null pointer.
+//
+// The comparison can be the condition of a top-level ternary with constant
+// arms like skb->len > 1500 ? 2 : 1, which returns 2 if matched and 1 if not.
 func SimpleCompile(expr string, typ btf.Type) (asm.Instructions, error) {
-	ast, err := parse(expr)
+	res, err := Compile(CompileOptions{
+		Expr: expr,
+		Type: typ,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Insns, nil
+}
+
+// CompileOptions is the options to compile a simple C expression.
+type CompileOptions struct {
+	// Expr is the simple C expression to compile, see SimpleCompile.
+	Expr string
+
+	// Type is the btf type of the root of the expression, which is in r1
+	// when running the compiled instructions.
+	Type btf.Type
+
+	// Spec is the optional btf spec to look up the types by name, e.g. the
+	// enum type of an enum name compared with a non-enum field.
+	Spec *btf.Spec
+
+	// TypeResolver resolves the type by name if it's not in Spec, e.g. the
+	// named integer type of a cast like (pid_t)task->pid, the container type
+	// of container_of(), or the enum type of an enum name like IPPROTO_TCP,
+	// to compose the types of multiple btf sources.
+	TypeResolver TypeResolver
+
+	// ForceBigEndian treats the last field as big endian, which corrects
+	// the mis-detection of big endian fields whose types are not __be*.
+	ForceBigEndian bool
+
+	// ForceLittleEndian treats the last field detected as big endian by its
+	// type like __be32 as host byte order, which prevents the double swap
+	// when the field is already converted in the context. It conflicts with
+	// ForceBigEndian.
+	ForceLittleEndian bool
+
+	// ByteOrder is the byte order of the target running the compiled
+	// instructions, either binary.LittleEndian or binary.BigEndian, which
+	// is the host byte order by default. It decides the constants of big
+	// endian fields, the bytes of byte(), the words of string literals, and
+	// the encoding of CompileBytes.
+	ByteOrder binary.ByteOrder
+
+	// UseDirectLoad dereferences the pointers directly instead of
+	// bpf_probe_read_kernel(), when the root is a trusted btf pointer, e.g.
+	// the arguments of fentry/fexit programs.
+	UseDirectLoad bool
+
+	// DirectContext loads the fields of the root directly, when the root is
+	// the context of the program like struct pt_regs or the tracepoint
+	// format struct. The pointers in the context are still read by
+	// bpf_probe_read_kernel().
+	DirectContext bool
+
+	// CgroupSkb compiles for the __sk_buff context of cgroup_skb programs,
+	// whose fields are loaded directly at the __sk_buff offsets, and the
+	// fields not available in the context are rejected. Type must be the
+	// pointer to __sk_buff.
+	CgroupSkb bool
+
+	// WordLoad loads the 4-byte fields by 32-bit loads, which zero-extend
+	// the value, instead of 64-bit loads followed by masking.
+	WordLoad bool
+
+	// ZeroExtend zero-extends the fields used in the arithmetic like
+	// skb->len / 4 explicitly after reading them, and masks the byte offset
+	// of the dynamic index like skb->cb[skb->queue_mapping] to the array, so
+	// the stricter verifiers can track their bounds in the subsequent uses.
+	ZeroExtend bool
+
+	// CounterMap is the name of the array or per-CPU array map, whose u64
+	// value at key 0 is incremented on every match before returning, to
+	// count the matches. The map is referenced by the name for loading. The
+	// ternary like skb->len > 1024 ? 2 : 1 counts the matches of the
+	// condition, i.e. the verdicts of the then arm, which is re-materialized
+	// after the counter update. With InvertVerdict, the matches are still
+	// counted, i.e. the verdicts of 0 or the else arm.
+	CounterMap string
+
+	// VerdictReg puts the verdict, i.e. 1 if matched and 0 if not, in the
+	// register instead of returning it, for the surrounding program to
+	// combine the verdicts. It must be one of r6-r9, and the instructions
+	// fall through to the surrounding program without return. It must not
+	// be clobbered by the compiled instructions, i.e. r6 by the tuple, the
+	// difference, the field mask and the set, r7 by the set and r8 by the
+	// difference and the field mask.
+	VerdictReg asm.Register
+
+	// InvertVerdict swaps the verdicts, i.e. r0 = 0 if matched and r0 = 1 if
+	// not, for the drop-list use cases.
+	InvertVerdict bool
+
+	// SkStorage looks up the root from the socket-local storage of the
+	// socket, and Type is the type of the pointer to the storage value.
+	SkStorage *SkStorageOptions
+
+	// ReadHelper is the helper to read memory instead of
+	// bpf_probe_read_kernel(), for the custom runtimes implementing the read
+	// helpers at non-standard IDs. Its arguments must be the same as
+	// bpf_probe_read_kernel().
+	ReadHelper asm.BuiltinFunc
+
+	// Annotate attaches the member access expressions as the sources of the
+	// instructions reading them, e.g. "read skb->dev", to map the verifier
+	// errors back to the expression.
+	Annotate bool
+
+	// CompareReg compares the left operand with the value in the register,
+	// which is set by the surrounding program, instead of the right operand
+	// constant, e.g. skb->len > threshold with r6 holding the threshold. It
+	// must be one of r6-r9 not clobbered by the compiled instructions like
+	// VerdictReg, and the right operand is only a placeholder.
+	CompareReg asm.Register
+
+	// LabelFail is the label to jump to when failing to read the memory, e.g.
+	// NULL pointer, instead of returning 0, to continue at the next filter in
+	// a multi-filter pipeline. The label must be defined by the caller, and
+	// r0 is undefined there.
+	LabelFail string
+
+	// RelativeJumps resolves the labels of the jumps to relative offsets,
+	// so the returned instructions have no symbols and can be spliced
+	// anywhere. It fails if any jump is dangling, e.g. to LabelFail, or any
+	// map is referenced.
+	RelativeJumps bool
+
+	// OpEmitters overrides how the comparison of the operator is emitted,
+	// keyed by the operator symbol like "==", which is used for '=' too. The
+	// string comparisons are not affected.
+	OpEmitters map[string]OpEmitter
+
+	// StackBudget limits the bytes of the read buffer on stack for the wide
+	// comparisons like the string comparisons, and ScratchMap is used for
+	// the buffer beyond the budget. No limit if 0.
+	StackBudget int
+
+	// ScratchMap is the name of the per-CPU array map, whose value at key 0
+	// is the read buffer if StackBudget is exceeded. Its value size must be
+	// at least the size of the buffer. The map is referenced by the name for
+	// loading.
+	ScratchMap string
+
+	// LPMKey builds the key of the map membership like iph->saddr in @map as
+	// struct bpf_lpm_trie_key with the full prefix length of the field, for
+	// the LPM trie maps.
+	LPMKey bool
+
+	// FieldAliases resolves the members renamed across kernel versions, like
+	// task_struct.state to task_struct.__state. DefaultFieldAliases is used if
+	// nil.
+	FieldAliases FieldAliases
+
+	// Rodata is the datasec of the read-only data of the program like
+	// .rodata, whose integer variables are compared like skb->len >
+	// @rodata.threshold by the map value relocation of the datasec name.
+	Rodata *btf.Datasec
+
+	// Macros are the named sub-expressions like is_ipv4 for
+	// skb->protocol == 0x0008, which are expanded in Expr before parsing.
+	Macros Macros
+
+	// Constants are the user-defined named constants like ETH_P_IP, which
+	// are looked up if the right operand name is not an enum value.
+	Constants map[string]uint64
+
+	// Values are the externally provided values like the per-tenant
+	// thresholds, which are substituted for the names prefixed by $ like
+	// skb->len > $max_len in Expr before parsing. The unknown name is
+	// rejected.
+	Values map[string]uint64
+
+	// Args maps the argument names to their registers and types, to access
+	// the members of the arguments other than the root like arg2->mtu. The
+	// member accesses of two roots can be compared like skb->len >
+	// arg2->mtu.
+	Args map[string]ArgRoot
+}
+
+// CompileResult is the result of compiling a simple C expression.
+type CompileResult struct {
+	Insns asm.Instructions
+
+	// Operator is the comparison operator of the expression.
+	Operator cc.ExprOp
+
+	// Constant is the constant compared with, which is in the width and
+	// byte order of the field, e.g. 0x0008 of skb->protocol == 0x800 for the
+	// big endian protocol. It's 0 if the comparison isn't with a constant,
+	// e.g. the string comparison.
+	Constant uint64
+
+	desc string
+	csrc string
+}
+
+// Describe returns the human-readable restatement of the compiled filter for
+// the audit logs, like "read sk_buff.dev(+16)->ifindex(+224) as int; match if
+// == 1" of skb->dev->ifindex == 1.
+func (r CompileResult) Describe() string {
+	return r.desc
+}
+
+// ToC returns the eBPF C source equivalent to the compiled filter for
+// inspection, like
+//
+//	static __always_inline int bice_filter(void *skb)
+//	{
+//		__u32 val;
+//
+//		bpf_probe_read_kernel(&val, sizeof(val), skb + 112); /* skb->len */
+//		return val > 1024;
+//	}
+//
+// of skb->len > 1024. It's "" if the filter cannot be restated in C, e.g. the
+// string comparison or the pseudo-functions.
+func (r CompileResult) ToC() string {
+	return r.csrc
+}
+
+// Compile compiles the simple C expression with the given options, see
+// SimpleCompile for the supported expressions.
+func Compile(opts CompileOptions) (CompileResult, error) {
+	if opts.ReadHelper != asm.FnUnspec {
+		if err := validateReadHelper(opts.ReadHelper); err != nil {
+			return CompileResult{}, err
+		}
+	}
+
+	if opts.CompareReg != 0 {
+		if err := validateCompareReg(opts.CompareReg); err != nil {
+			return CompileResult{}, err
+		}
+	}
+
+	if opts.ForceBigEndian && opts.ForceLittleEndian {
+		return CompileResult{}, fmt.Errorf("cannot force both big endian and little endian")
+	}
+
+	if opts.ByteOrder != nil {
+		if err := validateByteOrder(opts.ByteOrder); err != nil {
+			return CompileResult{}, err
+		}
+	}
+
+	if opts.VerdictReg != 0 {
+		if err := validateVerdictReg(opts.VerdictReg); err != nil {
+			return CompileResult{}, err
+		}
+	}
+
+	if opts.LabelFail != "" {
+		if err := validateLabelFail(opts.LabelFail); err != nil {
+			return CompileResult{}, err
+		}
+	}
+
+	if err := validateOpEmitters(opts.OpEmitters); err != nil {
+		return CompileResult{}, err
+	}
+
+	if err := validateArgs(opts.Args); err != nil {
+		return CompileResult{}, err
+	}
+
+	if err := validateMacros(opts.Macros); err != nil {
+		return CompileResult{}, err
+	}
+
+	// The comments are stripped before the textual passes, which must not
+	// expand the macros or substitute the values in them.
+	expr, err := stripComments(opts.Expr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse expression(%s): %w", expr, err)
+		return CompileResult{}, fmt.Errorf("failed to parse expression(%s): %w", opts.Expr, err)
 	}
 
-	if err := validate(ast); err != nil {
-		return nil, fmt.Errorf("failed to validate expression(%s): %w", expr, err)
+	expr, err = expandMacros(expr, opts.Macros)
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to expand macros of expression(%s): %w", opts.Expr, err)
+	}
+
+	expr, err = substituteValues(expr, opts.Values)
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to substitute values of expression(%s): %w", opts.Expr, err)
 	}
 
-	insns, err := compile(ast, typ)
+	expr, err = foldNamedCast(expr, &opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compile expression(%s): %w", expr, err)
+		return CompileResult{}, fmt.Errorf("failed to parse expression(%s): %w", opts.Expr, err)
+	}
+
+	ast, err := parseStripped(expr)
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to parse expression(%s): %w", opts.Expr, err)
+	}
+
+	var arms *ternaryArms
+	if ast.Op == cc.Cond {
+		cond, ternary, err := parseTernary(ast)
+		if err != nil {
+			return CompileResult{}, fmt.Errorf("failed to parse expression(%s): %w", opts.Expr, err)
+		}
+
+		ast, arms = cond, &ternary
+	}
+
+	ast = foldNot(ast)
+
+	var (
+		insns asm.Instructions
+		tgt   tgtInfo
+	)
+	if len(opts.Args) != 0 && isMemberAccess(ast.Right) {
+		if err := validateOperator(ast.Op); err != nil {
+			return CompileResult{}, fmt.Errorf("failed to validate expression(%s): %w", opts.Expr, err)
+		}
+
+		insns, err = compileArgs(ast, opts)
+	} else if isTuple(ast) {
+		insns, err = compileTuple(ast, opts)
+	} else {
+		if err := validate(ast); err != nil {
+			return CompileResult{}, fmt.Errorf("failed to validate expression(%s): %w", opts.Expr, err)
+		}
+
+		if arg, ok := opts.Args[rootName(ast.Left)]; ok {
+			// arg2->field == 1 reads the field from the argument.
+			argOpts := opts
+			argOpts.Type = arg.Type
+			insns, tgt, err = compileTarget(ast, argOpts)
+			insns = append(asm.Instructions{asm.Mov.Reg(asm.R1, arg.Reg)}, insns...) // r1 = arg
+		} else {
+			insns, tgt, err = compileTarget(ast, opts)
+		}
+	}
+	if err != nil {
+		return CompileResult{}, fmt.Errorf("failed to compile expression(%s): %w", opts.Expr, err)
+	}
+
+	// match is the verdict of the matched condition counted by CounterMap,
+	// which is inverted or selected by the ternary.
+	match := int32(1)
+	if opts.InvertVerdict {
+		insns = invertVerdict(insns)
+		match = 0
+	}
+
+	if arms != nil {
+		insns = selectVerdict(insns, arms.then, arms.els)
+		match = arms.then
+		if opts.InvertVerdict {
+			match = arms.els
+		}
+	}
+
+	if opts.ReadHelper != asm.FnUnspec {
+		insns = replaceReadHelper(insns, opts.ReadHelper)
+	}
+
+	if opts.CounterMap != "" {
+		insns, err = count2insns(insns, opts.CounterMap, match)
+		if err != nil {
+			return CompileResult{}, fmt.Errorf("failed to count matches of expression(%s): %w", opts.Expr, err)
+		}
+	}
+
+	if opts.CompareReg != 0 && clobbersReg(insns, opts.CompareReg) {
+		return CompileResult{}, fmt.Errorf("failed to compile expression(%s): compare register %s is clobbered by the compiled instructions", opts.Expr, opts.CompareReg)
+	}
+
+	if opts.VerdictReg != 0 {
+		insns, err = verdictToReg(insns, opts.VerdictReg)
+		if err != nil {
+			return CompileResult{}, fmt.Errorf("failed to put verdict of expression(%s) in %s: %w", opts.Expr, opts.VerdictReg, err)
+		}
+	}
+
+	if opts.RelativeJumps {
+		insns, err = resolveJumps(insns)
+		if err != nil {
+			return CompileResult{}, fmt.Errorf("failed to resolve jumps of expression(%s): %w", opts.Expr, err)
+		}
 	}
 
-	return insns, nil
+	return CompileResult{
+		Insns:    insns,
+		Operator: ast.Op,
+		Constant: tgt.constant,
+		desc:     describe(ast, opts),
+		csrc:     toC(ast, tgt.constant, arms, opts),
+	}, nil
 }
 
 // SimpleInjectFilter injects the simply compiled instructions into the given
@@ -8,6 +8,8 @@ import (
 
 	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
 
 	"github.com/leonhwangprojects/bice/internal/test"
 )
@@ -37,6 +39,21 @@ func TestSimpleCompile(t *testing.T) {
 		test.AssertEqualSlice(t, insns, cloneSkbLen1024InsnsWithoutExitLabel())
 	})
 
+	t.Run("skb->len > 80% of 1280", func(t *testing.T) {
+		insns, err := SimpleCompile("skb->len > 80% of 1280", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, cloneSkbLen1024InsnsWithoutExitLabel())
+	})
+
+	t.Run("sizeof(skb->dev) == 8", func(t *testing.T) {
+		insns, err := SimpleCompile("sizeof(skb->dev) == 8", getSkbBtf(t))
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
 	t.Run("(u64)skb != 0", func(t *testing.T) {
 		insns, err := SimpleCompile("skb != 0", getU64Btf(t))
 		test.AssertNoErr(t, err)
@@ -50,6 +67,68 @@ func TestSimpleCompile(t *testing.T) {
 	})
 }
 
+func TestCompileResult(t *testing.T) {
+	for _, tt := range []struct {
+		expr     string
+		op       cc.ExprOp
+		constant uint64
+	}{
+		{"skb->len > 1024", cc.Gt, 1024},
+		{"skb->protocol == 0x800", cc.EqEq, 0x0008},
+		{"(skb->mark & 0xff) = 1", cc.Eq, 1},
+		{"skb->skb_iif != -1", cc.NotEq, ^uint64(0)},
+		{"skb->dev->name == \"lo\"", cc.EqEq, 0},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			res, err := Compile(CompileOptions{Expr: tt.expr, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, res.Operator, tt.op)
+			test.AssertEqual(t, res.Constant, tt.constant)
+		})
+	}
+}
+
+func TestCompileRelativeJumps(t *testing.T) {
+	t.Run("skb->dev->ifindex == 1", func(t *testing.T) {
+		labeled, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 1", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		res, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 1", Type: getSkbBtf(t), RelativeJumps: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, len(res.Insns), len(labeled.Insns))
+
+		symbols := make(map[string]int)
+		for i, ins := range labeled.Insns {
+			if sym := ins.Symbol(); sym != "" {
+				symbols[sym] = i
+			}
+		}
+
+		jumps := 0
+		for i, ins := range res.Insns {
+			test.AssertEqual(t, ins.Symbol(), "")
+			test.AssertEqual(t, ins.Reference(), "")
+
+			if ref := labeled.Insns[i].Reference(); ref != "" && !ins.IsFunctionCall() {
+				test.AssertEqual(t, int(ins.Offset), symbols[ref]-i-1)
+				jumps++
+			}
+		}
+		test.AssertEqual(t, jumps, 2)
+	})
+
+	t.Run("dangling jump", func(t *testing.T) {
+		_, err := Compile(CompileOptions{
+			Expr:          "skb->dev->ifindex == 1",
+			Type:          getSkbBtf(t),
+			LabelFail:     "next_filter",
+			RelativeJumps: true,
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to resolve jumps of expression(skb->dev->ifindex == 1): insn")
+	})
+}
+
 func TestSimpleInjectFilter(t *testing.T) {
 	t.Run("empty options", func(t *testing.T) {
 		err := SimpleInjectFilter(InjectOptions{})
@@ -83,3 +162,43 @@ func TestSimpleInjectFilter(t *testing.T) {
 		test.AssertEqualSlice(t, prog.Instructions[4:], insns)
 	})
 }
+
+func BenchmarkCompile(b *testing.B) {
+	skb, err := testBtf.AnyTypeByName("sk_buff")
+	if err != nil {
+		b.Fatal(err)
+	}
+	typ := &btf.Pointer{Target: skb}
+
+	for _, bb := range []struct {
+		name string
+		expr string
+	}{
+		{"scalar", "skb->len > 1024"},
+		{"pointer chain", "skb->dev->nd_net.net->ns.inum == 0xf0000000"},
+		{"big endian", "skb->protocol == 0x0800"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Compile(CompileOptions{Expr: bb.expr, Type: typ}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	b.Run("compound", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := NewFilterBuilder(typ).
+				And("skb->protocol == 0x0800").
+				And("skb->len > 1024").
+				Or("skb->dev->ifindex == 9").
+				Build()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
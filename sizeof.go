@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// evalCompare evaluates the comparison of two constants at compile time.
+func evalCompare(op cc.ExprOp, left, right uint64, signed bool) (bool, error) {
+	l, r := int64(left), int64(right)
+
+	switch op {
+	case cc.Eq, cc.EqEq:
+		return left == right, nil
+	case cc.NotEq:
+		return left != right, nil
+	case cc.Lt:
+		if signed {
+			return l < r, nil
+		}
+		return left < right, nil
+	case cc.LtEq:
+		if signed {
+			return l <= r, nil
+		}
+		return left <= right, nil
+	case cc.Gt:
+		if signed {
+			return l > r, nil
+		}
+		return left > right, nil
+	case cc.GtEq:
+		if signed {
+			return l >= r, nil
+		}
+		return left >= right, nil
+	default:
+		return false, fmt.Errorf("unexpected operator: %s; must be one of =, ==, !=, <, <=, >, >=", op)
+	}
+}
+
+// sizeofMember resolves the size of the member access like sizeof(skb->len).
+func sizeofMember(expr *cc.Expr, typ btf.Type) (uint64, error) {
+	if expr.Op == cc.Paren {
+		expr = expr.Left
+	}
+
+	ast, err := expr2offset(expr, typ)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert expr to access offsets: %w", err)
+	}
+
+	if IsMemberBitfield(ast.member) {
+		return 0, fmt.Errorf("cannot get size of bitfield '%s'", ast.member.Name)
+	}
+
+	size, err := btf.Sizeof(ast.lastField)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s: %w", expr, err)
+	}
+
+	return uint64(size), nil
+}
+
+// compileSizeof compiles the expression like sizeof(skb->len) == 4, whose
+// verdict is determined at compile time.
+func compileSizeof(expr *cc.Expr, ri rightInfo, opts CompileOptions) (asm.Instructions, error) {
+	if err := ri.enum2const(nil); err != nil {
+		return nil, fmt.Errorf("failed to convert enum to constant: %w", err)
+	}
+
+	size, err := sizeofMember(expr.Left.Left, opts.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	match, err := evalCompare(expr.Op, size, ri.constant, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate sizeof: %w", err)
+	}
+
+	return verdict2insns(match), nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestEvalCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     cc.ExprOp
+		left   uint64
+		right  uint64
+		signed bool
+		match  bool
+	}{
+		{name: "==", op: cc.EqEq, left: 4, right: 4, match: true},
+		{name: "=", op: cc.Eq, left: 4, right: 2, match: false},
+		{name: "!=", op: cc.NotEq, left: 4, right: 2, match: true},
+		{name: "<", op: cc.Lt, left: 2, right: 4, match: true},
+		{name: "<=", op: cc.LtEq, left: 4, right: 4, match: true},
+		{name: ">", op: cc.Gt, left: 2, right: 4, match: false},
+		{name: ">=", op: cc.GtEq, left: 8, right: 4, match: true},
+		{name: "unsigned <", op: cc.Lt, left: ^uint64(0), right: 1, match: false},
+		{name: "signed <", op: cc.Lt, left: ^uint64(0), right: 1, signed: true, match: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := evalCompare(tt.op, tt.left, tt.right, tt.signed)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, match, tt.match)
+		})
+	}
+
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := evalCompare(cc.Add, 1, 1, false)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected operator")
+	})
+}
+
+func TestSizeofMember(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		size uint64
+	}{
+		{name: "u32", expr: "sizeof(skb->len)", size: 4},
+		{name: "__be16", expr: "sizeof(skb->protocol)", size: 2},
+		{name: "pointer", expr: "sizeof(skb->dev)", size: 8},
+		{name: "nested", expr: "sizeof(skb->dev->ifindex)", size: 4},
+		{name: "array", expr: "sizeof(skb->cb)", size: 48},
+		{name: "array element", expr: "sizeof(skb->cb[1])", size: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			size, err := sizeofMember(expr.Left, getSkbBtf(t))
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, size, tt.size)
+		})
+	}
+
+	t.Run("bitfield", func(t *testing.T) {
+		expr, err := parse("sizeof(skb->pkt_type)")
+		test.AssertNoErr(t, err)
+
+		_, err = sizeofMember(expr.Left, getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "cannot get size of bitfield")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		expr, err := parse("sizeof(skb->xxx)")
+		test.AssertNoErr(t, err)
+
+		_, err = sizeofMember(expr.Left, getSkbBtf(t))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to convert expr to access offsets")
+	})
+}
+
+func TestCompileSizeof(t *testing.T) {
+	t.Run("match", func(t *testing.T) {
+		expr, err := parse("sizeof(skb->len) == 4")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		expr, err := parse("sizeof(skb->protocol) > 2")
+		test.AssertNoErr(t, err)
+
+		insns, err := compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("enum", func(t *testing.T) {
+		expr, err := parse("sizeof(skb->len) == BPF_PROG_TYPE_XDP")
+		test.AssertNoErr(t, err)
+
+		_, err = compile(expr, CompileOptions{Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to convert enum to constant")
+	})
+}
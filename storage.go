@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/cilium/ebpf/asm"
+)
+
+// SkStorageOptions is the options to look up the root of the expression from
+// the socket-local storage, i.e. BPF_MAP_TYPE_SK_STORAGE map.
+type SkStorageOptions struct {
+	// Map is the name of the sk storage map, which is referenced by the
+	// instruction loading the map pointer and resolved when loading the
+	// program.
+	Map string
+
+	// Sock is the register of the socket pointer. It's r1 if not set.
+	Sock asm.Register
+}
+
+// skStorage2insns looks up the sk storage with bpf_sk_storage_get(), and
+// stores the storage pointer to r1 as the root. It jumps to labelExit if the
+// storage is not found.
+func skStorage2insns(insns asm.Instructions, opts *SkStorageOptions, labelExit string) asm.Instructions {
+	sock := opts.Sock
+	if sock == asm.R0 {
+		sock = asm.R1
+	}
+
+	if sock != asm.R2 {
+		insns = append(insns,
+			asm.Mov.Reg(asm.R2, sock), // r2 = sock
+		)
+	}
+
+	return append(insns,
+		asm.LoadMapPtr(asm.R1, 0).WithReference(opts.Map), // r1 = map
+		asm.Mov.Imm(asm.R3, 0),                            // r3 = NULL
+		asm.Mov.Imm(asm.R4, 0),                            // r4 = 0; flags
+		asm.FnSkStorageGet.Call(),                         // r0 = bpf_sk_storage_get(r1, r2, r3, r4)
+		asm.JEq.Imm(asm.R0, 0, labelExit),                 // if r0 == 0, goto __exit
+		asm.Mov.Reg(asm.R1, asm.R0),                       // r1 = r0
+	)
+}
@@ -0,0 +1,55 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestSkStorage2insns(t *testing.T) {
+	t.Run("default sock register", func(t *testing.T) {
+		insns := skStorage2insns(nil, &SkStorageOptions{Map: "sk_stg"}, labelExitFail)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R2, asm.R1),
+			asm.LoadMapPtr(asm.R1, 0).WithReference("sk_stg"),
+			asm.Mov.Imm(asm.R3, 0),
+			asm.Mov.Imm(asm.R4, 0),
+			asm.FnSkStorageGet.Call(),
+			asm.JEq.Imm(asm.R0, 0, labelExitFail),
+			asm.Mov.Reg(asm.R1, asm.R0),
+		})
+		test.AssertEqual(t, insns[1].Reference(), "sk_stg")
+	})
+
+	t.Run("sock in r2", func(t *testing.T) {
+		insns := skStorage2insns(nil, &SkStorageOptions{Map: "sk_stg", Sock: asm.R2}, labelExitFail)
+		test.AssertEqualSlice(t, insns[:1], asm.Instructions{
+			asm.LoadMapPtr(asm.R1, 0).WithReference("sk_stg"),
+		})
+	})
+
+	t.Run("sock in r6", func(t *testing.T) {
+		insns := skStorage2insns(nil, &SkStorageOptions{Map: "sk_stg", Sock: asm.R6}, labelExitFail)
+		test.AssertEqualSlice(t, insns[:1], asm.Instructions{
+			asm.Mov.Reg(asm.R2, asm.R6),
+		})
+	})
+}
+
+func TestCompileSkStorage(t *testing.T) {
+	res, err := Compile(CompileOptions{
+		Expr:      "val->len > 1024",
+		Type:      getSkbBtf(t),
+		SkStorage: &SkStorageOptions{Map: "sk_stg"},
+	})
+	test.AssertNoErr(t, err)
+
+	want := append(skStorage2insns(nil, &SkStorageOptions{Map: "sk_stg"}, labelExitFail), skbLen1024Insns...)
+	test.AssertEqualSlice(t, res.Insns, want)
+	test.AssertEqual(t, res.Insns[len(res.Insns)-2].Symbol(), labelExitFail)
+}
@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/Asphaltt/mybtf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// maxStrSize is the max size of bytes to compare in string comparison, which
+// are read to stack.
+const maxStrSize = 256
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isOctDigit(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+// unescapeString unescapes the C escape sequences of the string literal
+// without quotes. The hex escape \xHH takes at most 2 hex digits, and the octal
+// escape \OOO takes at most 3 octal digits. The universal character names \uXXXX
+// and \UXXXXXXXX are encoded in UTF-8.
+func unescapeString(s string) ([]byte, error) {
+	buf := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			buf = append(buf, c)
+			continue
+		}
+
+		i++
+		if i == len(s) {
+			return nil, fmt.Errorf("unterminated escape sequence at end of \"%s\"", s)
+		}
+
+		switch c = s[i]; c {
+		case 'a':
+			buf = append(buf, '\a')
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'v':
+			buf = append(buf, '\v')
+		case '\\', '\'', '"', '?':
+			buf = append(buf, c)
+
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("invalid hex escape sequence \\x in \"%s\"", s)
+			}
+
+			v, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			buf = append(buf, byte(v))
+			i = j - 1
+
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && isOctDigit(s[j]) {
+				j++
+			}
+
+			v, _ := strconv.ParseUint(s[i:j], 8, 16)
+			if v > 0xFF {
+				return nil, fmt.Errorf("octal escape sequence \\%s out of range in \"%s\"", s[i:j], s)
+			}
+			buf = append(buf, byte(v))
+			i = j - 1
+
+		case 'u', 'U':
+			n := 4
+			if c == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return nil, fmt.Errorf("invalid unicode escape sequence \\%s in \"%s\"", s[i:], s)
+			}
+
+			v, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(v)) {
+				return nil, fmt.Errorf("invalid unicode escape sequence \\%s in \"%s\"", s[i:i+1+n], s)
+			}
+			buf = utf8.AppendRune(buf, rune(v))
+			i += n
+
+		default:
+			return nil, fmt.Errorf("invalid escape sequence \\%c in \"%s\"", c, s)
+		}
+	}
+
+	return buf, nil
+}
+
+// unquoteString unquotes and concatenates the C string literals, like
+// "foo" "bar".
+func unquoteString(texts []string) ([]byte, error) {
+	var buf []byte
+	for _, text := range texts {
+		if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+			return nil, fmt.Errorf("unexpected string literal %s", text)
+		}
+
+		b, err := unescapeString(text[1 : len(text)-1])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+
+	return buf, nil
+}
+
+// memcmp2insns compares the bytes at base+off, e.g. on stack at r10+off, with
+// the data in the target byte order, and jumps to labelMismatch if any byte
+// differs.
+func memcmp2insns(insns asm.Instructions, data []byte, order binary.ByteOrder, base asm.Register, off int16, labelMismatch string) asm.Instructions {
+	for len(data) > 0 {
+		switch {
+		case len(data) >= 8:
+			insns = append(insns,
+				asm.LoadMem(asm.R3, base, off, asm.DWord),                 // r3 = *(u64 *)(base + off)
+				asm.LoadImm(asm.R2, int64(order.Uint64(data)), asm.DWord), // r2 = data
+				asm.JNE.Reg(asm.R3, asm.R2, labelMismatch),                // if r3 != r2, goto mismatch
+			)
+			data, off = data[8:], off+8
+
+		case len(data) >= 4:
+			insns = append(insns,
+				asm.LoadMem(asm.R3, base, off, asm.Word),                        // r3 = *(u32 *)(base + off)
+				asm.JNE.Imm32(asm.R3, int32(order.Uint32(data)), labelMismatch), // if w3 != data, goto mismatch
+			)
+			data, off = data[4:], off+4
+
+		case len(data) >= 2:
+			insns = append(insns,
+				asm.LoadMem(asm.R3, base, off, asm.Half),                      // r3 = *(u16 *)(base + off)
+				asm.JNE.Imm(asm.R3, int32(order.Uint16(data)), labelMismatch), // if r3 != data, goto mismatch
+			)
+			data, off = data[2:], off+2
+
+		default:
+			insns = append(insns,
+				asm.LoadMem(asm.R3, base, off, asm.Byte),           // r3 = *(u8 *)(base + off)
+				asm.JNE.Imm(asm.R3, int32(data[0]), labelMismatch), // if r3 != data, goto mismatch
+			)
+			data, off = data[1:], off+1
+		}
+	}
+
+	return insns
+}
+
+// compileString compiles the string comparison like dev->name == "lo", whose
+// left operand is a char array or a const char pointer. The literal is compared
+// with its terminating NUL, except it fills the whole char array. With !=, it
+// matches if any byte differs.
+func compileString(expr *cc.Expr, opts CompileOptions) (asm.Instructions, error) {
+	if expr.Op != cc.Eq && expr.Op != cc.EqEq && expr.Op != cc.NotEq {
+		return nil, fmt.Errorf("unexpected operator %s of string comparison; must be =, == or !=", expr.Op)
+	}
+
+	str, err := unquoteString(expr.Right.Texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unquote string literal: %w", err)
+	}
+
+	ast, err := expr2offsetWithLookup(expr.Left, opts.Type, opts.typeLookup(), opts.fieldAliases())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert expr to access offsets: %w", err)
+	}
+
+	isStr := mybtf.IsConstCharPtr(ast.lastField)
+	isArr := mybtf.IsCharArray(ast.lastField)
+	if !isStr && !isArr {
+		return nil, fmt.Errorf("unexpected type %s of string comparison; must be char array or const char pointer", ast.lastField)
+	}
+
+	data := append(str, 0)
+	if isArr {
+		arr := mybtf.UnderlyingType(ast.lastField).(*btf.Array)
+		if len(str) > int(arr.Nelems) {
+			return nil, fmt.Errorf("string literal of %d bytes is longer than %s", len(str), ast.lastField)
+		}
+		if len(str) == int(arr.Nelems) {
+			data = str
+		}
+	}
+	if len(data) > maxStrSize {
+		return nil, fmt.Errorf("string literal of %d bytes is longer than %d", len(data), maxStrSize)
+	}
+
+	var insns asm.Instructions
+	insns = append(insns,
+		asm.Mov.Reg(asm.R3, asm.R1), // r3 = r1
+	)
+
+	// r3 is the address of the char array, or the value of the char pointer.
+	labelFail := opts.failLabel()
+	insns, _ = offset2insns(insns, ast.offsets, asm.R3, labelFail, isArr)
+	userRead2insns(insns, ast.userReads)
+	if opts.Annotate {
+		annotateReads(insns, ast.paths)
+	}
+
+	bufSize := (len(data) + 7) / 8 * 8
+	base, off := asm.R10, -8-int16(bufSize)
+	useScratch := opts.StackBudget > 0 && bufSize > opts.StackBudget
+	if useScratch {
+		if opts.ScratchMap == "" {
+			return nil, fmt.Errorf("buffer of %d bytes exceeds stack budget %d without scratch map", bufSize, opts.StackBudget)
+		}
+
+		insns = scratch2insns(insns, opts.ScratchMap, labelFail)
+		base, off = scratchReg, 0
+	} else {
+		insns = append(insns,
+			asm.Mov.Reg(asm.R1, asm.R10),    // r1 = r10
+			asm.Add.Imm(asm.R1, int32(off)), // r1 = r10 + off
+		)
+	}
+
+	insns = append(insns,
+		asm.Mov.Imm(asm.R2, int32(len(data))), // r2 = size
+		asm.FnProbeReadKernel.Call(),          // bpf_probe_read_kernel(r1, size, r3)
+		asm.JNE.Imm(asm.R0, 0, labelFail),     // if r0 != 0, goto fail
+	)
+	if useScratch {
+		insns = append(insns,
+			asm.LoadMem(scratchReg, asm.R10, stackOffsetScratch, asm.DWord), // r4 = *(r10 - 24)
+		)
+	}
+
+	if expr.Op == cc.NotEq {
+		// Any mismatch returns 1, and the equal string falls through to
+		// return 0.
+		insns = append(insns,
+			asm.Mov.Imm(asm.R0, 1), // r0 = 1
+		)
+		insns = memcmp2insns(insns, data, opts.byteOrder(), base, off, labelReturn)
+
+		xorR0 := asm.Xor.Reg(asm.R0, asm.R0)
+		if labelFail == labelExitFail {
+			xorR0 = xorR0.WithSymbol(labelExitFail)
+		}
+		insns = append(insns,
+			xorR0,                                // r0 = 0
+			asm.Return().WithSymbol(labelReturn), // return; __return
+		)
+
+		return insns, nil
+	}
+
+	insns = memcmp2insns(insns, data, opts.byteOrder(), base, off, labelExitFail)
+
+	insns = append(insns,
+		asm.Mov.Imm(asm.R0, 1),                                // r0 = 1
+		asm.Ja.Label(labelReturn),                             // goto __return
+		asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail), // r0 = 0; __exit
+		asm.Return().WithSymbol(labelReturn),                  // return; __return
+	)
+
+	return insns, nil
+}
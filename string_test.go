@@ -0,0 +1,270 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestUnescapeString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		exp  string
+	}{
+		{name: "plain", s: `lo`, exp: "lo"},
+		{name: "simple escapes", s: `a\tb\n\\`, exp: "a\tb\n\\"},
+		{name: "hex", s: `\x41\x42C`, exp: "ABC"},
+		{name: "hex at most 2 digits", s: `\x414`, exp: "A4"},
+		{name: "escaped quote", s: `say \"hi\"`, exp: `say "hi"`},
+		{name: "octal", s: `\101\60`, exp: "A0"},
+		{name: "embedded nul", s: `ab\0cd`, exp: "ab\x00cd"},
+		{name: "unicode", s: `caf\u00e9 \U0001F600`, exp: "café \U0001F600"},
+		{name: "utf-8", s: `café`, exp: "café"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unescapeString(tt.s)
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, string(got), tt.exp)
+		})
+	}
+
+	invalids := []struct {
+		name string
+		s    string
+		err  string
+	}{
+		{name: "unknown escape", s: `\q`, err: `invalid escape sequence \q`},
+		{name: "hex without digits", s: `\xZZ`, err: `invalid hex escape sequence \x`},
+		{name: "trailing backslash", s: `abc\`, err: "unterminated escape sequence"},
+		{name: "octal out of range", s: `\777`, err: `octal escape sequence \777 out of range`},
+		{name: "short unicode", s: `\u12`, err: "invalid unicode escape sequence"},
+		{name: "invalid unicode", s: `\UFFFFFFFF`, err: "invalid unicode escape sequence"},
+	}
+
+	for _, tt := range invalids {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unescapeString(tt.s)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestUnquoteString(t *testing.T) {
+	t.Run("concatenated", func(t *testing.T) {
+		got, err := unquoteString([]string{`"a"`, `"b\t"`})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, string(got), "ab\t")
+	})
+
+	t.Run("not quoted", func(t *testing.T) {
+		_, err := unquoteString([]string{`a`})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected string literal")
+	})
+}
+
+func TestMemcmp2insns(t *testing.T) {
+	data := []byte("0123456789abcde")
+	insns := memcmp2insns(nil, data, nativeEndian, asm.R10, -24, labelExitFail)
+	test.AssertEqualSlice(t, insns, asm.Instructions{
+		asm.LoadMem(asm.R3, asm.R10, -24, asm.DWord),
+		asm.LoadImm(asm.R2, int64(nativeEndian.Uint64(data[:8])), asm.DWord),
+		asm.JNE.Reg(asm.R3, asm.R2, labelExitFail),
+		asm.LoadMem(asm.R3, asm.R10, -16, asm.Word),
+		asm.JNE.Imm32(asm.R3, int32(nativeEndian.Uint32(data[8:12])), labelExitFail),
+		asm.LoadMem(asm.R3, asm.R10, -12, asm.Half),
+		asm.JNE.Imm(asm.R3, int32(nativeEndian.Uint16(data[12:14])), labelExitFail),
+		asm.LoadMem(asm.R3, asm.R10, -10, asm.Byte),
+		asm.JNE.Imm(asm.R3, 'e', labelExitFail),
+	})
+}
+
+// runStringCompare emulates the string comparison of a short literal after
+// reading the char array as name to the stack, and returns whether it matches.
+func runStringCompare(t *testing.T, insns asm.Instructions, name string) bool {
+	t.Helper()
+
+	var stack [16]byte // r10-16 ~ r10, where the short literal is read to
+	copy(stack[:], name)
+	load := func(off int16, size asm.Size) uint64 {
+		b := stack[16+int(off):]
+		switch size {
+		case asm.DWord:
+			return nativeEndian.Uint64(b)
+		case asm.Word:
+			return uint64(nativeEndian.Uint32(b))
+		case asm.Half:
+			return uint64(nativeEndian.Uint16(b))
+		default:
+			return uint64(b[0])
+		}
+	}
+
+	start := 0
+	for i, ins := range insns {
+		if ins.IsBuiltinCall() {
+			start = i + 2 // skip the check of r0
+		}
+	}
+
+	var r0, r2, r3 uint64
+	for i := start; i < len(insns); i++ {
+		ins := insns[i]
+		switch {
+		case ins.OpCode.ALUOp() == asm.Mov:
+			r0 = uint64(ins.Constant)
+		case ins.OpCode.ALUOp() == asm.Xor:
+			r0 = 0
+		case ins.OpCode.IsDWordLoad():
+			r2 = uint64(ins.Constant)
+		case ins.OpCode.Class().IsLoad():
+			r3 = load(ins.Offset, ins.OpCode.Size())
+		case ins.OpCode.JumpOp() == asm.JNE:
+			other := uint64(ins.Constant)
+			if ins.OpCode.Source() == asm.RegSource {
+				other = r2
+			}
+			if r3 != other {
+				if ins.Reference() == labelReturn {
+					return r0 == 1
+				}
+				return false // mismatch of ==
+			}
+		case ins.OpCode.JumpOp() == asm.Ja, ins.OpCode.JumpOp() == asm.Exit:
+			return r0 == 1
+		default:
+			t.Fatalf("unexpected instruction %v", ins)
+		}
+	}
+
+	return r0 == 1
+}
+
+func TestCompileString(t *testing.T) {
+	t.Run(`skb->dev->name == "lo"`, func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: `skb->dev->name == "lo"`, Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 16),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Add.Imm(asm.R3, 304),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -16),
+			asm.Mov.Imm(asm.R2, 3),
+			asm.FnProbeReadKernel.Call(),
+			asm.JNE.Imm(asm.R0, 0, labelExitFail),
+			asm.LoadMem(asm.R3, asm.R10, -16, asm.Half),
+			asm.JNE.Imm(asm.R3, int32(nativeEndian.Uint16([]byte("lo"))), labelExitFail),
+			asm.LoadMem(asm.R3, asm.R10, -14, asm.Byte),
+			asm.JNE.Imm(asm.R3, 0, labelExitFail),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Ja.Label(labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run(`skb->dev->name != "lo"`, func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: `skb->dev->name != "lo"`, Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[13:], asm.Instructions{
+			asm.JNE.Imm(asm.R0, 0, labelExitFail),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.LoadMem(asm.R3, asm.R10, -16, asm.Half),
+			asm.JNE.Imm(asm.R3, int32(nativeEndian.Uint16([]byte("lo"))), labelReturn),
+			asm.LoadMem(asm.R3, asm.R10, -14, asm.Byte),
+			asm.JNE.Imm(asm.R3, 0, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("equal and unequal", func(t *testing.T) {
+		for _, tt := range []struct {
+			op    string
+			name  string
+			match bool
+		}{
+			{"==", "lo", true},
+			{"==", "lo0", false},
+			{"==", "l", false},
+			{"!=", "lo", false},
+			{"!=", "lo0", true},
+			{"!=", "eth0", true},
+		} {
+			res, err := Compile(CompileOptions{Expr: `skb->dev->name ` + tt.op + ` "lo"`, Type: getSkbBtf(t)})
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, runStringCompare(t, res.Insns, tt.name), tt.match)
+		}
+	})
+
+	t.Run("!= with fail label", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: `skb->dev->name != "lo"`, Type: getSkbBtf(t), LabelFail: "next"})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-2:], asm.Instructions{
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run(`kobj->name == "a\x41\"b"`, func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: `kobj->name == "a\x41\"b"`, Type: getKobjBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[6:13], asm.Instructions{
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -16),
+			asm.Mov.Imm(asm.R2, 5),
+			asm.FnProbeReadKernel.Call(),
+			asm.JNE.Imm(asm.R0, 0, labelExitFail),
+			asm.LoadMem(asm.R3, asm.R10, -16, asm.Word),
+			asm.JNE.Imm32(asm.R3, int32(nativeEndian.Uint32([]byte(`aA"b`))), labelExitFail),
+		})
+	})
+
+	t.Run("fixed length with embedded nul", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: `skb->dev->name == "eth\0\0\0\0\0\0\0\0\0\0\0\0\0"`, Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[9:12], asm.Instructions{
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -24),
+			asm.Mov.Imm(asm.R2, 16),
+		})
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: `skb->dev->name == "0123456789abcdefg"`, Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("not string", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: `skb->len == "lo"`, Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: `skb->dev->name < "lo"`, Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+	})
+
+	t.Run("invalid escape", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: `skb->dev->name == "\q"`, Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+
+	"rsc.io/c2go/cc"
+)
+
+// ternaryArms is the constant arms of the top-level ternary like
+// skb->len > 1500 ? 2 : 1, which are the verdicts if the condition matches or
+// not.
+type ternaryArms struct {
+	then, els int32
+}
+
+// parseTernary splits the top-level ternary into the condition and the
+// constant arms.
+func parseTernary(expr *cc.Expr) (*cc.Expr, ternaryArms, error) {
+	var arms ternaryArms
+
+	if len(expr.List) != 3 {
+		return nil, arms, fmt.Errorf("unexpected ternary %v; must be cond ? then : else", expr)
+	}
+
+	then, err := parseArm(expr.List[1])
+	if err != nil {
+		return nil, arms, fmt.Errorf("failed to parse then arm: %w", err)
+	}
+
+	els, err := parseArm(expr.List[2])
+	if err != nil {
+		return nil, arms, fmt.Errorf("failed to parse else arm: %w", err)
+	}
+
+	arms.then, arms.els = then, els
+	return expr.List[0], arms, nil
+}
+
+func parseArm(arm *cc.Expr) (int32, error) {
+	for arm.Op == cc.Paren {
+		arm = arm.Left
+	}
+
+	if arm.Op != cc.Number {
+		return 0, fmt.Errorf("unexpected arm %v; must be constant number", arm)
+	}
+
+	n, err := parseNumber(arm.Text)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("arm %d is too large; must be no more than %d", n, math.MaxInt32)
+	}
+
+	return int32(n), nil
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestParseTernary(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		expr, err := parse("skb->len > 1500 ? 2 : (0x10)")
+		test.AssertNoErr(t, err)
+
+		cond, arms, err := parseTernary(expr)
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, cond.String(), "skb->len > 1500")
+		test.AssertEqual(t, arms, ternaryArms{then: 2, els: 16})
+	})
+
+	for _, tt := range []struct {
+		expr string
+		err  string
+	}{
+		{"skb->len > 1500 ? skb->len : 1", "failed to parse then arm"},
+		{"skb->len > 1500 ? 2 : 0x100000000", "failed to parse else arm: arm 4294967296 is too large"},
+		{"skb->len > 1500 ? 2 : 0b12", "failed to parse else arm"},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			_, _, err = parseTernary(expr)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestCompileTernary(t *testing.T) {
+	t.Run("skb->len > 1024 ? 2 : 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024 ? 2 : 1", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		want := cloneSkbLen1024InsnsWithoutExitLabel()
+		want[len(want)-4] = asm.Mov.Imm(asm.R0, 2)
+		want[len(want)-2] = asm.Mov.Imm(asm.R0, 1)
+		test.AssertEqualSlice(t, res.Insns, want)
+	})
+
+	t.Run("keep exit label", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->dev->ifindex == 9 ? 7 : 3", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[len(res.Insns)-2:], asm.Instructions{
+			asm.Mov.Imm(asm.R0, 3).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("constant verdict", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "sizeof(skb->len) == 4 ? 5 : 6", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 5),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("invalid arm", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len > 1024 ? x : 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to parse expression")
+	})
+
+	t.Run("invalid condition", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len ? 2 : 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
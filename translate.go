@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+const (
+	translateFunc = "map"
+
+	// labelTranslate is the prefix of the labels of the translation chain.
+	labelTranslate = "__map_bice_filter"
+)
+
+// translateRegexp matches the translation table like map(field, {1:A, 6:B}),
+// whose braces cannot be parsed by cc.
+var translateRegexp = regexp.MustCompile(`\b` + translateFunc + `\(([^{}]+?),\s*\{([^{}]*)\}\s*\)`)
+
+// foldTranslate rewrites the translation table to the call with flattened
+// pairs like map(field, 1, A, 6, B).
+func foldTranslate(expr string) (string, error) {
+	var err error
+	expr = translateRegexp.ReplaceAllStringFunc(expr, func(s string) string {
+		m := translateRegexp.FindStringSubmatch(s)
+
+		var sb strings.Builder
+		sb.WriteString(translateFunc + "(" + m[1])
+		for _, pair := range strings.Split(m[2], ",") {
+			key, value, ok := strings.Cut(pair, ":")
+			if !ok {
+				err = fmt.Errorf("unexpected pair '%s' of %s(); must be key:value", strings.TrimSpace(pair), translateFunc)
+				return s
+			}
+			sb.WriteString(", " + strings.TrimSpace(key) + ", " + strings.TrimSpace(value))
+		}
+		sb.WriteString(")")
+		return sb.String()
+	})
+
+	return expr, err
+}
+
+type translation struct {
+	key, value uint64
+}
+
+func isTranslate(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Call && expr.Left != nil &&
+		expr.Left.Op == cc.Name && expr.Left.Text == translateFunc
+}
+
+func validateTranslate(call *cc.Expr) error {
+	if len(call.List) < 3 || len(call.List)%2 == 0 {
+		return fmt.Errorf("%s() expects a field and key:value pairs, got %d arguments", translateFunc, len(call.List))
+	}
+
+	for _, arg := range call.List[1:] {
+		if arg.Op != cc.Number && arg.Op != cc.Name {
+			return fmt.Errorf("unexpected key or value %v of %s(); must be number or name", arg, translateFunc)
+		}
+	}
+
+	return validateLeftOperand(call.List[0])
+}
+
+// parseTranslate parses the key:value pairs of the translation table, whose
+// names are looked up in constants.
+func parseTranslate(call *cc.Expr, constants map[string]uint64) ([]translation, error) {
+	parse := func(arg *cc.Expr) (uint64, error) {
+		var (
+			v   uint64
+			err error
+		)
+		if arg.Op == cc.Name {
+			var ok bool
+			if v, ok = constants[arg.Text]; !ok {
+				return 0, fmt.Errorf("unknown constant %s of %s()", arg.Text, translateFunc)
+			}
+		} else if v, err = parseNumber(arg.Text); err != nil {
+			return 0, fmt.Errorf("failed to parse %s of %s(): %w", arg.Text, translateFunc, err)
+		}
+
+		if v > math.MaxInt32 {
+			return 0, fmt.Errorf("%s of %s() is too large; must be at most %d", arg.Text, translateFunc, math.MaxInt32)
+		}
+		return v, nil
+	}
+
+	table := make([]translation, 0, len(call.List)/2)
+	for i := 1; i < len(call.List); i += 2 {
+		key, err := parse(call.List[i])
+		if err != nil {
+			return nil, err
+		}
+		value, err := parse(call.List[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		table = append(table, translation{key: key, value: value})
+	}
+
+	return table, nil
+}
+
+// translate2insns translates reg through the table in place by a chain of
+// conditional moves, and the value not in the table is translated to 0. R2 is
+// used as scratch register.
+func translate2insns(insns asm.Instructions, table []translation, reg asm.Register) asm.Instructions {
+	insns = append(insns,
+		asm.Mov.Imm(asm.R2, 0), // r2 = 0
+	)
+
+	for i, t := range table {
+		next := setLabel(labelTranslate, i)
+		jmp := asm.JNE.Imm(reg, int32(t.key), next) // if reg != key, goto next
+		if i != 0 {
+			jmp = jmp.WithSymbol(setLabel(labelTranslate, i-1))
+		}
+		insns = append(insns,
+			jmp,
+			asm.Mov.Imm(asm.R2, int32(t.value)), // r2 = value
+		)
+	}
+
+	return append(insns,
+		asm.Mov.Reg(reg, asm.R2).WithSymbol(setLabel(labelTranslate, len(table)-1)), // reg = r2
+	)
+}
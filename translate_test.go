@@ -0,0 +1,112 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestFoldTranslate(t *testing.T) {
+	t.Run("table", func(t *testing.T) {
+		expr, err := foldTranslate("map(iph->protocol, {1:A, 6 : B, 17:C}) == 2")
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, expr, "map(iph->protocol, 1, A, 6, B, 17, C) == 2")
+	})
+
+	t.Run("no table", func(t *testing.T) {
+		expr, err := foldTranslate("skb->len > 1024")
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, expr, "skb->len > 1024")
+	})
+
+	t.Run("invalid pair", func(t *testing.T) {
+		_, err := foldTranslate("map(iph->protocol, {1:A, 6}) == 2")
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected pair '6' of map()")
+	})
+}
+
+func TestTranslate2insns(t *testing.T) {
+	table := []translation{{1, 10}, {6, 20}, {17, 30}}
+	for _, tt := range []struct {
+		v, exp uint64
+	}{
+		{1, 10},
+		{6, 20},
+		{17, 30},
+		{0, 0},
+		{255, 0},
+	} {
+		insns := asm.Instructions{asm.Mov.Imm(asm.R3, int32(tt.v))}
+		insns = translate2insns(insns, table, asm.R3)
+		insns = append(insns,
+			asm.Mov.Reg(asm.R0, asm.R3),
+			asm.Return(),
+		)
+
+		r0, _ := runCombination(t, insns)
+		test.AssertEqual(t, r0, tt.exp)
+	}
+}
+
+func TestCompileTranslate(t *testing.T) {
+	t.Run("map(skb->mark, {1:A, 6:B, 17:C}) == 2", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:      "map(skb->mark, {1:A, 6:B, 17:C}) == 2",
+			Type:      getSkbBtf(t),
+			Constants: map[string]uint64{"A": 1, "B": 2, "C": 3},
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-12:], asm.Instructions{
+			asm.Mov.Imm(asm.R2, 0),
+			asm.JNE.Imm(asm.R3, 1, labelTranslate+"_0"),
+			asm.Mov.Imm(asm.R2, 1),
+			asm.JNE.Imm(asm.R3, 6, labelTranslate+"_1").WithSymbol(labelTranslate + "_0"),
+			asm.Mov.Imm(asm.R2, 2),
+			asm.JNE.Imm(asm.R3, 17, labelTranslate+"_2").WithSymbol(labelTranslate + "_1"),
+			asm.Mov.Imm(asm.R2, 3),
+			asm.Mov.Reg(asm.R3, asm.R2).WithSymbol(labelTranslate + "_2"),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 2, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("unknown constant", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "map(skb->mark, {1:A}) == 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "map(skb->mark, 1) == 1", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+
+	t.Run("in set", func(t *testing.T) {
+		res, err := CompileSet(CompileSetOptions{
+			Exprs:   []string{"map(skb->mark, {1:2}) == 2", "map(skb->len, {1:2}) == 2"},
+			Options: CompileOptions{Type: getSkbBtf(t)},
+		})
+		test.AssertNoErr(t, err)
+
+		symbols := map[string]bool{}
+		for _, ins := range res.Insns {
+			if sym := ins.Symbol(); sym != "" {
+				test.AssertFalse(t, symbols[sym])
+				symbols[sym] = true
+			}
+		}
+		test.AssertTrue(t, symbols[labelTranslate+"_0_0"])
+		test.AssertTrue(t, symbols[labelTranslate+"_0_1"])
+	})
+}
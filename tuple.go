@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// ctxSaveReg is the callee-saved register to keep the root pointer across
+// the compiled comparisons, as R1 is clobbered by the read helper.
+const ctxSaveReg = asm.R6
+
+// tupleList returns the elements of the tuple like (a, b), or nil if it's not
+// a tuple.
+func tupleList(expr *cc.Expr) []*cc.Expr {
+	if expr == nil || expr.Op != cc.Paren || expr.Left == nil || expr.Left.Op != cc.Comma {
+		return nil
+	}
+
+	return expr.Left.List
+}
+
+func isTuple(expr *cc.Expr) bool {
+	return expr != nil && tupleList(expr.Left) != nil
+}
+
+// chainEqual turns the compiled equality of a tuple element into a step, which
+// falls through if matched and jumps to __exit_bice_filter if not.
+func chainEqual(insns asm.Instructions) (asm.Instructions, error) {
+	n := len(insns)
+	if n < 4 {
+		return nil, fmt.Errorf("unexpected instructions of tuple element")
+	}
+
+	mov, jmp := insns[n-4], insns[n-3]
+	if mov.OpCode != asm.Mov.Op(asm.ImmSource) || mov.Dst != asm.R0 || mov.Constant != 1 ||
+		!jmp.OpCode.Class().IsJump() || jmp.Reference() != labelReturn {
+		return nil, fmt.Errorf("unexpected instructions of tuple element")
+	}
+
+	switch jmp.OpCode.JumpOp() {
+	case asm.Ja:
+		// The mismatches of string have jumped to __exit_bice_filter.
+		return insns[:n-4], nil
+
+	case asm.JEq:
+		jmp.OpCode = jmp.OpCode.SetJumpOp(asm.JNE)
+		return append(insns[:n-4], jmp.WithReference(labelExitFail)), nil
+
+	default:
+		return nil, fmt.Errorf("unexpected jump %v of tuple element", jmp)
+	}
+}
+
+// compileTuple compiles the tuple comparison like (iph->saddr, iph->daddr) ==
+// (10.0.0.1, 10.0.0.2), which reads every field once and matches if all of
+// them are equal to the constants.
+func compileTuple(expr *cc.Expr, opts CompileOptions) (asm.Instructions, error) {
+	if expr.Op != cc.Eq && expr.Op != cc.EqEq {
+		return nil, fmt.Errorf("unexpected operator %s of tuple comparison; must be = or ==", expr.Op)
+	}
+
+	if opts.CompareReg != 0 {
+		return nil, fmt.Errorf("cannot compare tuple with register %s", opts.CompareReg)
+	}
+
+	lefts, rights := tupleList(expr.Left), tupleList(expr.Right)
+	if len(lefts) != len(rights) {
+		return nil, fmt.Errorf("tuple of %d elements is compared with %d elements", len(lefts), len(rights))
+	}
+
+	insns := asm.Instructions{
+		asm.Mov.Reg(ctxSaveReg, asm.R1), // r6 = r1
+	}
+
+	for i := range lefts {
+		elem := &cc.Expr{Op: cc.EqEq, Left: lefts[i], Right: rights[i]}
+		if err := validate(elem); err != nil {
+			return nil, fmt.Errorf("failed to validate tuple element %d: %w", i, err)
+		}
+
+		elemInsns, err := compile(elem, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile tuple element %d: %w", i, err)
+		}
+
+		elemInsns, err = chainEqual(elemInsns)
+		if err != nil {
+			return nil, err
+		}
+
+		if i != 0 {
+			insns = append(insns, asm.Mov.Reg(asm.R1, ctxSaveReg)) // r1 = r6
+		}
+		insns = append(insns, elemInsns...)
+	}
+
+	insns = append(insns,
+		asm.Mov.Imm(asm.R0, 1),                                // r0 = 1
+		asm.Ja.Label(labelReturn),                             // goto __return
+		asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail), // r0 = 0; __exit
+		asm.Return().WithSymbol(labelReturn),                  // return; __return
+	)
+
+	return insns, nil
+}
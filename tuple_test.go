@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestIsTuple(t *testing.T) {
+	expr, err := parse("(iph->saddr, iph->daddr) == (1, 2)")
+	test.AssertNoErr(t, err)
+	test.AssertTrue(t, isTuple(expr))
+	test.AssertEqual(t, len(tupleList(expr.Right)), 2)
+
+	expr, err = parse("(iph->saddr) == 1")
+	test.AssertNoErr(t, err)
+	test.AssertFalse(t, isTuple(expr))
+}
+
+func TestChainEqual(t *testing.T) {
+	t.Run("equality", func(t *testing.T) {
+		insns, err := chainEqual(asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 1, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.DWord),
+			asm.JNE.Imm(asm.R3, 1, labelExitFail),
+		})
+	})
+
+	t.Run("string", func(t *testing.T) {
+		insns, err := chainEqual(asm.Instructions{
+			asm.JNE.Imm(asm.R3, 0, labelExitFail),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Ja.Label(labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.JNE.Imm(asm.R3, 0, labelExitFail),
+		})
+	})
+
+	t.Run("constant verdict", func(t *testing.T) {
+		_, err := chainEqual(verdict2insns(true))
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected instructions of tuple element")
+	})
+}
+
+func TestCompileTuple(t *testing.T) {
+	t.Run("(iph->saddr, iph->daddr) == (10.0.0.1, 10.0.0.2)", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr: "(iph->saddr, iph->daddr) == (10.0.0.1, 10.0.0.2)",
+			Type: getIphdrBtf(t),
+		})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Reg(asm.R6, asm.R1),
+
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 12),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.Word),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.JNE.Imm(asm.R3, int32(h2nl(0x0a000001, isBigEndian(nativeEndian))), labelExitFail),
+
+			asm.Mov.Reg(asm.R1, asm.R6),
+			asm.Mov.Reg(asm.R3, asm.R1),
+			asm.Add.Imm(asm.R3, 16),
+			asm.Mov.Imm(asm.R2, 8),
+			asm.Mov.Reg(asm.R1, asm.R10),
+			asm.Add.Imm(asm.R1, -8),
+			asm.FnProbeReadKernel.Call(),
+			asm.LoadMem(asm.R3, asm.R10, -8, asm.Word),
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.JNE.Imm(asm.R3, int32(h2nl(0x0a000002, isBigEndian(nativeEndian))), labelExitFail),
+
+			asm.Mov.Imm(asm.R0, 1),
+			asm.Ja.Label(labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("length mismatch", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "(iph->saddr, iph->daddr) == (1, 2, 3)", Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+
+	t.Run("invalid operator", func(t *testing.T) {
+		_, err := compileTuple(parseTuple(t, "(iph->saddr, iph->daddr) > (1, 2)"), CompileOptions{Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected operator")
+	})
+
+	t.Run("invalid element", func(t *testing.T) {
+		_, err := compileTuple(parseTuple(t, "(iph->saddr, a+b) == (1, 2)"), CompileOptions{Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate tuple element 1")
+	})
+
+	t.Run("unknown member", func(t *testing.T) {
+		_, err := compileTuple(parseTuple(t, "(iph->saddr, iph->xxx) == (1, 2)"), CompileOptions{Type: getIphdrBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile tuple element 1")
+	})
+
+	t.Run("compare register", func(t *testing.T) {
+		_, err := compileTuple(parseTuple(t, "(iph->saddr, iph->daddr) == (1, 2)"), CompileOptions{Type: getIphdrBtf(t), CompareReg: asm.R7})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "cannot compare tuple with register")
+	})
+}
+
+func parseTuple(t *testing.T, s string) *cc.Expr {
+	expr, err := parse(s)
+	test.AssertNoErr(t, err)
+	return expr
+}
@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/cilium/ebpf/asm"
+	"rsc.io/c2go/cc"
+)
+
+// isTwiddle reports whether the field is bitwise negated like ~skb->mark.
+func isTwiddle(expr *cc.Expr) bool {
+	return expr != nil && expr.Op == cc.Twid && expr.Left != nil
+}
+
+// twiddle2insns negates the value of bits width in reg, i.e. xors it with the
+// all-ones masked to the width, so the bits beyond the field keep zero. The
+// sign-extended value is negated in 64 bits, which keeps it sign-extended.
+func twiddle2insns(insns asm.Instructions, bits int, signExtended bool, reg asm.Register) asm.Instructions {
+	switch {
+	case signExtended || bits >= 64:
+		return append(insns,
+			asm.Xor.Imm(reg, -1), // reg ^= -1
+		)
+
+	case bits == 32:
+		return append(insns,
+			asm.Xor.Imm32(reg, -1), // (u32)reg ^= -1; zero-extended
+		)
+
+	default:
+		return append(insns,
+			asm.Xor.Imm(reg, int32(uint32(1)<<bits-1)), // reg ^= (1 << bits) - 1
+		)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestTwiddle2insns(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		bits         int
+		signExtended bool
+		exp          asm.Instruction
+	}{
+		{"u8", 8, false, asm.Xor.Imm(asm.R3, 0xff)},
+		{"u16", 16, false, asm.Xor.Imm(asm.R3, 0xffff)},
+		{"u32", 32, false, asm.Xor.Imm32(asm.R3, -1)},
+		{"u64", 64, false, asm.Xor.Imm(asm.R3, -1)},
+		{"bitfield", 3, false, asm.Xor.Imm(asm.R3, 0x7)},
+		{"s32", 32, true, asm.Xor.Imm(asm.R3, -1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			insns := twiddle2insns(nil, tt.bits, tt.signExtended, asm.R3)
+			test.AssertEqualSlice(t, insns, asm.Instructions{tt.exp})
+		})
+	}
+}
+
+func TestCompileTwiddle(t *testing.T) {
+	t.Run("(~skb->mark & 0x1) == 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "(~skb->mark & 0x1) == 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Xor.Imm32(asm.R3, -1),
+			asm.And.Imm(asm.R3, 1),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("(~skb->protocol & 0x800) == 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "(~skb->protocol & 0x800) == 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-7:len(insns)-3], asm.Instructions{
+			asm.And.Imm(asm.R3, 0xffff),
+			asm.Xor.Imm(asm.R3, 0xffff),
+			asm.And.Imm(asm.R3, 0x8),
+			asm.Mov.Imm(asm.R0, 1),
+		})
+	})
+
+	t.Run("~skb->skb_iif == 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "~skb->skb_iif == 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-6:len(insns)-3], asm.Instructions{
+			asm.ArSh.Imm(asm.R3, 32),
+			asm.Xor.Imm(asm.R3, -1),
+			asm.Mov.Imm(asm.R0, 1),
+		})
+	})
+
+	t.Run("invalid operand", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "(~skb->mark() & 0x1) == 0", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to validate expression")
+	})
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+// userTag is the btf type tag of the pointer to user memory like
+// struct msghdr __user *, whose target has to be read by
+// bpf_probe_read_user().
+const userTag = "user"
+
+// isUserPointer reports whether the pointer is tagged with __user. The other
+// tags like __rcu are read from kernel memory.
+func isUserPointer(ptr *btf.Pointer) bool {
+	for typ := ptr.Target; ; {
+		switch v := typ.(type) {
+		case *btf.TypeTag:
+			if v.Value == userTag {
+				return true
+			}
+			typ = v.Type
+		case *btf.Const:
+			typ = v.Type
+		case *btf.Volatile:
+			typ = v.Type
+		case *btf.Restrict:
+			typ = v.Type
+		default:
+			return false
+		}
+	}
+}
+
+// hasUserRead reports whether any offset is read from user memory.
+func (ast *astInfo) hasUserRead() bool {
+	for _, user := range ast.userReads {
+		if user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// userRead2insns replaces the bpf_probe_read_kernel() calls generated by
+// offset2insns with bpf_probe_read_user() in place, whose offsets are read
+// from user memory. The calls are matched with the offsets in order.
+func userRead2insns(insns asm.Instructions, userReads []bool) {
+	k := 0
+	for i := range insns {
+		if k == len(userReads) {
+			return
+		}
+		if !insns[i].IsBuiltinCall() || insns[i].Constant != int64(asm.FnProbeReadKernel) {
+			continue
+		}
+
+		if userReads[k] {
+			insns[i].Constant = int64(asm.FnProbeReadUser)
+		}
+		k++
+	}
+}
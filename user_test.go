@@ -0,0 +1,104 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+// getUserBtf returns the pointer to struct s { struct u __user *uptr;
+// struct u __rcu *rptr; }, where struct u { struct u __user *next; u32 val; }.
+func getUserBtf() *btf.Pointer {
+	u32 := &btf.Int{Name: "unsigned int", Size: 4}
+	u := &btf.Struct{Name: "u", Size: 16}
+	userPtr := &btf.Pointer{Target: &btf.TypeTag{Value: userTag, Type: u}}
+	u.Members = []btf.Member{
+		{Name: "next", Type: userPtr},
+		{Name: "val", Type: u32, Offset: 64},
+	}
+
+	return &btf.Pointer{Target: &btf.Struct{
+		Name: "s",
+		Size: 16,
+		Members: []btf.Member{
+			{Name: "uptr", Type: userPtr},
+			{Name: "rptr", Type: &btf.Pointer{Target: &btf.TypeTag{Value: "rcu", Type: u}}, Offset: 64},
+		},
+	}}
+}
+
+func readHelpers(insns asm.Instructions) []asm.BuiltinFunc {
+	var fns []asm.BuiltinFunc
+	for _, ins := range insns {
+		if ins.IsBuiltinCall() {
+			fns = append(fns, asm.BuiltinFunc(ins.Constant))
+		}
+	}
+
+	return fns
+}
+
+func TestIsUserPointer(t *testing.T) {
+	u := &btf.Struct{Name: "u"}
+	test.AssertTrue(t, isUserPointer(&btf.Pointer{Target: &btf.TypeTag{Value: userTag, Type: u}}))
+	test.AssertTrue(t, isUserPointer(&btf.Pointer{Target: &btf.Const{Type: &btf.TypeTag{Value: userTag, Type: u}}}))
+	test.AssertFalse(t, isUserPointer(&btf.Pointer{Target: &btf.TypeTag{Value: "rcu", Type: u}}))
+	test.AssertFalse(t, isUserPointer(&btf.Pointer{Target: u}))
+}
+
+func TestCompileUserPointer(t *testing.T) {
+	t.Run("s->uptr->next->val == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "s->uptr->next->val == 1", Type: getUserBtf()})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, readHelpers(res.Insns), []asm.BuiltinFunc{
+			asm.FnProbeReadKernel,
+			asm.FnProbeReadUser,
+			asm.FnProbeReadUser,
+		})
+	})
+
+	t.Run("s->rptr->val == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "s->rptr->val == 1", Type: getUserBtf()})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, readHelpers(res.Insns), []asm.BuiltinFunc{
+			asm.FnProbeReadKernel,
+			asm.FnProbeReadKernel,
+		})
+	})
+
+	t.Run("read helper", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "s->uptr->val == 1", Type: getUserBtf(), ReadHelper: asm.FnProbeRead})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, readHelpers(res.Insns), []asm.BuiltinFunc{
+			asm.FnProbeRead,
+			asm.FnProbeReadUser,
+		})
+	})
+
+	t.Run("access", func(t *testing.T) {
+		res, err := Access(AccessOptions{
+			Expr:      "s->uptr->val",
+			Type:      getUserBtf(),
+			Src:       asm.R1,
+			Dst:       asm.R3,
+			LabelExit: labelExitFail,
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, readHelpers(res.Insns), []asm.BuiltinFunc{
+			asm.FnProbeReadKernel,
+			asm.FnProbeReadUser,
+		})
+	})
+
+	t.Run("direct load", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "s->uptr->val == 1", Type: getUserBtf(), UseDirectLoad: true})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to compile expression")
+	})
+}
@@ -9,7 +9,30 @@ import (
 	"rsc.io/c2go/cc"
 )
 
+// modifyingOperators are the assignment and increment/decrement operators,
+// which modify the left operand and are usually written by accident.
+var modifyingOperators = map[cc.ExprOp]string{
+	cc.AddEq:   "+=",
+	cc.SubEq:   "-=",
+	cc.MulEq:   "*=",
+	cc.DivEq:   "/=",
+	cc.ModEq:   "%=",
+	cc.AndEq:   "&=",
+	cc.OrEq:    "|=",
+	cc.XorEq:   "^=",
+	cc.LshEq:   "<<=",
+	cc.RshEq:   ">>=",
+	cc.PreInc:  "++",
+	cc.PostInc: "++",
+	cc.PreDec:  "--",
+	cc.PostDec: "--",
+}
+
 func validateOperator(op cc.ExprOp) error {
+	if sym, ok := modifyingOperators[op]; ok {
+		return fmt.Errorf("operator '%s' modifies the left operand, which is not allowed; only comparison operators are supported: =, ==, !=, <, <=, >, >=", sym)
+	}
+
 	switch op {
 	case cc.Eq, cc.EqEq, cc.NotEq, cc.Lt, cc.LtEq, cc.Gt, cc.GtEq:
 		return nil
@@ -19,7 +42,8 @@ func validateOperator(op cc.ExprOp) error {
 }
 
 // validateLeftOperand checks if the left operand is struct member access like:
-// [[skb] -> dev] -> ifindex
+// [[skb] -> dev] -> ifindex, or array access with constant index like:
+// [[skb] -> cb] [0]
 func validateLeftOperand(left *cc.Expr) error {
 	if left == nil {
 		return nil
@@ -29,6 +53,114 @@ func validateLeftOperand(left *cc.Expr) error {
 		return nil
 	}
 
+	if left.Op == cc.Indir {
+		// *(unsigned int *)(skb - 8)
+		_, err := rawAccess(left)
+		return err
+	}
+
+	if isTwiddle(left) {
+		// ~skb->mark
+		return validateLeftOperand(left.Left)
+	}
+
+	if left.Op == cc.Div || left.Op == cc.Mod {
+		// skb->len / 64 or skb->data % 8
+		return validateDiv(left)
+	}
+
+	if isFieldMask(left) {
+		// (skb->flags & skb->mask)
+		return validateFieldMask(left)
+	}
+
+	if isMask(left) {
+		// (skb->mark & 0xff)
+		return validateMask(left)
+	}
+
+	if isPopcount(left) {
+		// popcount(skb->mark)
+		return validatePopcount(left)
+	}
+
+	if isDiff(left) {
+		// skb->end - skb->head
+		return validateDiff(left)
+	}
+
+	if isBits(left) {
+		// bits(*(unsigned int *)(skb + 4), 3, 7)
+		return validateBits(left)
+	}
+
+	if isPid(left) {
+		// pid()
+		return validatePid(left)
+	}
+
+	if isByte(left) {
+		// byte(skb->protocol, 0)
+		return validateByte(left)
+	}
+
+	if isHash(left) {
+		// hash(skb->data, 0, 14)
+		return validateHash(left)
+	}
+
+	if isSatAdd(left) {
+		// sat_add(skb->len, 10)
+		return validateSatAdd(left)
+	}
+
+	if isTranslate(left) {
+		// map(skb->protocol, {1:1, 6:2})
+		return validateTranslate(left)
+	}
+
+	if isAbs(left) {
+		// abs(skb->skb_iif)
+		return validateAbs(left)
+	}
+
+	if left.Op == cc.Call {
+		// container_of(ptr, type, member)
+		return validateContainerOf(left)
+	}
+
+	if left.Op == cc.Cast {
+		// (unsigned short)hdr->field
+		return validateLeftOperand(left.Left)
+	}
+
+	if left.Op == cc.SizeofExpr {
+		// sizeof(skb->len) or sizeof skb->len
+		if left.Left != nil && left.Left.Op == cc.Paren {
+			return validateLeftOperand(left.Left.Left)
+		}
+
+		return validateLeftOperand(left.Left)
+	}
+
+	if isDynamicIndex(left) {
+		// skb->cb[skb->queue_mapping]
+		return validateDynamicIndex(left)
+	}
+
+	if left.Op == cc.Index {
+		index := left.Right
+		if index != nil && index.Op == cc.Minus {
+			// skb->cb[-1]
+			index = index.Left
+		}
+		if left.Left == nil || index == nil || index.Op != cc.Number {
+			return fmt.Errorf("unexpected array access: %v; index must be constant number", left)
+		}
+
+		return validateLeftOperand(left.Left)
+	}
+
 	if left.Right != nil {
 		return fmt.Errorf("left operand must be struct member access")
 	}
@@ -41,26 +173,56 @@ func validateLeftOperand(left *cc.Expr) error {
 }
 
 func validateRightOperand(right *cc.Expr) error {
-	if right.Op != cc.Number && right.Op != cc.Name {
-		return fmt.Errorf("expect constant number or enum as right operand, got %s", right.Text)
+	if isNow(right) {
+		// skb->tstamp < now()
+		return validateNow(right)
 	}
 
-	if right.Op == cc.Name {
+	switch right.Op {
+	case cc.Name:
 		return nil
-	}
 
-	if _, err := parseNumber(right.Text); err != nil {
-		return fmt.Errorf("right operand is not a number: %w", err)
-	}
+	case cc.Number:
+		if _, err := parseNumber(right.Text); err != nil {
+			return fmt.Errorf("right operand is not a number: %w", err)
+		}
+		return nil
 
-	return nil
+	case cc.Minus:
+		// -1
+		if right.Left == nil || right.Left.Op != cc.Number {
+			return fmt.Errorf("expect negative constant number as right operand, got %v", right)
+		}
+		return validateRightOperand(right.Left)
+
+	case cc.Twid:
+		// ~0x3
+		if right.Left == nil || right.Left.Op != cc.Number {
+			return fmt.Errorf("expect complemented constant number as right operand, got %v", right)
+		}
+		return validateRightOperand(right.Left)
+
+	case cc.Paren:
+		return validateRightOperand(right.Left)
+
+	case cc.Or:
+		if err := validateRightOperand(right.Left); err != nil {
+			return err
+		}
+		return validateRightOperand(right.Right)
+
+	default:
+		return fmt.Errorf("expect constant number or enum as right operand, got %s", right.Text)
+	}
 }
 
 // validate checks if the expression is expected simple C expression by
 // checking:
 // 1. The top level operator is one of the following: =, ==, !=, <, <=, >, >=
 // 2. The left operand is struct member access
-// 3. The right operand is a constant number in hex, octal, or decimal format
+// 3. The right operand is a constant number in hex, octal, or decimal format,
+// or an enum name, or bitwise OR of them like (FLAG_A | FLAG_B), or a string
+// literal compared with a char array or const char pointer
 func validate(expr *cc.Expr) error {
 	if err := validateOperator(expr.Op); err != nil {
 		return err
@@ -76,7 +238,12 @@ func validate(expr *cc.Expr) error {
 	if expr.Right == nil {
 		return fmt.Errorf("right operand is missing")
 	}
-	if err := validateRightOperand(expr.Right); err != nil {
+	if expr.Right.Op == cc.String {
+		// dev->name == "lo"
+		if _, err := unquoteString(expr.Right.Texts); err != nil {
+			return fmt.Errorf("right operand is not a valid string literal: %w", err)
+		}
+	} else if err := validateRightOperand(expr.Right); err != nil {
 		return err
 	}
 
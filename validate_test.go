@@ -35,6 +35,29 @@ func TestValidateOperator(t *testing.T) {
 	}
 }
 
+func TestValidateModifyingOperator(t *testing.T) {
+	tests := []struct {
+		expr string
+		sym  string
+	}{
+		{expr: "skb->len += 1", sym: "+="},
+		{expr: "skb->len <<= 1", sym: "<<="},
+		{expr: "skb->len++", sym: "++"},
+		{expr: "--skb->len", sym: "--"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parse(tt.expr)
+			test.AssertNoErr(t, err)
+
+			err = validate(expr)
+			test.AssertHaveErr(t, err)
+			test.AssertStrPrefix(t, err.Error(), "operator '"+tt.sym+"' modifies the left operand")
+		})
+	}
+}
+
 func TestValidateLeftOperand(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -48,6 +71,12 @@ func TestValidateLeftOperand(t *testing.T) {
 		{name: "arrow", left: &cc.Expr{Op: cc.Arrow}, valid: true},
 		{name: "number op", left: &cc.Expr{Op: cc.Number, Left: &cc.Expr{}}, valid: false},
 		{name: "skb->dev", left: &cc.Expr{Op: cc.Arrow, Text: "dev", Left: &cc.Expr{Text: "skb"}}, valid: true},
+		{name: "skb->cb[0]", left: &cc.Expr{Op: cc.Index, Left: &cc.Expr{Op: cc.Arrow, Text: "cb", Left: &cc.Expr{Text: "skb"}}, Right: &cc.Expr{Op: cc.Number, Text: "0"}}, valid: true},
+		{name: "skb->cb[i]", left: &cc.Expr{Op: cc.Index, Left: &cc.Expr{Op: cc.Arrow, Text: "cb", Left: &cc.Expr{Text: "skb"}}, Right: &cc.Expr{Op: cc.Name, Text: "i"}}, valid: false},
+		{name: "*skb", left: &cc.Expr{Op: cc.Indir, Left: &cc.Expr{Op: cc.Name, Text: "skb"}}, valid: false},
+		{name: "(unsigned short)skb->len", left: &cc.Expr{Op: cc.Cast, Left: &cc.Expr{Op: cc.Arrow, Text: "len", Left: &cc.Expr{Text: "skb"}}}, valid: true},
+		{name: "sizeof(skb->len)", left: &cc.Expr{Op: cc.SizeofExpr, Left: &cc.Expr{Op: cc.Paren, Left: &cc.Expr{Op: cc.Arrow, Text: "len", Left: &cc.Expr{Text: "skb"}}}}, valid: true},
+		{name: "sizeof(a == b)", left: &cc.Expr{Op: cc.SizeofExpr, Left: &cc.Expr{Op: cc.Paren, Left: &cc.Expr{Op: cc.EqEq, Left: &cc.Expr{Text: "a"}, Right: &cc.Expr{Text: "b"}}}}, valid: false},
 		{name: "a == b", left: &cc.Expr{Op: cc.EqEq, Left: &cc.Expr{Text: "a"}, Right: &cc.Expr{Text: "b"}}, valid: false},
 	}
 
@@ -68,6 +97,11 @@ func TestValidateRightOperand(t *testing.T) {
 		{name: "number", right: &cc.Expr{Op: cc.Number, Text: "0x1234"}, valid: true},
 		{name: "invalid number", right: &cc.Expr{Op: cc.Number, Text: "1234a"}, valid: false},
 		{name: "name", right: &cc.Expr{Op: cc.Name, Text: "skb"}, valid: true},
+		{name: "or", right: &cc.Expr{Op: cc.Or, Left: &cc.Expr{Op: cc.Name, Text: "A"}, Right: &cc.Expr{Op: cc.Number, Text: "0x4"}}, valid: true},
+		{name: "paren", right: &cc.Expr{Op: cc.Paren, Left: &cc.Expr{Op: cc.Number, Text: "0x4"}}, valid: true},
+		{name: "invalid or", right: &cc.Expr{Op: cc.Or, Left: &cc.Expr{Op: cc.Name, Text: "A"}, Right: &cc.Expr{Op: cc.Add}}, valid: false},
+		{name: "twid", right: &cc.Expr{Op: cc.Twid, Left: &cc.Expr{Op: cc.Number, Text: "0x3"}}, valid: true},
+		{name: "twid name", right: &cc.Expr{Op: cc.Twid, Left: &cc.Expr{Op: cc.Name, Text: "A"}}, valid: false},
 		{name: "add", right: &cc.Expr{Op: cc.Add}, valid: false},
 	}
 
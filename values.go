@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// valueSigil prefixes the name of the externally provided value like $max_len.
+const valueSigil = '$'
+
+// substituteValues substitutes the externally provided values for the names
+// prefixed by $ like $max_len in the expression, which cannot be parsed by cc.
+// The $ in the string and char literals is kept as is, and the unknown name is
+// rejected.
+func substituteValues(expr string, values map[string]uint64) (string, error) {
+	if !strings.ContainsRune(expr, valueSigil) {
+		return expr, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch c {
+		case '"', '\'':
+			j := literalEnd(expr, i)
+			sb.WriteString(expr[i:j])
+			i = j
+
+		case valueSigil:
+			j := i + 1
+			for j < len(expr) && isIdentByte(expr[j]) {
+				j++
+			}
+
+			name := expr[i+1 : j]
+			if name == "" || ('0' <= name[0] && name[0] <= '9') {
+				return "", fmt.Errorf("unexpected %c at %d; must be followed by value name", valueSigil, i)
+			}
+
+			val, ok := values[name]
+			if !ok {
+				return "", fmt.Errorf("unknown value %c%s", valueSigil, name)
+			}
+			sb.WriteString(strconv.FormatUint(val, 10))
+			i = j
+
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestSubstituteValues(t *testing.T) {
+	values := map[string]uint64{"max_len": 1500, "mark": 7}
+
+	tests := []struct {
+		expr string
+		exp  string
+		err  string
+	}{
+		{expr: "skb->len > 1500", exp: "skb->len > 1500"},
+		{expr: "skb->len > $max_len", exp: "skb->len > 1500"},
+		{expr: "skb->mark == $mark", exp: "skb->mark == 7"},
+		{expr: "dev->name == \"$mark\"", exp: "dev->name == \"$mark\""},
+		{expr: "skb->len > $min_len", err: "unknown value $min_len"},
+		{expr: "skb->len > $", err: "unexpected $ at 11"},
+		{expr: "skb->len > $1", err: "unexpected $ at 11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := substituteValues(tt.expr, values)
+			if tt.err != "" {
+				test.AssertHaveErr(t, err)
+				test.AssertStrPrefix(t, err.Error(), tt.err)
+				return
+			}
+
+			test.AssertNoErr(t, err)
+			test.AssertEqual(t, got, tt.exp)
+		})
+	}
+}
+
+func TestCompileValues(t *testing.T) {
+	t.Run("skb->len > $max_len", func(t *testing.T) {
+		res, err := Compile(CompileOptions{
+			Expr:   "skb->len > $max_len",
+			Type:   getSkbBtf(t),
+			Values: map[string]uint64{"max_len": 1500},
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, res.Constant, uint64(1500))
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-3:], asm.Instructions{
+			asm.JGT.Imm(asm.R3, 1500, labelReturn),
+			asm.Xor.Reg(asm.R0, asm.R0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("unknown value", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len > $max_len", Type: getSkbBtf(t)})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to substitute values of expression(skb->len > $max_len): unknown value $max_len")
+	})
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"fmt"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+)
+
+// verdict2insns returns the instructions returning the verdict determined at
+// compile time.
+func verdict2insns(match bool) asm.Instructions {
+	var verdict int32
+	if match {
+		verdict = 1
+	}
+
+	return asm.Instructions{
+		asm.Mov.Imm(asm.R0, verdict),         // r0 = verdict
+		asm.Return().WithSymbol(labelReturn), // return; __return
+	}
+}
+
+// foldUnsignedZero folds the boundary comparisons with zero on unsigned
+// fields to constant verdicts, i.e. >= 0 always matches and < 0 never
+// matches.
+func foldUnsignedZero(op cc.ExprOp, constant uint64, typ btf.Type) (match, ok bool) {
+	if constant != 0 {
+		return false, false
+	}
+
+	if isSignedType(typ) {
+		return false, false
+	}
+
+	switch op {
+	case cc.GtEq:
+		return true, true
+	case cc.Lt:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// invertVerdict swaps the verdicts of the compiled instructions, i.e. r0 = 0
+// if matched and r0 = 1 if not, without changing the comparison logic. The
+// symbols of the verdict instructions are kept for the jumps.
+func invertVerdict(insns asm.Instructions) asm.Instructions {
+	for i, ins := range insns {
+		if ins.Dst != asm.R0 {
+			continue
+		}
+
+		switch {
+		case ins.OpCode == asm.Mov.Op(asm.ImmSource) && ins.Constant == 1:
+			insns[i].Constant = 0 // r0 = 1 => r0 = 0
+
+		case ins.OpCode == asm.Mov.Op(asm.ImmSource) && ins.Constant == 0:
+			insns[i].Constant = 1 // r0 = 0 => r0 = 1
+
+		case ins.OpCode == asm.Xor.Op(asm.RegSource) && ins.Src == asm.R0:
+			insns[i] = asm.Mov.Imm(asm.R0, 1).WithMetadata(ins.Metadata) // r0 ^= r0 => r0 = 1
+		}
+	}
+
+	return insns
+}
+
+// selectVerdict replaces the verdicts of the compiled instructions with the
+// given values, i.e. r0 = match if matched and r0 = mismatch if not. The
+// symbols of the verdict instructions are kept for the jumps.
+func selectVerdict(insns asm.Instructions, match, mismatch int32) asm.Instructions {
+	for i, ins := range insns {
+		if ins.Dst != asm.R0 {
+			continue
+		}
+
+		switch {
+		case ins.OpCode == asm.Mov.Op(asm.ImmSource) && ins.Constant == 1:
+			insns[i].Constant = int64(match) // r0 = 1 => r0 = match
+
+		case ins.OpCode == asm.Mov.Op(asm.ImmSource) && ins.Constant == 0:
+			insns[i].Constant = int64(mismatch) // r0 = 0 => r0 = mismatch
+
+		case ins.OpCode == asm.Xor.Op(asm.RegSource) && ins.Src == asm.R0:
+			insns[i] = asm.Mov.Imm(asm.R0, mismatch).WithMetadata(ins.Metadata) // r0 ^= r0 => r0 = mismatch
+		}
+	}
+
+	return insns
+}
+
+func validateVerdictReg(reg asm.Register) error {
+	if reg < asm.R6 || reg > asm.R9 {
+		return fmt.Errorf("invalid verdict register %s; must be one of r6-r9", reg)
+	}
+
+	return nil
+}
+
+// verdictToReg moves the verdict to reg instead of returning it, i.e. the last
+// return carrying the symbol of __return is replaced with reg = r0, so that
+// the instructions fall through to the surrounding program. The reg must not
+// be clobbered by the instructions, see clobbersReg.
+func verdictToReg(insns asm.Instructions, reg asm.Register) (asm.Instructions, error) {
+	if clobbersReg(insns, reg) {
+		return nil, fmt.Errorf("verdict register %s is clobbered by the compiled instructions", reg)
+	}
+
+	for i, ins := range insns {
+		if ins.OpCode.JumpOp() == asm.Exit && i != len(insns)-1 {
+			return nil, fmt.Errorf("unexpected return at insn %d; must be the last one", i)
+		}
+	}
+
+	last := insns[len(insns)-1]
+	if last.OpCode.JumpOp() != asm.Exit {
+		return nil, fmt.Errorf("unexpected last instruction %v; must be return", last)
+	}
+
+	insns[len(insns)-1] = asm.Mov.Reg(reg, asm.R0).WithMetadata(last.Metadata) // reg = r0; __return
+	return insns, nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+	"rsc.io/c2go/cc"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestInvertVerdict(t *testing.T) {
+	t.Run("skb->len > 1024", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), InvertVerdict: true})
+		test.AssertNoErr(t, err)
+
+		want := cloneSkbLen1024InsnsWithoutExitLabel()
+		want[len(want)-4] = asm.Mov.Imm(asm.R0, 0)
+		want[len(want)-2] = asm.Mov.Imm(asm.R0, 1)
+		test.AssertEqualSlice(t, res.Insns, want)
+	})
+
+	t.Run("keep symbol", func(t *testing.T) {
+		insns := invertVerdict(asm.Instructions{
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.JEq.Imm(asm.R3, 0, labelExitFail),
+			asm.Mov.Imm(asm.R0, 1).WithSymbol(labelExitFail),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("sizeof", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "sizeof(skb->len) == 4", Type: getSkbBtf(t), InvertVerdict: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("unrelated registers", func(t *testing.T) {
+		insns := invertVerdict(asm.Instructions{
+			asm.Mov.Imm(asm.R2, 1),
+			asm.Xor.Reg(asm.R3, asm.R3),
+		})
+		test.AssertEqualSlice(t, insns, asm.Instructions{
+			asm.Mov.Imm(asm.R2, 1),
+			asm.Xor.Reg(asm.R3, asm.R3),
+		})
+	})
+}
+
+func TestFoldUnsignedZero(t *testing.T) {
+	u32 := &btf.Int{Name: "u32", Size: 4}
+	s32 := &btf.Int{Name: "s32", Size: 4, Encoding: btf.Signed}
+
+	tests := []struct {
+		name     string
+		op       cc.ExprOp
+		constant uint64
+		typ      btf.Type
+		match    bool
+		ok       bool
+	}{
+		{name: "u32 >= 0", op: cc.GtEq, typ: u32, match: true, ok: true},
+		{name: "u32 < 0", op: cc.Lt, typ: u32, match: false, ok: true},
+		{name: "typedef u32 >= 0", op: cc.GtEq, typ: &btf.Typedef{Name: "__u32", Type: u32}, match: true, ok: true},
+		{name: "nil >= 0", op: cc.GtEq, match: true, ok: true},
+		{name: "u32 >= 1", op: cc.GtEq, constant: 1, typ: u32},
+		{name: "u32 <= 0", op: cc.LtEq, typ: u32},
+		{name: "s32 >= 0", op: cc.GtEq, typ: s32},
+		{name: "typedef s32 < 0", op: cc.Lt, typ: &btf.Typedef{Name: "__s32", Type: s32}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, ok := foldUnsignedZero(tt.op, tt.constant, tt.typ)
+			test.AssertEqual(t, ok, tt.ok)
+			test.AssertEqual(t, match, tt.match)
+		})
+	}
+}
+
+func TestCompileUnsignedZero(t *testing.T) {
+	t.Run("skb->len >= 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len >= 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, verdict2insns(true))
+	})
+
+	t.Run("skb->len < 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len < 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, asm.Instructions{
+			asm.Mov.Imm(asm.R0, 0),
+			asm.Return().WithSymbol(labelReturn),
+		})
+	})
+
+	t.Run("signed skb->dev->ifindex >= 0", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->dev->ifindex >= 0", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+		test.AssertTrue(t, len(res.Insns) > 2)
+	})
+}
+
+func TestSelectVerdict(t *testing.T) {
+	insns := selectVerdict(asm.Instructions{
+		asm.Mov.Imm(asm.R0, 1),
+		asm.JEq.Imm(asm.R3, 0, labelExitFail),
+		asm.Mov.Imm(asm.R3, 1),
+		asm.Mov.Imm(asm.R0, 0),
+		asm.Xor.Reg(asm.R0, asm.R0).WithSymbol(labelExitFail),
+		asm.Return().WithSymbol(labelReturn),
+	}, 2, 3)
+	test.AssertEqualSlice(t, insns, asm.Instructions{
+		asm.Mov.Imm(asm.R0, 2),
+		asm.JEq.Imm(asm.R3, 0, labelExitFail),
+		asm.Mov.Imm(asm.R3, 1),
+		asm.Mov.Imm(asm.R0, 3),
+		asm.Mov.Imm(asm.R0, 3).WithSymbol(labelExitFail),
+		asm.Return().WithSymbol(labelReturn),
+	})
+}
+
+func TestVerdictReg(t *testing.T) {
+	t.Run("skb->len > 1024", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), VerdictReg: asm.R7})
+		test.AssertNoErr(t, err)
+
+		want := cloneSkbLen1024InsnsWithoutExitLabel()
+		want[len(want)-1] = asm.Mov.Reg(asm.R7, asm.R0).WithSymbol(labelReturn)
+		test.AssertEqualSlice(t, res.Insns, want)
+
+		for _, ins := range res.Insns {
+			test.AssertFalse(t, ins.OpCode.JumpOp() == asm.Exit)
+		}
+	})
+
+	t.Run("invalid register", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "skb->len > 1024", Type: getSkbBtf(t), VerdictReg: asm.R3})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "invalid verdict register r3")
+	})
+
+	t.Run("clobbered register", func(t *testing.T) {
+		_, err := Compile(CompileOptions{Expr: "(iph->saddr, iph->daddr) == (10.0.0.1, 10.0.0.2)", Type: getIphdrBtf(t), VerdictReg: asm.R6})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "failed to put verdict")
+
+		_, err = Compile(CompileOptions{Expr: "skb->end - skb->tail > 2048", Type: getSkbBtf(t), VerdictReg: asm.R8})
+		test.AssertHaveErr(t, err)
+
+		_, err = Compile(CompileOptions{Expr: "skb->end - skb->tail > 2048", Type: getSkbBtf(t), VerdictReg: asm.R9})
+		test.AssertNoErr(t, err)
+	})
+
+	t.Run("return in the middle", func(t *testing.T) {
+		_, err := verdictToReg(asm.Instructions{
+			asm.Return(),
+			asm.Return().WithSymbol(labelReturn),
+		}, asm.R7)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected return at insn 0")
+	})
+}
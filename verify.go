@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+)
+
+// unresolvedLabel returns the label referenced by the instructions but not
+// defined by them, which is the exit label of Access.
+func unresolvedLabel(insns asm.Instructions) (string, error) {
+	symbols := make(map[string]struct{})
+	for _, ins := range insns {
+		if sym := ins.Symbol(); sym != "" {
+			symbols[sym] = struct{}{}
+		}
+	}
+
+	var label string
+	for _, ins := range insns {
+		ref := ins.Reference()
+		if ref == "" || ins.IsLoadFromMap() {
+			continue
+		}
+		if _, ok := symbols[ref]; ok || ref == label {
+			continue
+		}
+		if label != "" {
+			return "", fmt.Errorf("unexpected labels %s and %s to resolve", label, ref)
+		}
+		label = ref
+	}
+
+	return label, nil
+}
+
+// Verify wraps the instructions of Access into a minimal program of the type
+// returning 0, and loads it to check the instructions against the verifier,
+// whose log is returned on failure. It requires the privilege to load bpf
+// programs.
+func Verify(res AccessResult, progType ebpf.ProgramType) error {
+	if len(res.Insns) == 0 {
+		return fmt.Errorf("no instructions to verify")
+	}
+
+	insns := slices.Clone(res.Insns)
+	exit, err := unresolvedLabel(insns)
+	if err != nil {
+		return err
+	}
+
+	ret := asm.Mov.Imm(asm.R0, 0) // r0 = 0
+	if exit != "" {
+		ret = ret.WithSymbol(exit)
+	}
+	insns = append(insns,
+		ret,
+		asm.Return(), // return
+	)
+
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Name:         "bice_verify",
+		Type:         progType,
+		Instructions: insns,
+		License:      "GPL",
+	})
+	if err != nil {
+		var ve *ebpf.VerifierError
+		if errors.As(err, &ve) {
+			return fmt.Errorf("filter is rejected by verifier: %w\n%s", err, strings.Join(ve.Log, "\n"))
+		}
+		return fmt.Errorf("failed to load filter: %w", err)
+	}
+
+	return prog.Close()
+}
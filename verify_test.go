@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/features"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestUnresolvedLabel(t *testing.T) {
+	t.Run("exit label", func(t *testing.T) {
+		label, err := unresolvedLabel(asm.Instructions{
+			asm.JEq.Imm(asm.R3, 0, "exit"),
+			asm.JEq.Imm(asm.R3, 1, "exit"),
+			asm.Ja.Label("next"),
+			asm.Mov.Reg(asm.R3, asm.R3).WithSymbol("next"),
+		})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, label, "exit")
+	})
+
+	t.Run("none", func(t *testing.T) {
+		label, err := unresolvedLabel(asm.Instructions{asm.Mov.Reg(asm.R3, asm.R1)})
+		test.AssertNoErr(t, err)
+		test.AssertEqual(t, label, "")
+	})
+
+	t.Run("multiple labels", func(t *testing.T) {
+		_, err := unresolvedLabel(asm.Instructions{
+			asm.JEq.Imm(asm.R3, 0, "exit"),
+			asm.JEq.Imm(asm.R3, 1, "fail"),
+		})
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "unexpected labels")
+	})
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("no instructions", func(t *testing.T) {
+		err := Verify(AccessResult{}, ebpf.Kprobe)
+		test.AssertHaveErr(t, err)
+		test.AssertEqual(t, err.Error(), "no instructions to verify")
+	})
+
+	if err := features.HaveProgramType(ebpf.Kprobe); err != nil {
+		t.Skipf("kprobe program is not supported: %v", err)
+	}
+
+	t.Run("skb->dev->ifindex", func(t *testing.T) {
+		res, err := Access(AccessOptions{
+			Expr:      "skb->dev->ifindex",
+			Type:      getSkbBtf(t),
+			Src:       asm.R1,
+			Dst:       asm.R3,
+			LabelExit: labelExitFail,
+		})
+		test.AssertNoErr(t, err)
+
+		err = Verify(res, ebpf.Kprobe)
+		test.AssertNoErr(t, err)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		err := Verify(AccessResult{Insns: asm.Instructions{
+			asm.LoadMem(asm.R3, asm.R4, 0, asm.DWord), // r4 is uninitialized
+		}}, ebpf.Kprobe)
+		test.AssertHaveErr(t, err)
+		test.AssertStrPrefix(t, err.Error(), "filter is rejected by verifier")
+	})
+}
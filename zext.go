@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+/* Copyright Leon Hwang */
+
+package bice
+
+import "github.com/cilium/ebpf/asm"
+
+// zext2insns zero-extends the value of size bytes in reg explicitly, which
+// bounds it to the width for the verifier tracking the range of the value
+// used in the subsequent arithmetic or as index. The 8-byte value is
+// unbounded.
+func zext2insns(insns asm.Instructions, size int, reg asm.Register) asm.Instructions {
+	switch size {
+	case 1:
+		insns = append(insns,
+			asm.And.Imm(reg, 0xFF), // reg &= 0xff
+		)
+	case 2:
+		insns = append(insns,
+			asm.And.Imm(reg, 0xFFFF), // reg &= 0xffff
+		)
+	case 4:
+		insns = append(insns,
+			asm.Mov.Reg32(reg, reg), // wreg = wreg
+		)
+	}
+
+	return insns
+}
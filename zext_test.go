@@ -0,0 +1,56 @@
+// Copyright 2025 Leon Hwang.
+// SPDX-License-Identifier: Apache-2.0
+
+package bice
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+
+	"github.com/leonhwangprojects/bice/internal/test"
+)
+
+func TestZext2insns(t *testing.T) {
+	test.AssertEqualSlice(t, zext2insns(nil, 1, asm.R3), asm.Instructions{asm.And.Imm(asm.R3, 0xFF)})
+	test.AssertEqualSlice(t, zext2insns(nil, 2, asm.R3), asm.Instructions{asm.And.Imm(asm.R3, 0xFFFF)})
+	test.AssertEqualSlice(t, zext2insns(nil, 4, asm.R3), asm.Instructions{asm.Mov.Reg32(asm.R3, asm.R3)})
+	test.AssertEmptySlice(t, zext2insns(nil, 8, asm.R3))
+}
+
+func TestCompileZeroExtend(t *testing.T) {
+	t.Run("skb->len / 100 > 10", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->len / 100 > 10", Type: getSkbBtf(t), ZeroExtend: true})
+		test.AssertNoErr(t, err)
+
+		insns := res.Insns
+		test.AssertEqualSlice(t, insns[len(insns)-8:len(insns)-2], asm.Instructions{
+			asm.LSh.Imm(asm.R3, 32),
+			asm.RSh.Imm(asm.R3, 32),
+			asm.Mov.Reg32(asm.R3, asm.R3),
+			asm.Div.Imm(asm.R3, 100),
+			asm.Mov.Imm(asm.R0, 1),
+			asm.JGT.Imm(asm.R3, 10, labelReturn),
+		})
+	})
+
+	t.Run("skb->len > 10", func(t *testing.T) {
+		exp, err := Compile(CompileOptions{Expr: "skb->len > 10", Type: getSkbBtf(t)})
+		test.AssertNoErr(t, err)
+
+		res, err := Compile(CompileOptions{Expr: "skb->len > 10", Type: getSkbBtf(t), ZeroExtend: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns, exp.Insns)
+	})
+
+	t.Run("skb->cb[skb->queue_mapping] == 1", func(t *testing.T) {
+		res, err := Compile(CompileOptions{Expr: "skb->cb[skb->queue_mapping] == 1", Type: getSkbBtf(t), ZeroExtend: true})
+		test.AssertNoErr(t, err)
+		test.AssertEqualSlice(t, res.Insns[13:17], asm.Instructions{
+			asm.Add.Imm(asm.R3, 40),
+			asm.LoadMem(asm.R2, asm.R10, -24, asm.DWord),
+			asm.And.Imm(asm.R2, 0x3F),
+			asm.Add.Reg(asm.R3, asm.R2),
+		})
+	})
+}